@@ -0,0 +1,51 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"bytes"
+	"compress/gzip"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// compressionSampleSize is the maximum number of leading bytes
+// of an encoded message compressed by EstimateCompressionRatio.
+const compressionSampleSize = 64 << 10
+
+// EstimateCompressionRatio returns the size of the gzipped encoding of m divided by
+// the size of its encoding, letting senders decide whether compressing is worthwhile:
+// the lower the ratio, the more gzip helps. To bound the cost of the estimate, only
+// the first 64KiB of the encoding are compressed. The ratio is 1 for messages with
+// an empty encoding or that fail to marshal.
+func EstimateCompressionRatio(m proto.Message) float64 {
+	data, err := proto.Marshal(m)
+	if err != nil || len(data) == 0 {
+		return 1
+	}
+	if len(data) > compressionSampleSize {
+		data = data[:compressionSampleSize]
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return 1
+	}
+	if err := zw.Close(); err != nil {
+		return 1
+	}
+	return float64(buf.Len()) / float64(len(data))
+}
@@ -0,0 +1,41 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"testing"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+func TestEstimateCompressionRatio(t *testing.T) {
+	req := new(agenttracepb.ExportTraceServiceRequest)
+	for i := 0; i < 200; i++ {
+		req.Spans = append(req.Spans, &tracepb.Span{
+			Name: &tracepb.TruncatableString{Value: "/api/v1/users/list"},
+			Kind: tracepb.Span_SERVER,
+		})
+	}
+	if ratio := ocagent.EstimateCompressionRatio(req); ratio <= 0 || ratio >= 1 {
+		t.Errorf("Repetitive request: got ratio %v want a ratio in (0, 1)", ratio)
+	}
+
+	if g, w := ocagent.EstimateCompressionRatio(new(agenttracepb.ExportTraceServiceRequest)), 1.0; g != w {
+		t.Errorf("Empty request: got ratio %v want %v", g, w)
+	}
+}
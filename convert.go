@@ -41,3 +41,18 @@ func resourceToResourcePb(rs *resource.Resource) *resourcepb.Resource {
 	}
 	return rprs
 }
+
+// ResourceProtoFromDetector runs d and converts the detected resource to an
+// OpenCensus-Proto Resource, which allows using detectors for cloud environments
+// and chains of detectors combined with resource.MultiDetector.
+// If d fails, its error is returned along with a nil Resource.
+func ResourceProtoFromDetector(ctx context.Context, d resource.Detector) (*resourcepb.Resource, error) {
+	rs, err := d(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rs == nil {
+		return nil, nil
+	}
+	return resourceToResourcePb(rs), nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+	"go.opencensus.io/resource"
+
+	resourcepb "github.com/orijtech/ocagent_structs_no_grpc/pb/resource/v1"
+)
+
+func TestResourceProtoFromDetector(t *testing.T) {
+	fixed := func(context.Context) (*resource.Resource, error) {
+		return &resource.Resource{
+			Type:   "k8s.io/container",
+			Labels: map[string]string{"k8s.io/pod/name": "pod-1", "cloud.zone": "us-east1"},
+		}, nil
+	}
+
+	got, err := ocagent.ResourceProtoFromDetector(context.Background(), fixed)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := &resourcepb.Resource{
+		Type:   "k8s.io/container",
+		Labels: map[string]string{"k8s.io/pod/name": "pod-1", "cloud.zone": "us-east1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Resource mismatch\n\tGot  %+v\n\tWant %+v", got, want)
+	}
+
+	// A chain of detectors is merged before the conversion.
+	host := func(context.Context) (*resource.Resource, error) {
+		return &resource.Resource{Type: "host", Labels: map[string]string{"host.name": "h1", "cloud.zone": "ignored"}}, nil
+	}
+	got, err = ocagent.ResourceProtoFromDetector(context.Background(), resource.MultiDetector(fixed, host))
+	if err != nil {
+		t.Fatalf("Chain: unexpected error: %v", err)
+	}
+	want.Labels["host.name"] = "h1"
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Chain: resource mismatch\n\tGot  %+v\n\tWant %+v", got, want)
+	}
+
+	detectErr := errors.New("metadata server unreachable")
+	failing := func(context.Context) (*resource.Resource, error) {
+		return &resource.Resource{Type: "partial"}, detectErr
+	}
+	got, err = ocagent.ResourceProtoFromDetector(context.Background(), failing)
+	if err != detectErr {
+		t.Errorf("Expected the detector's error, got %v", err)
+	}
+	if got != nil {
+		t.Errorf("Expected a nil resource on error, got %v", got)
+	}
+}
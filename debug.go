@@ -0,0 +1,106 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+var errNilSpan = errors.New("expecting a non-nil tracepb.Span")
+
+// SortedAttributeMap returns the entries of attrs sorted by key, which gives
+// a deterministic view of the otherwise unordered map. attrs is not modified.
+func SortedAttributeMap(attrs map[string]*tracepb.AttributeValue) []struct {
+	Key   string
+	Value *tracepb.AttributeValue
+} {
+	if len(attrs) == 0 {
+		return nil
+	}
+	entries := make([]struct {
+		Key   string
+		Value *tracepb.AttributeValue
+	}, 0, len(attrs))
+	for key, value := range attrs {
+		entries = append(entries, struct {
+			Key   string
+			Value *tracepb.AttributeValue
+		}{Key: key, Value: value})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// SpanToDebugJSON returns a JSON encoding of s, meant for golden tests and
+// human reading rather than for the agent, in which the fields appear in
+// sorted order and the span attributes are emitted as a list of key and value
+// objects in sorted key order, as given by SortedAttributeMap.
+func SpanToDebugJSON(s *tracepb.Span) ([]byte, error) {
+	if s == nil {
+		return nil, errNilSpan
+	}
+
+	clone := proto.Clone(s).(*tracepb.Span)
+	attrs := clone.Attributes
+	clone.Attributes = nil
+
+	var buf bytes.Buffer
+	jsm := new(jsonpb.Marshaler)
+	if err := jsm.Marshal(&buf, clone); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(buf.Bytes(), &fields); err != nil {
+		return nil, err
+	}
+
+	if attrs != nil {
+		type debugEntry struct {
+			Key   string          `json:"key"`
+			Value json.RawMessage `json:"value"`
+		}
+		debugAttrs := struct {
+			AttributeMap           []debugEntry `json:"attributeMap,omitempty"`
+			DroppedAttributesCount int32        `json:"droppedAttributesCount,omitempty"`
+		}{DroppedAttributesCount: attrs.DroppedAttributesCount}
+
+		for _, entry := range SortedAttributeMap(attrs.AttributeMap) {
+			buf.Reset()
+			if err := jsm.Marshal(&buf, entry.Value); err != nil {
+				return nil, err
+			}
+			value := json.RawMessage(append([]byte(nil), buf.Bytes()...))
+			debugAttrs.AttributeMap = append(debugAttrs.AttributeMap, debugEntry{Key: entry.Key, Value: value})
+		}
+		blob, err := json.Marshal(debugAttrs)
+		if err != nil {
+			return nil, err
+		}
+		fields["attributes"] = blob
+	}
+
+	// encoding/json sorts the keys of maps.
+	return json.Marshal(fields)
+}
@@ -0,0 +1,98 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+func debugSpan() *tracepb.Span {
+	intAttr := func(v int64) *tracepb.AttributeValue {
+		return &tracepb.AttributeValue{Value: &tracepb.AttributeValue_IntValue{IntValue: v}}
+	}
+	return &tracepb.Span{
+		Name: &tracepb.TruncatableString{Value: "debug"},
+		Attributes: &tracepb.Span_Attributes{
+			AttributeMap: map[string]*tracepb.AttributeValue{
+				"zebra": intAttr(1), "apple": intAttr(2), "mango": intAttr(3),
+				"banana": intAttr(4), "kiwi": intAttr(5), "cherry": intAttr(6),
+			},
+			DroppedAttributesCount: 2,
+		},
+	}
+}
+
+func TestSortedAttributeMap(t *testing.T) {
+	attrs := debugSpan().Attributes.AttributeMap
+	want := []string{"apple", "banana", "cherry", "kiwi", "mango", "zebra"}
+	for i := 0; i < 10; i++ {
+		var keys []string
+		for _, entry := range ocagent.SortedAttributeMap(attrs) {
+			keys = append(keys, entry.Key)
+			if entry.Value != attrs[entry.Key] {
+				t.Errorf("%q: value mismatch", entry.Key)
+			}
+		}
+		if !reflect.DeepEqual(keys, want) {
+			t.Fatalf("Call #%d: got %v want %v", i, keys, want)
+		}
+	}
+
+	if got := ocagent.SortedAttributeMap(nil); got != nil {
+		t.Errorf("Expected nil for a nil map, got %v", got)
+	}
+}
+
+func TestSpanToDebugJSON(t *testing.T) {
+	span := debugSpan()
+	first, err := ocagent.SpanToDebugJSON(span)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := `{"attributes":{"attributeMap":[` +
+		`{"key":"apple","value":{"intValue":"2"}},` +
+		`{"key":"banana","value":{"intValue":"4"}},` +
+		`{"key":"cherry","value":{"intValue":"6"}},` +
+		`{"key":"kiwi","value":{"intValue":"5"}},` +
+		`{"key":"mango","value":{"intValue":"3"}},` +
+		`{"key":"zebra","value":{"intValue":"1"}}],` +
+		`"droppedAttributesCount":2},"name":{"value":"debug"}}`
+	if g := string(first); g != want {
+		t.Errorf("JSON mismatch\n\tGot  %s\n\tWant %s", g, want)
+	}
+
+	for i := 0; i < 10; i++ {
+		again, err := ocagent.SpanToDebugJSON(span)
+		if err != nil {
+			t.Fatalf("Call #%d: unexpected error: %v", i, err)
+		}
+		if !bytes.Equal(again, first) {
+			t.Fatalf("Call #%d: output differs\n\tGot  %s\n\tWant %s", i, again, first)
+		}
+	}
+	if span.Attributes == nil || len(span.Attributes.AttributeMap) != 6 {
+		t.Error("Expected the span to be left unmodified")
+	}
+
+	if _, err := ocagent.SpanToDebugJSON(nil); err == nil {
+		t.Error("Expected an error for a nil span")
+	}
+}
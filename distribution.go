@@ -0,0 +1,75 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"fmt"
+	"sort"
+
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+)
+
+// NewDistributionValue creates a DistributionValue with explicit bucket bounds
+// from bucketCounts, which must hold one count per bucket: len(bounds)+1 counts,
+// given that the bounds delimit the buckets [0, bounds[0]), ...,
+// [bounds[len(bounds)-1], +inf). Count is set to the sum of bucketCounts.
+// The bounds must be positive and strictly increasing and the bucket counts
+// non-negative.
+func NewDistributionValue(bounds []float64, bucketCounts []int64, sum float64, sumSqDev float64) (*metricspb.DistributionValue, error) {
+	if len(bucketCounts) != len(bounds)+1 {
+		return nil, fmt.Errorf("got %d bucket counts for %d bounds, want %d", len(bucketCounts), len(bounds), len(bounds)+1)
+	}
+	if len(bounds) > 0 && bounds[0] <= 0 {
+		return nil, fmt.Errorf("bounds must be positive, got %v", bounds[0])
+	}
+	for i := 1; i < len(bounds); i++ {
+		if bounds[i] <= bounds[i-1] {
+			return nil, fmt.Errorf("bounds must be strictly increasing, got %v after %v", bounds[i], bounds[i-1])
+		}
+	}
+
+	var count int64
+	for i, bucketCount := range bucketCounts {
+		if bucketCount < 0 {
+			return nil, fmt.Errorf("bucket #%d has a negative count %d", i, bucketCount)
+		}
+		count += bucketCount
+	}
+	return &metricspb.DistributionValue{
+		Count:                 count,
+		Sum:                   sum,
+		SumOfSquaredDeviation: sumSqDev,
+		BucketOptions: &metricspb.DistributionValue_BucketOptions{
+			Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
+				Explicit: &metricspb.DistributionValue_BucketOptions_Explicit{
+					Bounds: bounds,
+				},
+			},
+		},
+		Buckets: bucketsToProtoBuckets(bucketCounts),
+	}, nil
+}
+
+// BucketIndexFor returns the index of the bucket of value among those delimited
+// by the increasing bounds, following the half-open intervals of the proto:
+// [0, bounds[0]), [bounds[0], bounds[1]), ..., [bounds[len(bounds)-1], +inf).
+// A value equal to a bound thus belongs to the upper bucket. Negative values
+// belong to bucket 0.
+func BucketIndexFor(bounds []float64, value float64) int {
+	if value < 0 {
+		return 0
+	}
+	return sort.Search(len(bounds), func(i int) bool { return bounds[i] > value })
+}
@@ -0,0 +1,101 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+)
+
+func TestNewDistributionValue(t *testing.T) {
+	dv, err := ocagent.NewDistributionValue([]float64{10, 20}, []int64{3, 0, 2}, 55.5, 120.25)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := &metricspb.DistributionValue{
+		Count:                 5,
+		Sum:                   55.5,
+		SumOfSquaredDeviation: 120.25,
+		BucketOptions: &metricspb.DistributionValue_BucketOptions{
+			Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
+				Explicit: &metricspb.DistributionValue_BucketOptions_Explicit{
+					Bounds: []float64{10, 20},
+				},
+			},
+		},
+		Buckets: []*metricspb.DistributionValue_Bucket{{Count: 3}, {}, {Count: 2}},
+	}
+	if !reflect.DeepEqual(dv, want) {
+		t.Errorf("Got %v want %v", dv, want)
+	}
+}
+
+func TestNewDistributionValue_lengthMismatch(t *testing.T) {
+	for _, counts := range [][]int64{nil, {1, 2}, {1, 2, 3, 4}} {
+		dv, err := ocagent.NewDistributionValue([]float64{10, 20}, counts, 0, 0)
+		if err == nil {
+			t.Errorf("%v: expected an error, got %v", counts, dv)
+		}
+	}
+}
+
+func TestBucketIndexFor(t *testing.T) {
+	bounds := []float64{10, 20, 50}
+	tests := []struct {
+		value float64
+		want  int
+	}{
+		{value: -5, want: 0},
+		{value: 0, want: 0},
+		{value: 9.99, want: 0},
+		{value: 10, want: 1},
+		{value: 19.5, want: 1},
+		{value: 20, want: 2},
+		{value: 50, want: 3},
+		{value: 1e9, want: 3},
+	}
+	for _, tt := range tests {
+		if g := ocagent.BucketIndexFor(bounds, tt.value); g != tt.want {
+			t.Errorf("BucketIndexFor(%v, %v): got %d want %d", bounds, tt.value, g, tt.want)
+		}
+	}
+
+	if g := ocagent.BucketIndexFor(nil, 42); g != 0 {
+		t.Errorf("Without bounds: got %d want 0", g)
+	}
+}
+
+func TestNewDistributionValue_invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		bounds []float64
+		counts []int64
+	}{
+		{name: "unsorted bounds", bounds: []float64{20, 10}, counts: []int64{1, 2, 3}},
+		{name: "duplicate bounds", bounds: []float64{10, 10}, counts: []int64{1, 2, 3}},
+		{name: "zero first bound", bounds: []float64{0, 10}, counts: []int64{1, 2, 3}},
+		{name: "negative first bound", bounds: []float64{-10, 10}, counts: []int64{1, 2, 3}},
+		{name: "negative bucket count", bounds: []float64{10, 20}, counts: []int64{1, -2, 3}},
+	}
+	for _, tt := range tests {
+		if dv, err := ocagent.NewDistributionValue(tt.bounds, tt.counts, 0, 0); err == nil {
+			t.Errorf("%s: expected an error, got %v", tt.name, dv)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Reasons passed to the drop logger.
+const (
+	dropReasonUnsupportedAttribute  = "unsupported attribute type"
+	dropReasonNilAttribute          = "nil attribute value"
+	dropReasonAttributeKeyCollision = "attribute key collision"
+	dropReasonAnnotationLimit       = "annotation limit exceeded"
+	dropReasonMessageEventLimit     = "message event limit exceeded"
+	dropReasonInvalidSpanID         = "all-zero trace or span ID"
+	dropReasonMissingStartTime      = "missing span start time"
+	dropReasonSpanNamePrefix        = "span name prefix"
+	dropReasonInvertedTimestamps    = "span end time precedes start time"
+	dropReasonInvalidPercentile     = "invalid percentile"
+	dropReasonInvalidViewData       = "invalid view data"
+	dropReasonUnspecifiedMetricType = "unspecified metric type"
+)
+
+var (
+	dropLoggerMu sync.RWMutex
+	dropLogger   func(reason, detail string)
+)
+
+// SetDropLogger sets fn to be called whenever the converters discard data,
+// such as attributes of unsupported types, annotations and message events
+// over the per-span limits, or invalid percentiles, with a short reason and
+// a detail describing what was dropped. A nil fn, the default, disables
+// logging. fn may be called concurrently.
+func SetDropLogger(fn func(reason, detail string)) {
+	dropLoggerMu.Lock()
+	dropLogger = fn
+	dropLoggerMu.Unlock()
+}
+
+// logDrop passes reason and the formatted detail to the drop logger, if any.
+// The detail is only formatted if there is a logger.
+func logDrop(reason, format string, args ...interface{}) {
+	dropLoggerMu.RLock()
+	fn := dropLogger
+	dropLoggerMu.RUnlock()
+	if fn != nil {
+		fn(reason, fmt.Sprintf(format, args...))
+	}
+}
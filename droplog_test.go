@@ -0,0 +1,64 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+	"go.opencensus.io/trace"
+)
+
+func TestSetDropLogger(t *testing.T) {
+	type drop struct{ reason, detail string }
+	var drops []drop
+	ocagent.SetDropLogger(func(reason, detail string) {
+		drops = append(drops, drop{reason, detail})
+	})
+	defer ocagent.SetDropLogger(nil)
+
+	type unsupported struct{ Field int }
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "drops",
+		Attributes: map[string]interface{}{
+			"supported":   "yes",
+			"unsupported": unsupported{Field: 1},
+		},
+	}
+
+	span := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData}).Spans[0]
+	if _, ok := span.Attributes.AttributeMap["unsupported"]; ok {
+		t.Error("Expected the unsupported attribute to be dropped")
+	}
+	if len(drops) != 1 {
+		t.Fatalf("Expected a single drop, got %v", drops)
+	}
+	if g, w := drops[0].reason, "unsupported attribute type"; g != w {
+		t.Errorf("Reason: got %q want %q", g, w)
+	}
+	if !strings.Contains(drops[0].detail, `"unsupported"`) || !strings.Contains(drops[0].detail, "ocagent_test.unsupported") {
+		t.Errorf("Detail does not describe the attribute: %q", drops[0].detail)
+	}
+
+	// Once unset, nothing is logged.
+	ocagent.SetDropLogger(nil)
+	drops = nil
+	ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData})
+	if len(drops) != 0 {
+		t.Errorf("Expected no drops to be logged, got %v", drops)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"github.com/golang/protobuf/proto"
+
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+	resourcepb "github.com/orijtech/ocagent_structs_no_grpc/pb/resource/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+// MetricsEqualIgnoringTime reports whether a and b are equal, ignoring the
+// timestamps of their points, exemplars and the start timestamps of their
+// timeseries. Neither a nor b is modified.
+func MetricsEqualIgnoringTime(a, b *metricspb.Metric) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	ac := proto.Clone(a).(*metricspb.Metric)
+	bc := proto.Clone(b).(*metricspb.Metric)
+	clearMetricTimestamps(ac)
+	clearMetricTimestamps(bc)
+	return proto.Equal(ac, bc)
+}
+
+func clearMetricTimestamps(m *metricspb.Metric) {
+	for _, ts := range m.Timeseries {
+		if ts == nil {
+			continue
+		}
+		ts.StartTimestamp = nil
+		for _, pt := range ts.Points {
+			if pt == nil {
+				continue
+			}
+			pt.Timestamp = nil
+			if dv := pt.GetDistributionValue(); dv != nil {
+				for _, bucket := range dv.Buckets {
+					if bucket != nil && bucket.Exemplar != nil {
+						bucket.Exemplar.Timestamp = nil
+					}
+				}
+			}
+		}
+	}
+}
+
+// TraceConfigChanged reports whether updated, typically received as an
+// UpdatedLibraryConfig from the agent, differs from current in its sampler
+// or in any of its max number of attributes, annotations, message events
+// and links. A nil updated config means no change.
+func TraceConfigChanged(current, updated *tracepb.TraceConfig) bool {
+	if updated == nil {
+		return false
+	}
+	if current.GetMaxNumberOfAttributes() != updated.MaxNumberOfAttributes ||
+		current.GetMaxNumberOfAnnotations() != updated.MaxNumberOfAnnotations ||
+		current.GetMaxNumberOfMessageEvents() != updated.MaxNumberOfMessageEvents ||
+		current.GetMaxNumberOfLinks() != updated.MaxNumberOfLinks {
+		return true
+	}
+	return !samplersEqual(current.GetSampler(), updated.Sampler)
+}
+
+func samplersEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case *tracepb.TraceConfig_ProbabilitySampler:
+		b, ok := b.(*tracepb.TraceConfig_ProbabilitySampler)
+		return ok && proto.Equal(a.ProbabilitySampler, b.ProbabilitySampler)
+	case *tracepb.TraceConfig_ConstantSampler:
+		b, ok := b.(*tracepb.TraceConfig_ConstantSampler)
+		return ok && proto.Equal(a.ConstantSampler, b.ConstantSampler)
+	case *tracepb.TraceConfig_RateLimitingSampler:
+		b, ok := b.(*tracepb.TraceConfig_RateLimitingSampler)
+		return ok && proto.Equal(a.RateLimitingSampler, b.RateLimitingSampler)
+	default:
+		return false
+	}
+}
+
+// ResourcePbEqual reports whether a and b have the same Type and Labels.
+// A nil Resource only equals another nil Resource.
+func ResourcePbEqual(a, b *resourcepb.Resource) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type || len(a.Labels) != len(b.Labels) {
+		return false
+	}
+	for key, value := range a.Labels {
+		if bValue, ok := b.Labels[key]; !ok || bValue != value {
+			return false
+		}
+	}
+	return true
+}
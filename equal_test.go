@@ -0,0 +1,142 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+	resourcepb "github.com/orijtech/ocagent_structs_no_grpc/pb/resource/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+func TestMetricsEqualIgnoringTime(t *testing.T) {
+	newMetric := func(at time.Time, value int64) *metricspb.Metric {
+		return &metricspb.Metric{
+			MetricDescriptor: &metricspb.MetricDescriptor{
+				Name: "fouls",
+				Type: metricspb.MetricDescriptor_CUMULATIVE_INT64,
+				LabelKeys: []*metricspb.LabelKey{
+					{Key: "player"},
+				},
+			},
+			Timeseries: []*metricspb.TimeSeries{
+				{
+					StartTimestamp: timeToTimestamp(at),
+					LabelValues:    []*metricspb.LabelValue{{Value: "p1", HasValue: true}},
+					Points: []*metricspb.Point{
+						{
+							Timestamp: timeToTimestamp(at.Add(time.Second)),
+							Value:     &metricspb.Point_Int64Value{Int64Value: value},
+						},
+					},
+				},
+			},
+		}
+	}
+
+	now := time.Now()
+	a := newMetric(now, 10)
+	b := newMetric(now.Add(time.Hour), 10)
+	if !ocagent.MetricsEqualIgnoringTime(a, b) {
+		t.Error("Expected metrics differing only by timestamps to be equal")
+	}
+	if a.Timeseries[0].StartTimestamp == nil || a.Timeseries[0].Points[0].Timestamp == nil {
+		t.Error("The original metrics must not be modified")
+	}
+
+	c := newMetric(now, 11)
+	if ocagent.MetricsEqualIgnoringTime(a, c) {
+		t.Error("Expected metrics differing by a point value to be unequal")
+	}
+}
+
+func TestTraceConfigChanged(t *testing.T) {
+	newConfig := func(probability float64, maxAttributes int64) *tracepb.TraceConfig {
+		return &tracepb.TraceConfig{
+			Sampler: &tracepb.TraceConfig_ProbabilitySampler{
+				ProbabilitySampler: &tracepb.ProbabilitySampler{SamplingProbability: probability},
+			},
+			MaxNumberOfAttributes:    maxAttributes,
+			MaxNumberOfAnnotations:   32,
+			MaxNumberOfMessageEvents: 128,
+			MaxNumberOfLinks:         32,
+		}
+	}
+	current := newConfig(0.5, 32)
+
+	constant := newConfig(0.5, 32)
+	constant.Sampler = &tracepb.TraceConfig_ConstantSampler{
+		ConstantSampler: &tracepb.ConstantSampler{Decision: tracepb.ConstantSampler_ALWAYS_ON},
+	}
+	noSampler := newConfig(0.5, 32)
+	noSampler.Sampler = nil
+	moreLinks := newConfig(0.5, 32)
+	moreLinks.MaxNumberOfLinks = 64
+
+	tests := []struct {
+		name    string
+		updated *tracepb.TraceConfig
+		want    bool
+	}{
+		{name: "nil updated", updated: nil, want: false},
+		{name: "identical", updated: newConfig(0.5, 32), want: false},
+		{name: "different probability", updated: newConfig(0.25, 32), want: true},
+		{name: "different sampler type", updated: constant, want: true},
+		{name: "sampler removed", updated: noSampler, want: true},
+		{name: "different max attributes", updated: newConfig(0.5, 64), want: true},
+		{name: "different max links", updated: moreLinks, want: true},
+	}
+
+	for _, tt := range tests {
+		if got := ocagent.TraceConfigChanged(current, tt.updated); got != tt.want {
+			t.Errorf("%s: got %t want %t", tt.name, got, tt.want)
+		}
+	}
+
+	if !ocagent.TraceConfigChanged(nil, current) {
+		t.Error("Expected a change from a nil current config")
+	}
+}
+
+func TestResourcePbEqual(t *testing.T) {
+	host := &resourcepb.Resource{Type: "host", Labels: map[string]string{"zone": "a", "name": "h1"}}
+	tests := []struct {
+		name string
+		b    *resourcepb.Resource
+		want bool
+	}{
+		{name: "same labels", b: &resourcepb.Resource{Type: "host", Labels: map[string]string{"name": "h1", "zone": "a"}}, want: true},
+		{name: "different type", b: &resourcepb.Resource{Type: "container", Labels: host.Labels}, want: false},
+		{name: "different value", b: &resourcepb.Resource{Type: "host", Labels: map[string]string{"zone": "b", "name": "h1"}}, want: false},
+		{name: "missing label", b: &resourcepb.Resource{Type: "host", Labels: map[string]string{"zone": "a"}}, want: false},
+		{name: "nil", b: nil, want: false},
+	}
+	for _, tt := range tests {
+		if got := ocagent.ResourcePbEqual(host, tt.b); got != tt.want {
+			t.Errorf("%s: got %t want %t", tt.name, got, tt.want)
+		}
+	}
+
+	if !ocagent.ResourcePbEqual(nil, nil) {
+		t.Error("Expected nil resources to be equal")
+	}
+	if !ocagent.ResourcePbEqual(&resourcepb.Resource{Type: "host"}, &resourcepb.Resource{Type: "host", Labels: map[string]string{}}) {
+		t.Error("Expected nil and empty labels to be equal")
+	}
+}
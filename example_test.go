@@ -152,7 +152,7 @@ func ExampleMetrics_jsonExport() {
 					Min:             11.9,
 					Max:             11.9,
 					Mean:            11.9,
-					CountPerBucket:  []int64{0, 1, 0, 0, 0},
+					CountPerBucket:  []int64{0, 1, 0, 0, 0, 0},
 					SumOfSquaredDev: 0,
 				},
 			},
@@ -167,7 +167,7 @@ func ExampleMetrics_jsonExport() {
 					Min:             20.2,
 					Max:             20.2,
 					Mean:            20.2,
-					CountPerBucket:  []int64{0, 0, 1, 0, 0},
+					CountPerBucket:  []int64{0, 0, 1, 0, 0, 0},
 					SumOfSquaredDev: 0,
 				},
 			},
@@ -182,7 +182,7 @@ func ExampleMetrics_jsonExport() {
 					Min:             28.9,
 					Max:             28.9,
 					Mean:            28.9,
-					CountPerBucket:  []int64{0, 0, 1, 0, 0},
+					CountPerBucket:  []int64{0, 0, 1, 0, 0, 0},
 					SumOfSquaredDev: 0,
 				},
 			},
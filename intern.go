@@ -0,0 +1,58 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+// InternAttributeValues deduplicates the string attribute values of the spans,
+// annotations and links in req, so that equal values share the same backing
+// memory. This reduces the memory held by large batches with many repeated
+// values, for example the same URL on every span. req is modified in place.
+func InternAttributeValues(req *agenttracepb.ExportTraceServiceRequest) {
+	if req == nil {
+		return
+	}
+
+	interned := make(map[string]string)
+	intern := func(attrs *tracepb.Span_Attributes) {
+		for _, av := range attrs.GetAttributeMap() {
+			sv := av.GetStringValue()
+			if sv == nil {
+				continue
+			}
+			if s, ok := interned[sv.Value]; ok {
+				sv.Value = s
+			} else {
+				interned[sv.Value] = sv.Value
+			}
+		}
+	}
+
+	for _, span := range req.Spans {
+		if span == nil {
+			continue
+		}
+		intern(span.Attributes)
+		for _, te := range span.GetTimeEvents().GetTimeEvent() {
+			intern(te.GetAnnotation().GetAttributes())
+		}
+		for _, link := range span.GetLinks().GetLink() {
+			intern(link.GetAttributes())
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+	"go.opencensus.io/trace"
+
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+)
+
+// stringData returns the address of the backing memory of s.
+func stringData(s string) *byte {
+	return unsafe.StringData(s)
+}
+
+func spansWithRepeatedURL(n int) []*trace.SpanData {
+	sdl := make([]*trace.SpanData, 0, n)
+	for i := 0; i < n; i++ {
+		// strings.Repeat allocates a distinct backing array every time.
+		url := strings.Repeat("https://opencensus.io/", 2)
+		sdl = append(sdl, &trace.SpanData{
+			SpanContext: trace.SpanContext{
+				TraceID: testTraceID,
+				SpanID:  trace.SpanID{0xFF, 0xFE, 0xFD, 0xFC, 0xFB, 0xFA, 0xF9, byte(i)},
+			},
+			Name:        "interned",
+			Attributes:  map[string]interface{}{"url": url, "method": "GET"},
+			Annotations: []trace.Annotation{{Message: "a", Attributes: map[string]interface{}{"url": url}}},
+		})
+	}
+	return sdl
+}
+
+func TestInternAttributeValues(t *testing.T) {
+	req := ocagent.OpenCensusSpanDataToProtoSpans(spansWithRepeatedURL(3))
+	ocagent.InternAttributeValues(req)
+
+	first := req.Spans[0].Attributes.AttributeMap["url"].GetStringValue().Value
+	for i, span := range req.Spans {
+		values := []string{
+			span.Attributes.AttributeMap["url"].GetStringValue().Value,
+			span.TimeEvents.TimeEvent[0].GetAnnotation().Attributes.AttributeMap["url"].GetStringValue().Value,
+		}
+		for j, value := range values {
+			if value != first {
+				t.Errorf("#%d.%d: value changed to %q", i, j, value)
+			}
+			if stringData(value) != stringData(first) {
+				t.Errorf("#%d.%d: expected equal values to share backing memory", i, j)
+			}
+		}
+	}
+}
+
+func BenchmarkInternAttributeValues(b *testing.B) {
+	sdl := spansWithRepeatedURL(256)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var req *agenttracepb.ExportTraceServiceRequest = ocagent.OpenCensusSpanDataToProtoSpans(sdl)
+		ocagent.InternAttributeValues(req)
+	}
+}
@@ -20,24 +20,36 @@ import (
 
 	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
 	"go.opencensus.io"
+	"go.opencensus.io/resource"
 )
 
+// resourceLabelAttributePrefix prefixes the keys of Node attributes holding
+// resource labels. See NodeWithResource.
+const resourceLabelAttributePrefix = "resource.label."
+
 // NodeWithStartTime creates a node using nodeName and derives:
 //  Hostname from the environment
 //  Pid from the current process
 //  StartTimestamp from the start time of this process
 //  Language and library information.
 func NodeWithStartTime(nodeName string, startTime time.Time) *commonpb.Node {
+	return NodeWithLibraryInfo(nodeName, startTime, "0.0.1", opencensus.Version())
+}
+
+// NodeWithLibraryInfo is like NodeWithStartTime, but records exporterVersion and
+// coreLibraryVersion in the LibraryInfo of the node, which helps debugging which
+// exporter produced the data. Empty versions are left unset.
+func NodeWithLibraryInfo(nodeName string, startTime time.Time, exporterVersion, coreLibraryVersion string) *commonpb.Node {
 	return &commonpb.Node{
 		Identifier: &commonpb.ProcessIdentifier{
 			HostName:       os.Getenv("HOSTNAME"),
 			Pid:            uint32(os.Getpid()),
-			StartTimestamp: timeToTimestamp(startTime),
+			StartTimestamp: TimeToProto(startTime),
 		},
 		LibraryInfo: &commonpb.LibraryInfo{
 			Language:           commonpb.LibraryInfo_GO_LANG,
-			ExporterVersion:    "0.0.1",
-			CoreLibraryVersion: opencensus.Version(),
+			ExporterVersion:    exporterVersion,
+			CoreLibraryVersion: coreLibraryVersion,
 		},
 		ServiceInfo: &commonpb.ServiceInfo{
 			Name: nodeName,
@@ -45,3 +57,21 @@ func NodeWithStartTime(nodeName string, startTime time.Time) *commonpb.Node {
 		Attributes: make(map[string]string),
 	}
 }
+
+// NodeWithResource is like NodeWithStartTime, but folds the type and labels of rs
+// into the Node attributes, under the keys "resource.type" and "resource.label."
+// followed by the label key respectively, for agents whose trace service doesn't
+// support the Resource message. A nil rs yields the same Node as NodeWithStartTime.
+func NodeWithResource(serviceName string, startTime time.Time, rs *resource.Resource) *commonpb.Node {
+	node := NodeWithStartTime(serviceName, startTime)
+	if rs == nil {
+		return node
+	}
+	if rs.Type != "" {
+		node.Attributes[resourceTypeLabelKey] = rs.Type
+	}
+	for k, v := range rs.Labels {
+		node.Attributes[resourceLabelAttributePrefix+k] = v
+	}
+	return node
+}
@@ -0,0 +1,85 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+	"go.opencensus.io"
+	"go.opencensus.io/resource"
+
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+)
+
+func TestNodeWithLibraryInfo(t *testing.T) {
+	startTime := time.Date(2019, time.January, 2, 3, 4, 5, 0, time.UTC)
+	node := ocagent.NodeWithLibraryInfo("svc", startTime, "1.2.3", "0.22.0")
+
+	want := &commonpb.LibraryInfo{
+		Language:           commonpb.LibraryInfo_GO_LANG,
+		ExporterVersion:    "1.2.3",
+		CoreLibraryVersion: "0.22.0",
+	}
+	if g := node.LibraryInfo; g.Language != want.Language || g.ExporterVersion != want.ExporterVersion || g.CoreLibraryVersion != want.CoreLibraryVersion {
+		t.Errorf("LibraryInfo: got %v want %v", g, want)
+	}
+	if g, w := node.ServiceInfo.GetName(), "svc"; g != w {
+		t.Errorf("ServiceInfo.Name: got %q want %q", g, w)
+	}
+	if g := ocagent.ProtoToTime(node.Identifier.GetStartTimestamp()); !g.Equal(startTime) {
+		t.Errorf("StartTimestamp: got %v want %v", g, startTime)
+	}
+}
+
+func TestNodeWithStartTime_libraryInfo(t *testing.T) {
+	info := ocagent.NodeWithStartTime("svc", time.Now()).LibraryInfo
+	if g, w := info.GetLanguage(), commonpb.LibraryInfo_GO_LANG; g != w {
+		t.Errorf("Language: got %v want %v", g, w)
+	}
+	if info.GetExporterVersion() == "" {
+		t.Error("Expected a default ExporterVersion")
+	}
+	if g, w := info.GetCoreLibraryVersion(), opencensus.Version(); g != w {
+		t.Errorf("CoreLibraryVersion: got %q want %q", g, w)
+	}
+}
+
+func TestNodeWithResource(t *testing.T) {
+	startTime := time.Date(2019, time.January, 2, 3, 4, 5, 0, time.UTC)
+	rs := &resource.Resource{
+		Type:   "k8s.io/container",
+		Labels: map[string]string{"k8s.io/pod/name": "web-0", "cloud.zone": "us-east1-b"},
+	}
+	node := ocagent.NodeWithResource("svc", startTime, rs)
+
+	want := map[string]string{
+		"resource.type":                  "k8s.io/container",
+		"resource.label.k8s.io/pod/name": "web-0",
+		"resource.label.cloud.zone":      "us-east1-b",
+	}
+	if !reflect.DeepEqual(node.Attributes, want) {
+		t.Errorf("Attributes: got %v want %v", node.Attributes, want)
+	}
+	if g, w := node.ServiceInfo.GetName(), "svc"; g != w {
+		t.Errorf("ServiceInfo.Name: got %q want %q", g, w)
+	}
+
+	if g := ocagent.NodeWithResource("svc", startTime, nil).Attributes; len(g) != 0 {
+		t.Errorf("Expected no attributes for a nil resource, got %v", g)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ocagenttest contains helpers for testing exporters
+// that produce OpenCensus-Proto requests.
+package ocagenttest
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+var errNilMessage = errors.New("expecting a non-nil proto.Message")
+
+// AssertRoundTrip marshals m to the protobuf binary format, unmarshals the
+// result into a new message of the same type and returns an error unless
+// both messages are equal, ignoring unrecognized fields. m is not modified.
+func AssertRoundTrip(m proto.Message) error {
+	if m == nil {
+		return errNilMessage
+	}
+	blob, err := proto.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("marshal: %v", err)
+	}
+
+	got := proto.Clone(m)
+	got.Reset()
+	if err := proto.Unmarshal(blob, got); err != nil {
+		return fmt.Errorf("unmarshal: %v", err)
+	}
+
+	want := proto.Clone(m)
+	proto.DiscardUnknown(want)
+	proto.DiscardUnknown(got)
+	if !proto.Equal(got, want) {
+		return fmt.Errorf("round trip mismatch\n\tGot  %v\n\tWant %v", got, want)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagenttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+	"github.com/orijtech/ocagent_structs_no_grpc/ocagenttest"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
+
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+	resourcepb "github.com/orijtech/ocagent_structs_no_grpc/pb/resource/v1"
+)
+
+func TestAssertRoundTrip_trace(t *testing.T) {
+	start := time.Date(2019, time.January, 2, 3, 4, 5, 6, time.UTC)
+	sd := &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID: trace.TraceID{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F},
+			SpanID:  trace.SpanID{0xFF, 0xFE, 0xFD, 0xFC, 0xFB, 0xFA, 0xF9, 0xF8},
+		},
+		ParentSpanID: trace.SpanID{0xEF, 0xEE, 0xED, 0xEC, 0xEB, 0xEA, 0xE9, 0xE8},
+		Name:         "roundtrip",
+		SpanKind:     trace.SpanKindServer,
+		StartTime:    start,
+		EndTime:      start.Add(time.Second),
+		Attributes:   map[string]interface{}{"s": "v", "b": true, "i": int64(3), "f": 1.5},
+		Annotations:  []trace.Annotation{{Time: start, Message: "a", Attributes: map[string]interface{}{"k": "v"}}},
+		MessageEvents: []trace.MessageEvent{
+			{Time: start.Add(time.Millisecond), EventType: trace.MessageEventTypeSent, MessageID: 1, UncompressedByteSize: 10},
+		},
+		Links:  []trace.Link{{TraceID: trace.TraceID{0x01}, SpanID: trace.SpanID{0x02}, Type: trace.LinkTypeChild}},
+		Status: trace.Status{Code: 5, Message: "not found"},
+	}
+	req := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{sd})
+	req.Node = ocagent.NodeWithStartTime("svc", start)
+	req.Resource = &resourcepb.Resource{Type: "host", Labels: map[string]string{"zone": "a"}}
+
+	if err := ocagenttest.AssertRoundTrip(req); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestAssertRoundTrip_metrics(t *testing.T) {
+	key, _ := tag.NewKey("method")
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "latency",
+			Aggregation: view.Distribution(0, 10, 20),
+			TagKeys:     []tag.Key{key},
+			Measure:     stats.Float64("latency", "The latency", "ms"),
+		},
+		Start: time.Date(2019, time.January, 2, 3, 4, 5, 6, time.UTC),
+		End:   time.Date(2019, time.January, 2, 3, 4, 22, 6, time.UTC),
+		Rows: []*view.Row{{
+			Tags: []tag.Tag{{Key: key, Value: "GET"}},
+			Data: &view.DistributionData{Count: 2, Mean: 7.5, SumOfSquaredDev: 12.5, CountPerBucket: []int64{0, 2, 0, 0}},
+		}},
+	}
+	req := ocagent.OpenCensusViewDataToProtoMetrics([]*view.Data{vd})
+	req.Node = &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}}
+
+	if err := ocagenttest.AssertRoundTrip(req); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestAssertRoundTrip_nil(t *testing.T) {
+	if err := ocagenttest.AssertRoundTrip(nil); err == nil {
+		t.Error("Expected an error for a nil message")
+	}
+}
@@ -0,0 +1,585 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+	resourcepb "github.com/orijtech/ocagent_structs_no_grpc/pb/resource/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+var (
+	errNilTraceRequest   = errors.New("expecting a non-nil ExportTraceServiceRequest")
+	errNilMetricsRequest = errors.New("expecting a non-nil ExportMetricsServiceRequest")
+)
+
+// otlpScopeName is the name of the instrumentation scope of all converted data.
+const otlpScopeName = "opencensus"
+
+// The types below mirror the OTLP JSON encoding, in which 64 bit integers are
+// decimal strings and trace and span IDs are hex strings.
+
+type otlpTraceData struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource      `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID                string          `json:"traceId"`
+	SpanID                 string          `json:"spanId"`
+	TraceState             string          `json:"traceState,omitempty"`
+	ParentSpanID           string          `json:"parentSpanId,omitempty"`
+	Name                   string          `json:"name"`
+	Kind                   int             `json:"kind"`
+	StartTimeUnixNano      string          `json:"startTimeUnixNano,omitempty"`
+	EndTimeUnixNano        string          `json:"endTimeUnixNano,omitempty"`
+	Attributes             []*otlpKeyValue `json:"attributes,omitempty"`
+	DroppedAttributesCount int32           `json:"droppedAttributesCount,omitempty"`
+	Events                 []*otlpEvent    `json:"events,omitempty"`
+	DroppedEventsCount     int32           `json:"droppedEventsCount,omitempty"`
+	Links                  []*otlpLink     `json:"links,omitempty"`
+	DroppedLinksCount      int32           `json:"droppedLinksCount,omitempty"`
+	Status                 *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano           string          `json:"timeUnixNano,omitempty"`
+	Name                   string          `json:"name"`
+	Attributes             []*otlpKeyValue `json:"attributes,omitempty"`
+	DroppedAttributesCount int32           `json:"droppedAttributesCount,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID                string          `json:"traceId"`
+	SpanID                 string          `json:"spanId"`
+	TraceState             string          `json:"traceState,omitempty"`
+	Attributes             []*otlpKeyValue `json:"attributes,omitempty"`
+	DroppedAttributesCount int32           `json:"droppedAttributesCount,omitempty"`
+}
+
+type otlpStatus struct {
+	Message string `json:"message,omitempty"`
+	Code    int    `json:"code,omitempty"`
+}
+
+type otlpMetricsData struct {
+	ResourceMetrics []*otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource        `json:"resource"`
+	ScopeMetrics []*otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope     `json:"scope"`
+	Metrics []*otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Unit        string         `json:"unit,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []*otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []*otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                    `json:"aggregationTemporality"`
+	IsMonotonic            bool                   `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []*otlpHistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality int                       `json:"aggregationTemporality"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []*otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano,omitempty"`
+	AsInt             *string         `json:"asInt,omitempty"`
+	AsDouble          *float64        `json:"asDouble,omitempty"`
+}
+
+type otlpHistogramDataPoint struct {
+	Attributes        []*otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano,omitempty"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// OTLP span kinds and status codes.
+const (
+	otlpSpanKindUnspecified = 0
+	otlpSpanKindServer      = 2
+	otlpSpanKindClient      = 3
+
+	otlpStatusCodeError = 2
+
+	otlpAggregationTemporalityDelta      = 1
+	otlpAggregationTemporalityCumulative = 2
+)
+
+// otlpLanguages maps library languages to OpenTelemetry telemetry.sdk.language values.
+var otlpLanguages = map[commonpb.LibraryInfo_Language]string{
+	commonpb.LibraryInfo_CPP:     "cpp",
+	commonpb.LibraryInfo_C_SHARP: "dotnet",
+	commonpb.LibraryInfo_ERLANG:  "erlang",
+	commonpb.LibraryInfo_GO_LANG: "go",
+	commonpb.LibraryInfo_JAVA:    "java",
+	commonpb.LibraryInfo_NODE_JS: "nodejs",
+	commonpb.LibraryInfo_PHP:     "php",
+	commonpb.LibraryInfo_PYTHON:  "python",
+	commonpb.LibraryInfo_RUBY:    "ruby",
+	commonpb.LibraryInfo_WEB_JS:  "webjs",
+}
+
+// ToOTLPTraceJSON renders req in the OTLP JSON shape, resourceSpans holding scopeSpans
+// holding spans, on a best-effort basis to ease migrating to OpenTelemetry collectors.
+// Spans are grouped by their Resource, falling back to the Resource of req.
+//
+// The mapping is lossy:
+//   - The Node becomes the resource attributes service.name, host.name, process.pid,
+//     telemetry.sdk.name, telemetry.sdk.language and telemetry.sdk.version, plus the
+//     Node attributes. Resource labels are added as is, overriding those, and the
+//     Resource type becomes the opencensus.resource.type attribute.
+//   - Status codes other than OK become the OTLP error code, keeping the message,
+//     while OK becomes the unset code.
+//   - Annotations become events named after their description, and message events
+//     become events named "message" with message.type, message.id,
+//     message.uncompressed_size and message.compressed_size attributes.
+//   - Truncated byte counts, link types, stack traces, ChildSpanCount and
+//     SameProcessAsParentSpan are dropped.
+func ToOTLPTraceJSON(req *agenttracepb.ExportTraceServiceRequest) ([]byte, error) {
+	if req == nil {
+		return nil, errNilTraceRequest
+	}
+	data := &otlpTraceData{ResourceSpans: make([]*otlpResourceSpans, 0)}
+	var resources []*resourcepb.Resource
+	for _, span := range req.Spans {
+		if span == nil {
+			continue
+		}
+		resource := span.Resource
+		if resource == nil {
+			resource = req.Resource
+		}
+		i := indexOfResource(resources, resource)
+		if i < 0 {
+			i = len(resources)
+			resources = append(resources, resource)
+			data.ResourceSpans = append(data.ResourceSpans, &otlpResourceSpans{
+				Resource: otlpResource{Attributes: otlpResourceAttributes(req.Node, resource)},
+				ScopeSpans: []*otlpScopeSpans{{
+					Scope: otlpScope{Name: otlpScopeName},
+					Spans: make([]*otlpSpan, 0, 1),
+				}},
+			})
+		}
+		scopeSpans := data.ResourceSpans[i].ScopeSpans[0]
+		scopeSpans.Spans = append(scopeSpans.Spans, protoSpanToOTLPSpan(span))
+	}
+	return json.Marshal(data)
+}
+
+// ToOTLPMetricsJSON renders req in the OTLP JSON shape, resourceMetrics holding scopeMetrics
+// holding metrics, on a best-effort basis to ease migrating to OpenTelemetry collectors.
+// Metrics are grouped by their Resource, falling back to the Resource of req, which along
+// with the Node maps to resource attributes as documented by ToOTLPTraceJSON.
+//
+// The mapping is lossy:
+//   - GAUGE_INT64 and GAUGE_DOUBLE metrics become gauges.
+//   - CUMULATIVE_INT64 and CUMULATIVE_DOUBLE metrics become monotonic sums with
+//     cumulative temporality.
+//   - CUMULATIVE_DISTRIBUTION metrics become histograms with cumulative temporality,
+//     while GAUGE_DISTRIBUTION metrics, whose points each describe the values of a
+//     single interval, become histograms with delta temporality. The sum of squared
+//     deviations and exemplars are dropped.
+//   - Bucket bounds are copied as is, although OpenCensus buckets include their lower
+//     bound, [bounds[i-1], bounds[i]), while OTLP buckets include their upper bound,
+//     (bounds[i-1], bounds[i]]. Values equal to a bound are thus attributed to the
+//     bucket above it rather than to the bucket below it.
+//   - SUMMARY metrics, and metrics of an unspecified type, are unsupported and skipped.
+//   - Unset label values are omitted from the data point attributes.
+func ToOTLPMetricsJSON(req *agentmetricspb.ExportMetricsServiceRequest) ([]byte, error) {
+	if req == nil {
+		return nil, errNilMetricsRequest
+	}
+	data := &otlpMetricsData{ResourceMetrics: make([]*otlpResourceMetrics, 0)}
+	var resources []*resourcepb.Resource
+	for _, metric := range req.Metrics {
+		om := protoMetricToOTLPMetric(metric)
+		if om == nil {
+			continue
+		}
+		resource := metric.Resource
+		if resource == nil {
+			resource = req.Resource
+		}
+		i := indexOfResource(resources, resource)
+		if i < 0 {
+			i = len(resources)
+			resources = append(resources, resource)
+			data.ResourceMetrics = append(data.ResourceMetrics, &otlpResourceMetrics{
+				Resource: otlpResource{Attributes: otlpResourceAttributes(req.Node, resource)},
+				ScopeMetrics: []*otlpScopeMetrics{{
+					Scope:   otlpScope{Name: otlpScopeName},
+					Metrics: make([]*otlpMetric, 0, 1),
+				}},
+			})
+		}
+		scopeMetrics := data.ResourceMetrics[i].ScopeMetrics[0]
+		scopeMetrics.Metrics = append(scopeMetrics.Metrics, om)
+	}
+	return json.Marshal(data)
+}
+
+// protoMetricToOTLPMetric returns nil for metrics of unsupported types.
+func protoMetricToOTLPMetric(metric *metricspb.Metric) *otlpMetric {
+	md := metric.GetMetricDescriptor()
+	if md == nil {
+		return nil
+	}
+	om := &otlpMetric{
+		Name:        md.Name,
+		Description: md.Description,
+		Unit:        md.Unit,
+	}
+	switch md.Type {
+	case metricspb.MetricDescriptor_GAUGE_INT64, metricspb.MetricDescriptor_GAUGE_DOUBLE:
+		om.Gauge = &otlpGauge{DataPoints: otlpNumberDataPoints(md, metric.Timeseries)}
+	case metricspb.MetricDescriptor_CUMULATIVE_INT64, metricspb.MetricDescriptor_CUMULATIVE_DOUBLE:
+		om.Sum = &otlpSum{
+			DataPoints:             otlpNumberDataPoints(md, metric.Timeseries),
+			AggregationTemporality: otlpAggregationTemporalityCumulative,
+			IsMonotonic:            true,
+		}
+	case metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION:
+		om.Histogram = &otlpHistogram{
+			DataPoints:             otlpHistogramDataPoints(md, metric.Timeseries),
+			AggregationTemporality: otlpAggregationTemporalityCumulative,
+		}
+	case metricspb.MetricDescriptor_GAUGE_DISTRIBUTION:
+		om.Histogram = &otlpHistogram{
+			DataPoints:             otlpHistogramDataPoints(md, metric.Timeseries),
+			AggregationTemporality: otlpAggregationTemporalityDelta,
+		}
+	default:
+		return nil
+	}
+	return om
+}
+
+func otlpNumberDataPoints(md *metricspb.MetricDescriptor, timeseries []*metricspb.TimeSeries) []*otlpNumberDataPoint {
+	dps := make([]*otlpNumberDataPoint, 0, len(timeseries))
+	for _, ts := range timeseries {
+		if ts == nil {
+			continue
+		}
+		attrs := otlpLabelAttributes(md.LabelKeys, ts.LabelValues)
+		for _, point := range ts.Points {
+			dp := &otlpNumberDataPoint{
+				Attributes:        attrs,
+				StartTimeUnixNano: otlpTime(ts.StartTimestamp),
+				TimeUnixNano:      otlpTime(point.GetTimestamp()),
+			}
+			switch v := point.GetValue().(type) {
+			case *metricspb.Point_Int64Value:
+				i := strconv.FormatInt(v.Int64Value, 10)
+				dp.AsInt = &i
+			case *metricspb.Point_DoubleValue:
+				d := v.DoubleValue
+				dp.AsDouble = &d
+			default:
+				continue
+			}
+			dps = append(dps, dp)
+		}
+	}
+	return dps
+}
+
+func otlpHistogramDataPoints(md *metricspb.MetricDescriptor, timeseries []*metricspb.TimeSeries) []*otlpHistogramDataPoint {
+	dps := make([]*otlpHistogramDataPoint, 0, len(timeseries))
+	for _, ts := range timeseries {
+		if ts == nil {
+			continue
+		}
+		attrs := otlpLabelAttributes(md.LabelKeys, ts.LabelValues)
+		for _, point := range ts.Points {
+			dv := point.GetDistributionValue()
+			if dv == nil {
+				continue
+			}
+			bucketCounts := make([]string, 0, len(dv.Buckets))
+			for _, bucket := range dv.Buckets {
+				bucketCounts = append(bucketCounts, strconv.FormatInt(bucket.GetCount(), 10))
+			}
+			dps = append(dps, &otlpHistogramDataPoint{
+				Attributes:        attrs,
+				StartTimeUnixNano: otlpTime(ts.StartTimestamp),
+				TimeUnixNano:      otlpTime(point.Timestamp),
+				Count:             strconv.FormatInt(dv.Count, 10),
+				Sum:               dv.Sum,
+				BucketCounts:      bucketCounts,
+				ExplicitBounds:    dv.BucketOptions.GetExplicit().GetBounds(),
+			})
+		}
+	}
+	return dps
+}
+
+// otlpLabelAttributes pairs keys with the set values, sorted by key.
+func otlpLabelAttributes(keys []*metricspb.LabelKey, values []*metricspb.LabelValue) []*otlpKeyValue {
+	attrs := make(map[string]*otlpKeyValue, len(keys))
+	for i, lk := range keys {
+		if i < len(values) && values[i].GetHasValue() {
+			attrs[lk.GetKey()] = otlpString(lk.GetKey(), values[i].Value)
+		}
+	}
+	return sortedOTLPKeyValues(attrs)
+}
+
+// indexOfResource returns the index of the first Resource of resources equal to rs, or -1.
+func indexOfResource(resources []*resourcepb.Resource, rs *resourcepb.Resource) int {
+	for i, r := range resources {
+		if ResourcePbEqual(r, rs) {
+			return i
+		}
+	}
+	return -1
+}
+
+func protoSpanToOTLPSpan(span *tracepb.Span) *otlpSpan {
+	out := &otlpSpan{
+		TraceID:                hex.EncodeToString(span.TraceId),
+		SpanID:                 hex.EncodeToString(span.SpanId),
+		TraceState:             otlpTraceState(span.Tracestate),
+		ParentSpanID:           hex.EncodeToString(span.ParentSpanId),
+		Name:                   span.Name.GetValue(),
+		StartTimeUnixNano:      otlpTime(span.StartTime),
+		EndTimeUnixNano:        otlpTime(span.EndTime),
+		Attributes:             otlpSpanAttributes(span.Attributes),
+		DroppedAttributesCount: span.Attributes.GetDroppedAttributesCount(),
+		DroppedEventsCount:     span.TimeEvents.GetDroppedAnnotationsCount() + span.TimeEvents.GetDroppedMessageEventsCount(),
+		DroppedLinksCount:      span.Links.GetDroppedLinksCount(),
+	}
+	switch span.Kind {
+	case tracepb.Span_SERVER:
+		out.Kind = otlpSpanKindServer
+	case tracepb.Span_CLIENT:
+		out.Kind = otlpSpanKindClient
+	default:
+		out.Kind = otlpSpanKindUnspecified
+	}
+	if span.Status != nil {
+		out.Status = &otlpStatus{Message: span.Status.Message}
+		if span.Status.Code != 0 {
+			out.Status.Code = otlpStatusCodeError
+		}
+	}
+	for _, te := range span.TimeEvents.GetTimeEvent() {
+		if event := protoTimeEventToOTLPEvent(te); event != nil {
+			out.Events = append(out.Events, event)
+		}
+	}
+	for _, link := range span.Links.GetLink() {
+		if link == nil {
+			continue
+		}
+		out.Links = append(out.Links, &otlpLink{
+			TraceID:                hex.EncodeToString(link.TraceId),
+			SpanID:                 hex.EncodeToString(link.SpanId),
+			TraceState:             otlpTraceState(link.Tracestate),
+			Attributes:             otlpSpanAttributes(link.Attributes),
+			DroppedAttributesCount: link.Attributes.GetDroppedAttributesCount(),
+		})
+	}
+	return out
+}
+
+func protoTimeEventToOTLPEvent(te *tracepb.Span_TimeEvent) *otlpEvent {
+	if te == nil {
+		return nil
+	}
+	if a := te.GetAnnotation(); a != nil {
+		return &otlpEvent{
+			TimeUnixNano:           otlpTime(te.Time),
+			Name:                   a.Description.GetValue(),
+			Attributes:             otlpSpanAttributes(a.Attributes),
+			DroppedAttributesCount: a.Attributes.GetDroppedAttributesCount(),
+		}
+	}
+	if me := te.GetMessageEvent(); me != nil {
+		return &otlpEvent{
+			TimeUnixNano: otlpTime(te.Time),
+			Name:         "message",
+			Attributes: []*otlpKeyValue{
+				otlpString("message.type", me.Type.String()),
+				otlpInt("message.id", int64(me.Id)),
+				otlpInt("message.uncompressed_size", int64(me.UncompressedSize)),
+				otlpInt("message.compressed_size", int64(me.CompressedSize)),
+			},
+		}
+	}
+	return nil
+}
+
+// otlpResourceAttributes returns the attributes describing node and resource, sorted by key.
+func otlpResourceAttributes(node *commonpb.Node, resource *resourcepb.Resource) []*otlpKeyValue {
+	attrs := make(map[string]*otlpKeyValue)
+	setString := func(key, value string) {
+		if value != "" {
+			attrs[key] = otlpString(key, value)
+		}
+	}
+	if node != nil {
+		for k, v := range node.Attributes {
+			setString(k, v)
+		}
+		setString("service.name", node.ServiceInfo.GetName())
+		setString("host.name", node.Identifier.GetHostName())
+		if pid := node.Identifier.GetPid(); pid != 0 {
+			attrs["process.pid"] = otlpInt("process.pid", int64(pid))
+		}
+		if node.LibraryInfo != nil {
+			setString("telemetry.sdk.name", otlpScopeName)
+			setString("telemetry.sdk.language", otlpLanguages[node.LibraryInfo.Language])
+			setString("telemetry.sdk.version", node.LibraryInfo.CoreLibraryVersion)
+		}
+	}
+	if resource != nil {
+		for k, v := range resource.Labels {
+			setString(k, v)
+		}
+		setString("opencensus.resource.type", resource.Type)
+	}
+	return sortedOTLPKeyValues(attrs)
+}
+
+func otlpSpanAttributes(attrs *tracepb.Span_Attributes) []*otlpKeyValue {
+	kvs := make(map[string]*otlpKeyValue, len(attrs.GetAttributeMap()))
+	for k, av := range attrs.GetAttributeMap() {
+		kv := &otlpKeyValue{Key: k}
+		switch v := av.GetValue().(type) {
+		case *tracepb.AttributeValue_StringValue:
+			s := v.StringValue.GetValue()
+			kv.Value.StringValue = &s
+		case *tracepb.AttributeValue_IntValue:
+			kv = otlpInt(k, v.IntValue)
+		case *tracepb.AttributeValue_BoolValue:
+			b := v.BoolValue
+			kv.Value.BoolValue = &b
+		case *tracepb.AttributeValue_DoubleValue:
+			d := v.DoubleValue
+			kv.Value.DoubleValue = &d
+		default:
+			continue
+		}
+		kvs[k] = kv
+	}
+	return sortedOTLPKeyValues(kvs)
+}
+
+func sortedOTLPKeyValues(kvs map[string]*otlpKeyValue) []*otlpKeyValue {
+	if len(kvs) == 0 {
+		return nil
+	}
+	sorted := make([]*otlpKeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		sorted = append(sorted, kv)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return sorted
+}
+
+func otlpString(key, value string) *otlpKeyValue {
+	return &otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int64) *otlpKeyValue {
+	s := strconv.FormatInt(value, 10)
+	return &otlpKeyValue{Key: key, Value: otlpAnyValue{IntValue: &s}}
+}
+
+// otlpTime returns ts in nanoseconds since the epoch, or "" if ts is nil.
+func otlpTime(ts *timestamp.Timestamp) string {
+	if ts == nil {
+		return ""
+	}
+	return strconv.FormatInt(ts.Seconds*1e9+int64(ts.Nanos), 10)
+}
+
+// otlpTraceState returns the W3C encoding of ts, such as "k1=v1,k2=v2".
+func otlpTraceState(ts *tracepb.Span_Tracestate) string {
+	entries := ts.GetEntries()
+	pairs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		pairs = append(pairs, e.Key+"="+e.Value)
+	}
+	return strings.Join(pairs, ",")
+}
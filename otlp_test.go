@@ -0,0 +1,247 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+	resourcepb "github.com/orijtech/ocagent_structs_no_grpc/pb/resource/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+func TestToOTLPTraceJSON(t *testing.T) {
+	req := &agenttracepb.ExportTraceServiceRequest{
+		Node:     &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}},
+		Resource: &resourcepb.Resource{Type: "k8s", Labels: map[string]string{"zone": "z1"}},
+		Spans: []*tracepb.Span{{
+			TraceId:      []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F},
+			SpanId:       []byte{0xFF, 0xFE, 0xFD, 0xFC, 0xFB, 0xFA, 0xF9, 0xF8},
+			ParentSpanId: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08},
+			Name:         &tracepb.TruncatableString{Value: "/search"},
+			Kind:         tracepb.Span_SERVER,
+			StartTime:    &timestamp.Timestamp{Seconds: 1, Nanos: 5},
+			EndTime:      &timestamp.Timestamp{Seconds: 2},
+			Status:       &tracepb.Status{Code: 5, Message: "not found"},
+			Attributes: &tracepb.Span_Attributes{
+				AttributeMap: map[string]*tracepb.AttributeValue{
+					"http.status_code": {Value: &tracepb.AttributeValue_IntValue{IntValue: 404}},
+				},
+			},
+		}},
+	}
+
+	blob, err := ocagent.ToOTLPTraceJSON(req)
+	if err != nil {
+		t.Fatalf("ToOTLPTraceJSON: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(blob, &got); err != nil {
+		t.Fatalf("Failed to unmarshal %s: %v", blob, err)
+	}
+	want := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []interface{}{
+						map[string]interface{}{"key": "opencensus.resource.type", "value": map[string]interface{}{"stringValue": "k8s"}},
+						map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "svc"}},
+						map[string]interface{}{"key": "zone", "value": map[string]interface{}{"stringValue": "z1"}},
+					},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "opencensus"},
+						"spans": []interface{}{
+							map[string]interface{}{
+								"traceId":           "000102030405060708090a0b0c0d0e0f",
+								"spanId":            "fffefdfcfbfaf9f8",
+								"parentSpanId":      "0102030405060708",
+								"name":              "/search",
+								"kind":              float64(2),
+								"startTimeUnixNano": "1000000005",
+								"endTimeUnixNano":   "2000000000",
+								"attributes": []interface{}{
+									map[string]interface{}{"key": "http.status_code", "value": map[string]interface{}{"intValue": "404"}},
+								},
+								"status": map[string]interface{}{"code": float64(2), "message": "not found"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Mismatch\nGot:  %s\nWant: %v", blob, want)
+	}
+
+	if _, err := ocagent.ToOTLPTraceJSON(nil); err == nil {
+		t.Error("Expected an error for a nil request")
+	}
+}
+
+func TestToOTLPMetricsJSON(t *testing.T) {
+	startTimestamp := &timestamp.Timestamp{Seconds: 1}
+	endTimestamp := &timestamp.Timestamp{Seconds: 2}
+	req := &agentmetricspb.ExportMetricsServiceRequest{
+		Node: &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}},
+		Metrics: []*metricspb.Metric{
+			{
+				MetricDescriptor: &metricspb.MetricDescriptor{
+					Name:      "queue_length",
+					Unit:      "1",
+					Type:      metricspb.MetricDescriptor_GAUGE_INT64,
+					LabelKeys: []*metricspb.LabelKey{{Key: "queue"}, {Key: "unset"}},
+				},
+				Timeseries: []*metricspb.TimeSeries{{
+					LabelValues: []*metricspb.LabelValue{{Value: "jobs", HasValue: true}, {}},
+					Points: []*metricspb.Point{{
+						Timestamp: endTimestamp,
+						Value:     &metricspb.Point_Int64Value{Int64Value: 7},
+					}},
+				}},
+			},
+			{
+				MetricDescriptor: &metricspb.MetricDescriptor{
+					Name:        "latency",
+					Description: "Request latency",
+					Unit:        "ms",
+					Type:        metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION,
+				},
+				Timeseries: []*metricspb.TimeSeries{{
+					StartTimestamp: startTimestamp,
+					Points: []*metricspb.Point{{
+						Timestamp: endTimestamp,
+						Value: &metricspb.Point_DistributionValue{
+							DistributionValue: &metricspb.DistributionValue{
+								Count: 3,
+								Sum:   45,
+								BucketOptions: &metricspb.DistributionValue_BucketOptions{
+									Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
+										Explicit: &metricspb.DistributionValue_BucketOptions_Explicit{Bounds: []float64{10, 20}},
+									},
+								},
+								Buckets: []*metricspb.DistributionValue_Bucket{{Count: 1}, {Count: 1}, {Count: 1}},
+							},
+						},
+					}},
+				}},
+			},
+			{
+				MetricDescriptor: &metricspb.MetricDescriptor{Name: "rpc_summary", Type: metricspb.MetricDescriptor_SUMMARY},
+			},
+		},
+	}
+
+	blob, err := ocagent.ToOTLPMetricsJSON(req)
+	if err != nil {
+		t.Fatalf("ToOTLPMetricsJSON: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(blob, &got); err != nil {
+		t.Fatalf("Failed to unmarshal %s: %v", blob, err)
+	}
+	want := map[string]interface{}{
+		"resourceMetrics": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []interface{}{
+						map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "svc"}},
+					},
+				},
+				"scopeMetrics": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "opencensus"},
+						"metrics": []interface{}{
+							map[string]interface{}{
+								"name": "queue_length",
+								"unit": "1",
+								"gauge": map[string]interface{}{
+									"dataPoints": []interface{}{
+										map[string]interface{}{
+											"attributes": []interface{}{
+												map[string]interface{}{"key": "queue", "value": map[string]interface{}{"stringValue": "jobs"}},
+											},
+											"timeUnixNano": "2000000000",
+											"asInt":        "7",
+										},
+									},
+								},
+							},
+							map[string]interface{}{
+								"name":        "latency",
+								"description": "Request latency",
+								"unit":        "ms",
+								"histogram": map[string]interface{}{
+									"aggregationTemporality": float64(2),
+									"dataPoints": []interface{}{
+										map[string]interface{}{
+											"startTimeUnixNano": "1000000000",
+											"timeUnixNano":      "2000000000",
+											"count":             "3",
+											"sum":               float64(45),
+											"bucketCounts":      []interface{}{"1", "1", "1"},
+											"explicitBounds":    []interface{}{float64(10), float64(20)},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Mismatch\nGot:  %s\nWant: %v", blob, want)
+	}
+
+	// Gauge distributions are deltas over the interval of each point.
+	req.Metrics[1].MetricDescriptor.Type = metricspb.MetricDescriptor_GAUGE_DISTRIBUTION
+	if blob, err = ocagent.ToOTLPMetricsJSON(req); err != nil {
+		t.Fatalf("ToOTLPMetricsJSON: %v", err)
+	}
+	var gauge struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []struct {
+					Histogram *struct {
+						AggregationTemporality *int `json:"aggregationTemporality"`
+					} `json:"histogram"`
+				} `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+	if err := json.Unmarshal(blob, &gauge); err != nil {
+		t.Fatalf("Failed to unmarshal %s: %v", blob, err)
+	}
+	histogram := gauge.ResourceMetrics[0].ScopeMetrics[0].Metrics[1].Histogram
+	if histogram == nil || histogram.AggregationTemporality == nil || *histogram.AggregationTemporality != 1 {
+		t.Errorf("Gauge distribution: expected a histogram with delta temporality, got %s", blob)
+	}
+
+	if _, err := ocagent.ToOTLPMetricsJSON(nil); err == nil {
+		t.Error("Expected an error for a nil request")
+	}
+}
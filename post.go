@@ -0,0 +1,101 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+)
+
+// Format is the encoding of requests posted to the agent.
+type Format int
+
+const (
+	// FormatJSON encodes requests with jsonpb, as "application/json".
+	FormatJSON Format = iota
+	// FormatProtobuf encodes requests in the protobuf wire format,
+	// as "application/x-protobuf".
+	FormatProtobuf
+)
+
+// contentType returns the Content-Type of requests encoded in f.
+func (f Format) contentType() (string, error) {
+	switch f {
+	case FormatJSON:
+		return "application/json", nil
+	case FormatProtobuf:
+		return "application/x-protobuf", nil
+	default:
+		return "", fmt.Errorf("unknown format %d", f)
+	}
+}
+
+// PostTraceRequest encodes req in format and POSTs it to baseURL+"/v1/trace",
+// for example "http://localhost:55678", with the matching Content-Type.
+// A nil client uses http.DefaultClient. The request is canceled when ctx is
+// done. Callers must close the body of the returned response.
+func PostTraceRequest(ctx context.Context, client *http.Client, baseURL string, req *agenttracepb.ExportTraceServiceRequest, format Format) (*http.Response, error) {
+	buf := new(bytes.Buffer)
+	var err error
+	switch format {
+	case FormatJSON:
+		err = WriteTraceRequestJSON(buf, req)
+	case FormatProtobuf:
+		err = WriteTraceRequestProto(buf, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return post(ctx, client, baseURL+"/v1/trace", buf, format)
+}
+
+// PostMetricsRequest is like PostTraceRequest, for metrics
+// requests, which are posted to baseURL+"/v1/metrics".
+func PostMetricsRequest(ctx context.Context, client *http.Client, baseURL string, req *agentmetricspb.ExportMetricsServiceRequest, format Format) (*http.Response, error) {
+	buf := new(bytes.Buffer)
+	var err error
+	switch format {
+	case FormatJSON:
+		err = WriteMetricsRequestJSON(buf, req)
+	case FormatProtobuf:
+		err = WriteMetricsRequestProto(buf, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return post(ctx, client, baseURL+"/v1/metrics", buf, format)
+}
+
+func post(ctx context.Context, client *http.Client, url string, body *bytes.Buffer, format Format) (*http.Response, error) {
+	contentType, err := format.contentType()
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest("POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", contentType)
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(httpReq)
+}
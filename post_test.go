@@ -0,0 +1,120 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/orijtech/ocagent_structs_no_grpc"
+
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+type recordedRequest struct {
+	path        string
+	contentType string
+	body        []byte
+}
+
+func newRecordingServer(t *testing.T) (*httptest.Server, <-chan recordedRequest) {
+	reqs := make(chan recordedRequest, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("Failed to read the body: %v", err)
+		}
+		reqs <- recordedRequest{path: r.URL.Path, contentType: r.Header.Get("Content-Type"), body: body}
+	}))
+	return srv, reqs
+}
+
+func TestPostTraceRequest(t *testing.T) {
+	srv, recorded := newRecordingServer(t)
+	defer srv.Close()
+	req := &agenttracepb.ExportTraceServiceRequest{
+		Spans: []*tracepb.Span{{Name: &tracepb.TruncatableString{Value: "span"}}},
+	}
+
+	res, err := ocagent.PostTraceRequest(context.Background(), srv.Client(), srv.URL, req, ocagent.FormatJSON)
+	if err != nil {
+		t.Fatalf("JSON: unexpected error: %v", err)
+	}
+	res.Body.Close()
+	got := <-recorded
+	if got.path != "/v1/trace" || got.contentType != "application/json" {
+		t.Errorf("JSON: got path %q and content type %q", got.path, got.contentType)
+	}
+	if fromJSON, err := ocagent.UnmarshalTraceRequestJSON(got.body); err != nil || !proto.Equal(fromJSON, req) {
+		t.Errorf("JSON: got body %q, error %v", got.body, err)
+	}
+
+	res, err = ocagent.PostTraceRequest(context.Background(), srv.Client(), srv.URL, req, ocagent.FormatProtobuf)
+	if err != nil {
+		t.Fatalf("Protobuf: unexpected error: %v", err)
+	}
+	res.Body.Close()
+	got = <-recorded
+	if got.path != "/v1/trace" || got.contentType != "application/x-protobuf" {
+		t.Errorf("Protobuf: got path %q and content type %q", got.path, got.contentType)
+	}
+	fromProto := new(agenttracepb.ExportTraceServiceRequest)
+	if err := proto.Unmarshal(got.body, fromProto); err != nil || !proto.Equal(fromProto, req) {
+		t.Errorf("Protobuf: got body %v, error %v", fromProto, err)
+	}
+}
+
+func TestPostMetricsRequest(t *testing.T) {
+	srv, recorded := newRecordingServer(t)
+	defer srv.Close()
+	req := &agentmetricspb.ExportMetricsServiceRequest{
+		Metrics: []*metricspb.Metric{{MetricDescriptor: &metricspb.MetricDescriptor{Name: "ocagent.io/calls"}}},
+	}
+
+	res, err := ocagent.PostMetricsRequest(context.Background(), srv.Client(), srv.URL, req, ocagent.FormatProtobuf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	res.Body.Close()
+	got := <-recorded
+	if got.path != "/v1/metrics" || got.contentType != "application/x-protobuf" {
+		t.Errorf("Got path %q and content type %q", got.path, got.contentType)
+	}
+	fromProto := new(agentmetricspb.ExportMetricsServiceRequest)
+	if err := proto.Unmarshal(got.body, fromProto); err != nil || !proto.Equal(fromProto, req) {
+		t.Errorf("Got body %v, error %v", fromProto, err)
+	}
+}
+
+func TestPostTraceRequest_canceled(t *testing.T) {
+	srv, _ := newRecordingServer(t)
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := ocagent.PostTraceRequest(ctx, srv.Client(), srv.URL, new(agenttracepb.ExportTraceServiceRequest), ocagent.FormatJSON); err == nil {
+		t.Error("Expected an error for a canceled context")
+	}
+	if _, err := ocagent.PostTraceRequest(context.Background(), srv.Client(), srv.URL, new(agenttracepb.ExportTraceServiceRequest), ocagent.Format(42)); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
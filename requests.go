@@ -0,0 +1,574 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+	resourcepb "github.com/orijtech/ocagent_structs_no_grpc/pb/resource/v1"
+)
+
+var errInconsistentNode = errors.New("requests have different Nodes")
+
+// SplitTraceRequestByResource splits req into one request per distinct Span.Resource,
+// in the order in which each resource is first encountered. Every resulting
+// request carries the Node and Resource of req.
+func SplitTraceRequestByResource(req *agenttracepb.ExportTraceServiceRequest) []*agenttracepb.ExportTraceServiceRequest {
+	if req == nil {
+		return nil
+	}
+
+	var reqs []*agenttracepb.ExportTraceServiceRequest
+	indexByKey := make(map[string]int)
+	for _, span := range req.Spans {
+		if span == nil {
+			continue
+		}
+		key := resourceKey(span.Resource)
+		i, ok := indexByKey[key]
+		if !ok {
+			i = len(reqs)
+			indexByKey[key] = i
+			reqs = append(reqs, &agenttracepb.ExportTraceServiceRequest{
+				Node:     req.Node,
+				Resource: req.Resource,
+			})
+		}
+		reqs[i].Spans = append(reqs[i].Spans, span)
+	}
+	return reqs
+}
+
+// SplitMetricsRequestByResource splits req into one request per distinct resource,
+// in the order in which each resource is first encountered. The resource of each
+// metric is its Resource or, if nil, the Resource of req, such that metrics without
+// a Resource form a default group with the request-level Resource. Every resulting
+// request has the resource of its group as its Resource, carries the Node of req,
+// and holds copies of the metrics with their Resource cleared. req is not modified.
+func SplitMetricsRequestByResource(req *agentmetricspb.ExportMetricsServiceRequest) []*agentmetricspb.ExportMetricsServiceRequest {
+	if req == nil {
+		return nil
+	}
+
+	var reqs []*agentmetricspb.ExportMetricsServiceRequest
+	indexByKey := make(map[string]int)
+	for _, metric := range req.Metrics {
+		if metric == nil {
+			continue
+		}
+		rs := metric.Resource
+		if rs == nil {
+			rs = req.Resource
+		}
+		key := resourceKey(rs)
+		i, ok := indexByKey[key]
+		if !ok {
+			i = len(reqs)
+			indexByKey[key] = i
+			reqs = append(reqs, &agentmetricspb.ExportMetricsServiceRequest{
+				Node:     req.Node,
+				Resource: rs,
+			})
+		}
+		reqs[i].Metrics = append(reqs[i].Metrics, &metricspb.Metric{
+			MetricDescriptor: metric.MetricDescriptor,
+			Timeseries:       metric.Timeseries,
+		})
+	}
+	return reqs
+}
+
+// resourceKey returns a canonical key for rp, such that two resources
+// with the same Type and Labels produce the same key regardless of
+// the iteration order of their Labels.
+func resourceKey(rp *resourcepb.Resource) string {
+	if rp == nil {
+		return ""
+	}
+
+	keys := make([]string, 0, len(rp.Labels))
+	for key := range rp.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(strconv.Quote(rp.Type))
+	for _, key := range keys {
+		sb.WriteByte(';')
+		sb.WriteString(strconv.Quote(key))
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(rp.Labels[key]))
+	}
+	return sb.String()
+}
+
+// ResourceFingerprint returns a 64-bit FNV-1a hash of the Type and the
+// sorted Labels of rp, for deduplicating resources. Resources with the same
+// Type and Labels have the same fingerprint. A nil rp has a zero fingerprint.
+func ResourceFingerprint(rp *resourcepb.Resource) uint64 {
+	if rp == nil {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(resourceKey(rp)))
+	return h.Sum64()
+}
+
+// SetNodeOnAll sets node on every request in reqs.
+func SetNodeOnAll(reqs []*agenttracepb.ExportTraceServiceRequest, node *commonpb.Node) {
+	for _, req := range reqs {
+		if req != nil {
+			req.Node = node
+		}
+	}
+}
+
+// SetNodeOnFirst sets node on the first non-nil request in reqs and
+// clears the Node of the rest, since the agent only needs the Node to
+// be sent once per stream.
+func SetNodeOnFirst(reqs []*agenttracepb.ExportTraceServiceRequest, node *commonpb.Node) {
+	set := false
+	for _, req := range reqs {
+		if req == nil {
+			continue
+		}
+		if !set {
+			req.Node = node
+			set = true
+		} else {
+			req.Node = nil
+		}
+	}
+}
+
+// CloneTraceRequest returns a deep copy of req, which can then be
+// modified concurrently with req. A nil req yields nil.
+func CloneTraceRequest(req *agenttracepb.ExportTraceServiceRequest) *agenttracepb.ExportTraceServiceRequest {
+	if req == nil {
+		return nil
+	}
+	return proto.Clone(req).(*agenttracepb.ExportTraceServiceRequest)
+}
+
+// CloneMetricsRequest is like CloneTraceRequest but for metrics requests.
+func CloneMetricsRequest(req *agentmetricspb.ExportMetricsServiceRequest) *agentmetricspb.ExportMetricsServiceRequest {
+	if req == nil {
+		return nil
+	}
+	return proto.Clone(req).(*agentmetricspb.ExportMetricsServiceRequest)
+}
+
+// StripNode returns a shallow clone of req without its Node, for replaying
+// stored requests onto a stream on which the Node was already sent.
+// The Spans and Resource are shared with req, which is not modified.
+func StripNode(req *agenttracepb.ExportTraceServiceRequest) *agenttracepb.ExportTraceServiceRequest {
+	if req == nil {
+		return nil
+	}
+	return &agenttracepb.ExportTraceServiceRequest{
+		Spans:    req.Spans,
+		Resource: req.Resource,
+	}
+}
+
+// StripMetricsNode is like StripNode but for metrics requests.
+func StripMetricsNode(req *agentmetricspb.ExportMetricsServiceRequest) *agentmetricspb.ExportMetricsServiceRequest {
+	if req == nil {
+		return nil
+	}
+	return &agentmetricspb.ExportMetricsServiceRequest{
+		Metrics:  req.Metrics,
+		Resource: req.Resource,
+	}
+}
+
+// SplitDescriptorsAndData separates the metric descriptors in req from its data,
+// for agents that accept descriptor registration separately. It returns the
+// unique descriptors by name, in the order first encountered, and a request
+// whose metrics only carry the names of their descriptors. req is not modified.
+func SplitDescriptorsAndData(req *agentmetricspb.ExportMetricsServiceRequest) (descriptors []*metricspb.MetricDescriptor, data *agentmetricspb.ExportMetricsServiceRequest) {
+	if req == nil {
+		return nil, nil
+	}
+
+	data = &agentmetricspb.ExportMetricsServiceRequest{
+		Node:     req.Node,
+		Resource: req.Resource,
+		Metrics:  make([]*metricspb.Metric, 0, len(req.Metrics)),
+	}
+	seen := make(map[string]bool)
+	for _, metric := range req.Metrics {
+		if metric == nil {
+			continue
+		}
+		var name string
+		if desc := metric.MetricDescriptor; desc != nil {
+			name = desc.Name
+			if !seen[name] {
+				seen[name] = true
+				descriptors = append(descriptors, desc)
+			}
+		}
+		data.Metrics = append(data.Metrics, &metricspb.Metric{
+			MetricDescriptor: &metricspb.MetricDescriptor{Name: name},
+			Timeseries:       metric.Timeseries,
+			Resource:         metric.Resource,
+		})
+	}
+	return descriptors, data
+}
+
+// NodeSpanSkew returns the difference between the earliest span StartTime in req
+// and the StartTimestamp of req's Node, which helps diagnose clock drift. The
+// boolean is false if req has no Node start time or no span with a StartTime.
+func NodeSpanSkew(req *agenttracepb.ExportTraceServiceRequest) (time.Duration, bool) {
+	if req == nil || req.Node == nil || req.Node.Identifier == nil || req.Node.Identifier.StartTimestamp == nil {
+		return 0, false
+	}
+
+	var earliest time.Time
+	for _, span := range req.Spans {
+		if span == nil || span.StartTime == nil {
+			continue
+		}
+		if st := ProtoToTime(span.StartTime); earliest.IsZero() || st.Before(earliest) {
+			earliest = st
+		}
+	}
+	if earliest.IsZero() {
+		return 0, false
+	}
+	return earliest.Sub(ProtoToTime(req.Node.Identifier.StartTimestamp)), true
+}
+
+// SplitMetricsByLabel splits the timeseries in req by their value for labelKey,
+// for example to route them by tenant. The returned requests are keyed by label
+// value and their metrics no longer carry labelKey. Timeseries without a value
+// for labelKey, including those of metrics that don't have labelKey, are keyed
+// by the empty string. req is not modified.
+func SplitMetricsByLabel(req *agentmetricspb.ExportMetricsServiceRequest, labelKey string) map[string]*agentmetricspb.ExportMetricsServiceRequest {
+	if req == nil {
+		return nil
+	}
+
+	splits := make(map[string]*agentmetricspb.ExportMetricsServiceRequest)
+	for _, metric := range req.Metrics {
+		if metric == nil || metric.MetricDescriptor == nil {
+			continue
+		}
+
+		keyIndex := -1
+		for i, lk := range metric.MetricDescriptor.LabelKeys {
+			if lk.GetKey() == labelKey {
+				keyIndex = i
+				break
+			}
+		}
+
+		var descriptor *metricspb.MetricDescriptor
+		if keyIndex < 0 {
+			descriptor = metric.MetricDescriptor
+		} else {
+			descriptor = proto.Clone(metric.MetricDescriptor).(*metricspb.MetricDescriptor)
+			descriptor.LabelKeys = removeLabelKeyAt(descriptor.LabelKeys, keyIndex)
+		}
+
+		metricsByValue := make(map[string]*metricspb.Metric)
+		for _, ts := range metric.Timeseries {
+			if ts == nil {
+				continue
+			}
+			var value string
+			if keyIndex >= 0 && keyIndex < len(ts.LabelValues) {
+				value = ts.LabelValues[keyIndex].GetValue()
+				ts = &metricspb.TimeSeries{
+					StartTimestamp: ts.StartTimestamp,
+					LabelValues:    removeLabelValueAt(ts.LabelValues, keyIndex),
+					Points:         ts.Points,
+				}
+			}
+
+			split := metricsByValue[value]
+			if split == nil {
+				split = &metricspb.Metric{
+					MetricDescriptor: descriptor,
+					Resource:         metric.Resource,
+				}
+				metricsByValue[value] = split
+
+				splitReq := splits[value]
+				if splitReq == nil {
+					splitReq = &agentmetricspb.ExportMetricsServiceRequest{
+						Node:     req.Node,
+						Resource: req.Resource,
+					}
+					splits[value] = splitReq
+				}
+				splitReq.Metrics = append(splitReq.Metrics, split)
+			}
+			split.Timeseries = append(split.Timeseries, ts)
+		}
+	}
+	return splits
+}
+
+func removeLabelKeyAt(keys []*metricspb.LabelKey, i int) []*metricspb.LabelKey {
+	out := make([]*metricspb.LabelKey, 0, len(keys)-1)
+	out = append(out, keys[:i]...)
+	return append(out, keys[i+1:]...)
+}
+
+func removeLabelValueAt(values []*metricspb.LabelValue, i int) []*metricspb.LabelValue {
+	out := make([]*metricspb.LabelValue, 0, len(values)-1)
+	out = append(out, values[:i]...)
+	return append(out, values[i+1:]...)
+}
+
+// ProtoMetricsToRequest wraps already converted metrics, for example from the
+// metricexport pipeline, into an ExportMetricsServiceRequest. Both node and rs
+// are optional. A nil metrics slice yields an empty request.
+func ProtoMetricsToRequest(metrics []*metricspb.Metric, node *commonpb.Node, rs *resourcepb.Resource) *agentmetricspb.ExportMetricsServiceRequest {
+	return &agentmetricspb.ExportMetricsServiceRequest{
+		Node:     node,
+		Metrics:  metrics,
+		Resource: rs,
+	}
+}
+
+// ProtoMetricsToRequestStrict is like ProtoMetricsToRequest, but returns
+// an error if node is set yet fails ValidateNode.
+func ProtoMetricsToRequestStrict(metrics []*metricspb.Metric, node *commonpb.Node, rs *resourcepb.Resource) (*agentmetricspb.ExportMetricsServiceRequest, error) {
+	if node != nil {
+		if err := ValidateNode(node); err != nil {
+			return nil, err
+		}
+	}
+	return ProtoMetricsToRequest(metrics, node, rs), nil
+}
+
+// MergeMetricsRequests merges reqs into a single request, concatenating their
+// metrics. The Node of all requests must be the same, except that requests
+// without a Node are allowed. Metrics are deduplicated by descriptor name:
+// those with the same name and Resource are merged into a single metric,
+// while metrics with the same name but conflicting descriptors are an error.
+// If the requests have different Resources, the merged request has none and
+// instead each metric without a Resource gets that of its request.
+// The reqs are not modified.
+func MergeMetricsRequests(reqs ...*agentmetricspb.ExportMetricsServiceRequest) (*agentmetricspb.ExportMetricsServiceRequest, error) {
+	merged := new(agentmetricspb.ExportMetricsServiceRequest)
+	sameResource, seenReq := true, false
+	for _, req := range reqs {
+		if req == nil {
+			continue
+		}
+		if req.Node != nil {
+			if merged.Node == nil {
+				merged.Node = req.Node
+			} else if !proto.Equal(merged.Node, req.Node) {
+				return nil, errInconsistentNode
+			}
+		}
+		if !seenReq {
+			merged.Resource, seenReq = req.Resource, true
+		} else if (req.Resource == nil) != (merged.Resource == nil) || resourceKey(req.Resource) != resourceKey(merged.Resource) {
+			sameResource = false
+		}
+	}
+	if !sameResource {
+		merged.Resource = nil
+	}
+
+	descriptors := make(map[string]*metricspb.MetricDescriptor)
+	byKey := make(map[string]*metricspb.Metric)
+	for _, req := range reqs {
+		if req == nil {
+			continue
+		}
+		for _, metric := range req.Metrics {
+			if metric == nil {
+				continue
+			}
+			rs := metric.Resource
+			if rs == nil && !sameResource {
+				rs = req.Resource
+			}
+			out := &metricspb.Metric{
+				MetricDescriptor: metric.MetricDescriptor,
+				Timeseries:       append([]*metricspb.TimeSeries(nil), metric.Timeseries...),
+				Resource:         rs,
+			}
+
+			name := metric.GetMetricDescriptor().GetName()
+			if name == "" {
+				merged.Metrics = append(merged.Metrics, out)
+				continue
+			}
+			if descriptor, ok := descriptors[name]; !ok {
+				descriptors[name] = metric.MetricDescriptor
+			} else if !proto.Equal(descriptor, metric.MetricDescriptor) {
+				return nil, fmt.Errorf("metric %q has conflicting descriptors", name)
+			} else {
+				out.MetricDescriptor = descriptor
+			}
+
+			key := name + "\x00" + resourceKey(rs)
+			if prev, ok := byKey[key]; ok {
+				prev.Timeseries = append(prev.Timeseries, out.Timeseries...)
+				continue
+			}
+			byKey[key] = out
+			merged.Metrics = append(merged.Metrics, out)
+		}
+	}
+	return merged, nil
+}
+
+// Metric type families returned by PartitionMetricsByFamily.
+const (
+	metricFamilyGauge      = "gauge"
+	metricFamilyCumulative = "cumulative"
+	metricFamilySummary    = "summary"
+)
+
+// PartitionMetricsByFamily groups the metrics of req by the family of their
+// descriptor type, under the keys "gauge", "cumulative" and "summary", for
+// routing to different backends. Only families with metrics are present. Each
+// resulting request carries the Node and Resource of req. Metrics with an
+// unspecified type are dropped. req is not modified.
+func PartitionMetricsByFamily(req *agentmetricspb.ExportMetricsServiceRequest) map[string]*agentmetricspb.ExportMetricsServiceRequest {
+	if req == nil {
+		return nil
+	}
+
+	partitions := make(map[string]*agentmetricspb.ExportMetricsServiceRequest)
+	for _, metric := range req.Metrics {
+		if metric == nil {
+			continue
+		}
+		var family string
+		switch metric.GetMetricDescriptor().GetType() {
+		case metricspb.MetricDescriptor_GAUGE_INT64, metricspb.MetricDescriptor_GAUGE_DOUBLE,
+			metricspb.MetricDescriptor_GAUGE_DISTRIBUTION:
+			family = metricFamilyGauge
+		case metricspb.MetricDescriptor_CUMULATIVE_INT64, metricspb.MetricDescriptor_CUMULATIVE_DOUBLE,
+			metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION:
+			family = metricFamilyCumulative
+		case metricspb.MetricDescriptor_SUMMARY:
+			family = metricFamilySummary
+		default:
+			logDrop(dropReasonUnspecifiedMetricType, "metric %q", metric.GetMetricDescriptor().GetName())
+			continue
+		}
+
+		partition, ok := partitions[family]
+		if !ok {
+			partition = &agentmetricspb.ExportMetricsServiceRequest{
+				Node:     req.Node,
+				Resource: req.Resource,
+			}
+			partitions[family] = partition
+		}
+		partition.Metrics = append(partition.Metrics, metric)
+	}
+	return partitions
+}
+
+// RequestSummary returns counts describing req, suitable for emitting telemetry
+// about the exporter itself. Every summary has the encoded size under "bytes".
+// Trace requests additionally have "spans" and "attributes", the latter
+// counting the attributes of spans, annotations and links. Metrics requests
+// additionally have "metrics", "timeseries" and "points". A nil req or one
+// of any other type only has "bytes".
+func RequestSummary(req proto.Message) map[string]int {
+	summary := map[string]int{"bytes": 0}
+	switch req := req.(type) {
+	case *agenttracepb.ExportTraceServiceRequest:
+		if req == nil {
+			return summary
+		}
+		summary["spans"] = 0
+		summary["attributes"] = 0
+		for _, span := range req.Spans {
+			if span == nil {
+				continue
+			}
+			summary["spans"]++
+			summary["attributes"] += len(span.GetAttributes().GetAttributeMap())
+			for _, te := range span.GetTimeEvents().GetTimeEvent() {
+				summary["attributes"] += len(te.GetAnnotation().GetAttributes().GetAttributeMap())
+			}
+			for _, link := range span.GetLinks().GetLink() {
+				summary["attributes"] += len(link.GetAttributes().GetAttributeMap())
+			}
+		}
+
+	case *agentmetricspb.ExportMetricsServiceRequest:
+		if req == nil {
+			return summary
+		}
+		summary["metrics"] = 0
+		summary["timeseries"] = 0
+		summary["points"] = 0
+		for _, metric := range req.Metrics {
+			if metric == nil {
+				continue
+			}
+			summary["metrics"]++
+			summary["timeseries"] += len(metric.Timeseries)
+			for _, ts := range metric.Timeseries {
+				summary["points"] += len(ts.GetPoints())
+			}
+		}
+
+	default:
+		if req == nil {
+			return summary
+		}
+	}
+	summary["bytes"] = proto.Size(req)
+	return summary
+}
+
+// CollectExemplars returns the exemplars of every bucket of every
+// distribution point in req, in the order in which they appear, for
+// exporting to exemplar-aware backends. The exemplars are shared with req.
+func CollectExemplars(req *agentmetricspb.ExportMetricsServiceRequest) []*metricspb.DistributionValue_Exemplar {
+	var exemplars []*metricspb.DistributionValue_Exemplar
+	for _, metric := range req.GetMetrics() {
+		for _, ts := range metric.GetTimeseries() {
+			for _, point := range ts.GetPoints() {
+				for _, bucket := range point.GetDistributionValue().GetBuckets() {
+					if exemplar := bucket.GetExemplar(); exemplar != nil {
+						exemplars = append(exemplars, exemplar)
+					}
+				}
+			}
+		}
+	}
+	return exemplars
+}
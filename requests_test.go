@@ -0,0 +1,667 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/orijtech/ocagent_structs_no_grpc"
+
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+	resourcepb "github.com/orijtech/ocagent_structs_no_grpc/pb/resource/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+func TestSplitTraceRequestByResource(t *testing.T) {
+	resourceV1 := &resourcepb.Resource{
+		Type:   "k8s",
+		Labels: map[string]string{"schema_url": "https://opencensus.io/schemas/1.0", "pod": "p1"},
+	}
+	resourceV2 := &resourcepb.Resource{
+		Type:   "k8s",
+		Labels: map[string]string{"schema_url": "https://opencensus.io/schemas/2.0", "pod": "p1"},
+	}
+	// Equal to resourceV1 but a distinct pointer.
+	resourceV1Copy := &resourcepb.Resource{
+		Type:   "k8s",
+		Labels: map[string]string{"pod": "p1", "schema_url": "https://opencensus.io/schemas/1.0"},
+	}
+
+	req := &agenttracepb.ExportTraceServiceRequest{
+		Resource: &resourcepb.Resource{Type: "request"},
+		Spans: []*tracepb.Span{
+			{Name: &tracepb.TruncatableString{Value: "a"}, Resource: resourceV1},
+			{Name: &tracepb.TruncatableString{Value: "b"}, Resource: resourceV2},
+			{Name: &tracepb.TruncatableString{Value: "c"}, Resource: resourceV1Copy},
+		},
+	}
+
+	reqs := ocagent.SplitTraceRequestByResource(req)
+	if g, w := len(reqs), 2; g != w {
+		t.Fatalf("Number of requests: got %d want %d", g, w)
+	}
+
+	wantNames := [][]string{{"a", "c"}, {"b"}}
+	for i, r := range reqs {
+		if r.Resource != req.Resource {
+			t.Errorf("#%d: expected the request-level Resource to be preserved", i)
+		}
+		var names []string
+		for _, span := range r.Spans {
+			names = append(names, span.Name.Value)
+		}
+		if g, w := len(names), len(wantNames[i]); g != w {
+			t.Errorf("#%d: got %d spans want %d", i, g, w)
+			continue
+		}
+		for j := range names {
+			if g, w := names[j], wantNames[i][j]; g != w {
+				t.Errorf("#%d: span #%d got %q want %q", i, j, g, w)
+			}
+		}
+	}
+}
+
+func TestSetNodeOnAll(t *testing.T) {
+	node := &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}}
+	reqs := []*agenttracepb.ExportTraceServiceRequest{{}, {}, {}}
+
+	ocagent.SetNodeOnAll(reqs, node)
+	for i, req := range reqs {
+		if req.Node != node {
+			t.Errorf("#%d: expected the node to be set", i)
+		}
+	}
+
+	// Must not panic on an empty slice.
+	ocagent.SetNodeOnAll(nil, node)
+}
+
+func TestSetNodeOnFirst(t *testing.T) {
+	node := &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}}
+	reqs := []*agenttracepb.ExportTraceServiceRequest{{}, {Node: node}, {Node: node}}
+
+	ocagent.SetNodeOnFirst(reqs, node)
+	if reqs[0].Node != node {
+		t.Error("Expected the node to be set on the first request")
+	}
+	for i, req := range reqs[1:] {
+		if req.Node != nil {
+			t.Errorf("#%d: expected the node to be cleared, got %v", i+1, req.Node)
+		}
+	}
+
+	// Must not panic on an empty slice.
+	ocagent.SetNodeOnFirst(nil, node)
+
+	// Leading nil requests are skipped.
+	reqs = []*agenttracepb.ExportTraceServiceRequest{nil, {}, {Node: node}}
+	ocagent.SetNodeOnFirst(reqs, node)
+	if reqs[1].Node != node {
+		t.Error("Expected the node to be set on the first non-nil request")
+	}
+	if reqs[2].Node != nil {
+		t.Errorf("Expected the node to be cleared on the last request, got %v", reqs[2].Node)
+	}
+}
+
+func TestSplitDescriptorsAndData(t *testing.T) {
+	latencyDesc := &metricspb.MetricDescriptor{
+		Name: "latency",
+		Unit: "ms",
+		Type: metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION,
+	}
+	foulsDesc := &metricspb.MetricDescriptor{
+		Name: "fouls",
+		Unit: "1",
+		Type: metricspb.MetricDescriptor_CUMULATIVE_INT64,
+	}
+	series := []*metricspb.TimeSeries{{}}
+	req := &agentmetricspb.ExportMetricsServiceRequest{
+		Metrics: []*metricspb.Metric{
+			{MetricDescriptor: latencyDesc, Timeseries: series},
+			{MetricDescriptor: foulsDesc},
+			{MetricDescriptor: latencyDesc},
+		},
+	}
+
+	descriptors, data := ocagent.SplitDescriptorsAndData(req)
+	if g, w := len(descriptors), 2; g != w {
+		t.Fatalf("Number of descriptors: got %d want %d", g, w)
+	}
+	if descriptors[0] != latencyDesc || descriptors[1] != foulsDesc {
+		t.Errorf("Unexpected descriptors: %v", descriptors)
+	}
+
+	if g, w := len(data.Metrics), 3; g != w {
+		t.Fatalf("Number of data metrics: got %d want %d", g, w)
+	}
+	for i, metric := range data.Metrics {
+		desc := metric.MetricDescriptor
+		if g, w := desc.Name, req.Metrics[i].MetricDescriptor.Name; g != w {
+			t.Errorf("#%d: Name: got %q want %q", i, g, w)
+		}
+		if desc.Unit != "" || desc.Type != metricspb.MetricDescriptor_UNSPECIFIED {
+			t.Errorf("#%d: expected only the descriptor name, got %+v", i, desc)
+		}
+	}
+	if len(data.Metrics[0].Timeseries) != len(series) {
+		t.Error("Expected the timeseries to be preserved")
+	}
+	if req.Metrics[0].MetricDescriptor.Unit != "ms" {
+		t.Error("The input request must not be modified")
+	}
+}
+
+func TestNodeSpanSkew(t *testing.T) {
+	nodeStart := time.Date(2019, 3, 14, 15, 9, 26, 0, time.UTC)
+	req := &agenttracepb.ExportTraceServiceRequest{
+		Node: ocagent.NodeWithStartTime("skew", nodeStart),
+		Spans: []*tracepb.Span{
+			{StartTime: timeToTimestamp(nodeStart.Add(5 * time.Second))},
+			{StartTime: timeToTimestamp(nodeStart.Add(1500 * time.Millisecond))},
+			{},
+		},
+	}
+
+	skew, ok := ocagent.NodeSpanSkew(req)
+	if !ok {
+		t.Fatal("Expected a skew to be computed")
+	}
+	if g, w := skew, 1500*time.Millisecond; g != w {
+		t.Errorf("Skew: got %v want %v", g, w)
+	}
+
+	if _, ok := ocagent.NodeSpanSkew(&agenttracepb.ExportTraceServiceRequest{Spans: req.Spans}); ok {
+		t.Error("Expected no skew without a Node")
+	}
+}
+
+func TestSplitMetricsByLabel(t *testing.T) {
+	newSeries := func(tenant, host string, value int64) *metricspb.TimeSeries {
+		return &metricspb.TimeSeries{
+			LabelValues: []*metricspb.LabelValue{
+				{Value: host, HasValue: true},
+				{Value: tenant, HasValue: true},
+			},
+			Points: []*metricspb.Point{{Value: &metricspb.Point_Int64Value{Int64Value: value}}},
+		}
+	}
+	req := &agentmetricspb.ExportMetricsServiceRequest{
+		Metrics: []*metricspb.Metric{
+			{
+				MetricDescriptor: &metricspb.MetricDescriptor{
+					Name:      "requests",
+					LabelKeys: []*metricspb.LabelKey{{Key: "host"}, {Key: "tenant"}},
+				},
+				Timeseries: []*metricspb.TimeSeries{
+					newSeries("acme", "h1", 1),
+					newSeries("globex", "h1", 2),
+					newSeries("acme", "h2", 3),
+				},
+			},
+		},
+	}
+
+	splits := ocagent.SplitMetricsByLabel(req, "tenant")
+	if g, w := len(splits), 2; g != w {
+		t.Fatalf("Number of splits: got %d want %d", g, w)
+	}
+
+	wantPoints := map[string][]int64{
+		"acme":   {1, 3},
+		"globex": {2},
+	}
+	for tenant, want := range wantPoints {
+		split := splits[tenant]
+		if split == nil || len(split.Metrics) != 1 {
+			t.Errorf("%q: expected one metric, got %v", tenant, split)
+			continue
+		}
+		metric := split.Metrics[0]
+		if g, w := len(metric.MetricDescriptor.LabelKeys), 1; g != w || metric.MetricDescriptor.LabelKeys[0].Key != "host" {
+			t.Errorf("%q: expected only the host label key, got %v", tenant, metric.MetricDescriptor.LabelKeys)
+		}
+		var got []int64
+		for _, ts := range metric.Timeseries {
+			if g, w := len(ts.LabelValues), 1; g != w {
+				t.Errorf("%q: got %d label values want %d", tenant, g, w)
+			}
+			got = append(got, ts.Points[0].GetInt64Value())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got points %v want %v", tenant, got, want)
+		}
+	}
+
+	if g, w := len(req.Metrics[0].MetricDescriptor.LabelKeys), 2; g != w {
+		t.Error("The input request must not be modified")
+	}
+}
+
+func TestProtoMetricsToRequest(t *testing.T) {
+	metrics := []*metricspb.Metric{
+		{MetricDescriptor: &metricspb.MetricDescriptor{Name: "a"}},
+		{MetricDescriptor: &metricspb.MetricDescriptor{Name: "b"}},
+	}
+	node := &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}}
+	rs := &resourcepb.Resource{Type: "host"}
+
+	req := ocagent.ProtoMetricsToRequest(metrics, node, rs)
+	if req.Node != node || req.Resource != rs {
+		t.Errorf("Expected the node and resource to be set, got %v", req)
+	}
+	if g, w := len(req.Metrics), len(metrics); g != w {
+		t.Fatalf("Number of metrics: got %d want %d", g, w)
+	}
+	for i := range metrics {
+		if req.Metrics[i] != metrics[i] {
+			t.Errorf("#%d: expected the metric to be unchanged", i)
+		}
+	}
+
+	empty := ocagent.ProtoMetricsToRequest(nil, nil, nil)
+	if empty == nil || len(empty.Metrics) != 0 || empty.Node != nil || empty.Resource != nil {
+		t.Errorf("Expected an empty request, got %v", empty)
+	}
+}
+
+func TestRequestSummary(t *testing.T) {
+	stringAttr := &tracepb.AttributeValue{Value: &tracepb.AttributeValue_StringValue{
+		StringValue: &tracepb.TruncatableString{Value: "v"},
+	}}
+	traceReq := &agenttracepb.ExportTraceServiceRequest{
+		Spans: []*tracepb.Span{
+			{
+				Attributes: &tracepb.Span_Attributes{AttributeMap: map[string]*tracepb.AttributeValue{"a": stringAttr, "b": stringAttr}},
+				TimeEvents: &tracepb.Span_TimeEvents{TimeEvent: []*tracepb.Span_TimeEvent{
+					{Value: &tracepb.Span_TimeEvent_Annotation_{Annotation: &tracepb.Span_TimeEvent_Annotation{
+						Attributes: &tracepb.Span_Attributes{AttributeMap: map[string]*tracepb.AttributeValue{"c": stringAttr}},
+					}}},
+				}},
+				Links: &tracepb.Span_Links{Link: []*tracepb.Span_Link{
+					{Attributes: &tracepb.Span_Attributes{AttributeMap: map[string]*tracepb.AttributeValue{"d": stringAttr}}},
+				}},
+			},
+			nil,
+			{Name: &tracepb.TruncatableString{Value: "no attributes"}},
+		},
+	}
+	got := ocagent.RequestSummary(traceReq)
+	want := map[string]int{"spans": 2, "attributes": 4, "bytes": proto.Size(traceReq)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Trace summary: got %v want %v", got, want)
+	}
+	if got["bytes"] == 0 {
+		t.Error("Expected a non-zero byte count")
+	}
+
+	point := &metricspb.Point{Value: &metricspb.Point_Int64Value{Int64Value: 1}}
+	metricsReq := &agentmetricspb.ExportMetricsServiceRequest{
+		Metrics: []*metricspb.Metric{
+			{Timeseries: []*metricspb.TimeSeries{{Points: []*metricspb.Point{point, point}}, {Points: []*metricspb.Point{point}}}},
+			{Timeseries: []*metricspb.TimeSeries{{}}},
+		},
+	}
+	got = ocagent.RequestSummary(metricsReq)
+	want = map[string]int{"metrics": 2, "timeseries": 3, "points": 3, "bytes": proto.Size(metricsReq)}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Metrics summary: got %v want %v", got, want)
+	}
+
+	var nilReq *agenttracepb.ExportTraceServiceRequest
+	if got, want := ocagent.RequestSummary(nilReq), map[string]int{"bytes": 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Nil request summary: got %v want %v", got, want)
+	}
+}
+
+func TestStripNode(t *testing.T) {
+	node := &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}}
+	rs := &resourcepb.Resource{Type: "host"}
+	spans := []*tracepb.Span{{Name: &tracepb.TruncatableString{Value: "a"}}}
+
+	req := &agenttracepb.ExportTraceServiceRequest{Node: node, Spans: spans, Resource: rs}
+	stripped := ocagent.StripNode(req)
+	if stripped == req {
+		t.Fatal("Expected a clone of the request")
+	}
+	if stripped.Node != nil {
+		t.Errorf("Expected no Node, got %v", stripped.Node)
+	}
+	if req.Node != node {
+		t.Errorf("Expected the original to keep its Node, got %v", req.Node)
+	}
+	if !reflect.DeepEqual(stripped.Spans, spans) || stripped.Resource != rs {
+		t.Errorf("Expected the Spans and Resource to be preserved, got %v", stripped)
+	}
+
+	metricsReq := &agentmetricspb.ExportMetricsServiceRequest{
+		Node:     node,
+		Metrics:  []*metricspb.Metric{{MetricDescriptor: &metricspb.MetricDescriptor{Name: "m"}}},
+		Resource: rs,
+	}
+	strippedMetrics := ocagent.StripMetricsNode(metricsReq)
+	if strippedMetrics.Node != nil {
+		t.Errorf("Expected no Node, got %v", strippedMetrics.Node)
+	}
+	if metricsReq.Node != node {
+		t.Errorf("Expected the original to keep its Node, got %v", metricsReq.Node)
+	}
+	if !reflect.DeepEqual(strippedMetrics.Metrics, metricsReq.Metrics) || strippedMetrics.Resource != rs {
+		t.Errorf("Expected the Metrics and Resource to be preserved, got %v", strippedMetrics)
+	}
+
+	if ocagent.StripNode(nil) != nil || ocagent.StripMetricsNode(nil) != nil {
+		t.Error("Expected nil for nil requests")
+	}
+}
+
+func TestResourceFingerprint(t *testing.T) {
+	labels := map[string]string{"zone": "us-east1", "host": "h1", "pod": "p1", "container": "c1"}
+	want := ocagent.ResourceFingerprint(&resourcepb.Resource{Type: "k8s", Labels: labels})
+	for i := 0; i < 10; i++ {
+		// Rebuild the map every time, for different insertion and iteration orders.
+		rebuilt := make(map[string]string)
+		for k, v := range labels {
+			rebuilt[k] = v
+		}
+		if got := ocagent.ResourceFingerprint(&resourcepb.Resource{Type: "k8s", Labels: rebuilt}); got != want {
+			t.Fatalf("#%d: got fingerprint %x want %x", i, got, want)
+		}
+	}
+
+	different := []*resourcepb.Resource{
+		{Type: "host", Labels: labels},
+		{Type: "k8s", Labels: map[string]string{"zone": "us-east1"}},
+		{Type: "k8s", Labels: map[string]string{"zone": "us-east1", "host": "h1", "pod": "p1", "container": "c2"}},
+		// Keys and values must not be confused.
+		{Type: "k8s", Labels: map[string]string{"zone=us-east1": "", "host": "h1", "pod": "p1", "container": "c1"}},
+	}
+	for i, rp := range different {
+		if got := ocagent.ResourceFingerprint(rp); got == want {
+			t.Errorf("#%d: unexpected fingerprint collision for %v", i, rp)
+		}
+	}
+
+	if got := ocagent.ResourceFingerprint(nil); got != 0 {
+		t.Errorf("Nil resource: got fingerprint %x want 0", got)
+	}
+}
+
+func TestMergeMetricsRequests(t *testing.T) {
+	node := &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}}
+	rs := &resourcepb.Resource{Type: "host"}
+	fouls := &metricspb.MetricDescriptor{Name: "fouls", Type: metricspb.MetricDescriptor_CUMULATIVE_INT64}
+	series := func(v string) *metricspb.TimeSeries {
+		return &metricspb.TimeSeries{LabelValues: []*metricspb.LabelValue{{Value: v, HasValue: true}}}
+	}
+
+	req1 := &agentmetricspb.ExportMetricsServiceRequest{
+		Node:     node,
+		Resource: rs,
+		Metrics: []*metricspb.Metric{
+			{MetricDescriptor: fouls, Timeseries: []*metricspb.TimeSeries{series("a")}},
+			{MetricDescriptor: &metricspb.MetricDescriptor{Name: "goals"}, Timeseries: []*metricspb.TimeSeries{series("x")}},
+		},
+	}
+	req2 := &agentmetricspb.ExportMetricsServiceRequest{
+		Resource: &resourcepb.Resource{Type: "host"},
+		Metrics: []*metricspb.Metric{
+			// An equal but distinct descriptor.
+			{MetricDescriptor: &metricspb.MetricDescriptor{Name: "fouls", Type: metricspb.MetricDescriptor_CUMULATIVE_INT64}, Timeseries: []*metricspb.TimeSeries{series("b")}},
+		},
+	}
+
+	merged, err := ocagent.MergeMetricsRequests(req1, nil, req2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if merged.Node != node || merged.Resource != rs {
+		t.Errorf("Expected the Node and Resource to be kept, got %v and %v", merged.Node, merged.Resource)
+	}
+	if g, w := len(merged.Metrics), 2; g != w {
+		t.Fatalf("Number of metrics: got %d want %d", g, w)
+	}
+	if merged.Metrics[0].MetricDescriptor != fouls {
+		t.Errorf("Expected the first descriptor to be kept, got %v", merged.Metrics[0].MetricDescriptor)
+	}
+	var values []string
+	for _, ts := range merged.Metrics[0].Timeseries {
+		values = append(values, ts.LabelValues[0].Value)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("Merged timeseries: got %v want %v", values, want)
+	}
+	if len(req1.Metrics[0].Timeseries) != 1 {
+		t.Error("Expected the input requests to be left unmodified")
+	}
+
+	// Different request resources are pushed down onto the metrics.
+	req2.Resource = &resourcepb.Resource{Type: "container"}
+	merged, err = ocagent.MergeMetricsRequests(req1, req2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if merged.Resource != nil {
+		t.Errorf("Expected no request Resource, got %v", merged.Resource)
+	}
+	if g, w := len(merged.Metrics), 3; g != w {
+		t.Fatalf("Number of metrics: got %d want %d", g, w)
+	}
+	if g, w := merged.Metrics[2].Resource.GetType(), "container"; g != w {
+		t.Errorf("Pushed down resource: got %q want %q", g, w)
+	}
+
+	conflicting := &agentmetricspb.ExportMetricsServiceRequest{
+		Metrics: []*metricspb.Metric{{MetricDescriptor: &metricspb.MetricDescriptor{Name: "fouls", Unit: "1"}}},
+	}
+	if _, err := ocagent.MergeMetricsRequests(req1, conflicting); err == nil {
+		t.Error("Expected an error for conflicting descriptors")
+	}
+	otherNode := &agentmetricspb.ExportMetricsServiceRequest{Node: &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "other"}}}
+	if _, err := ocagent.MergeMetricsRequests(req1, otherNode); err == nil {
+		t.Error("Expected an error for different Nodes")
+	}
+}
+
+func TestCloneTraceRequest(t *testing.T) {
+	req := &agenttracepb.ExportTraceServiceRequest{
+		Node:     &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}},
+		Resource: &resourcepb.Resource{Type: "host", Labels: map[string]string{"zone": "a"}},
+		Spans:    []*tracepb.Span{{Name: &tracepb.TruncatableString{Value: "original"}}},
+	}
+
+	clone := ocagent.CloneTraceRequest(req)
+	if !proto.Equal(clone, req) {
+		t.Fatalf("Expected the clone to equal the original\n\tGot  %v\n\tWant %v", clone, req)
+	}
+	clone.Spans[0].Name.Value = "modified"
+	clone.Node.ServiceInfo.Name = "modified"
+	clone.Resource.Labels["zone"] = "modified"
+	if g := req.Spans[0].Name.Value; g != "original" {
+		t.Errorf("Span name of the original changed to %q", g)
+	}
+	if g := req.Node.ServiceInfo.Name; g != "svc" {
+		t.Errorf("Node of the original changed to %q", g)
+	}
+	if g := req.Resource.Labels["zone"]; g != "a" {
+		t.Errorf("Resource of the original changed to %q", g)
+	}
+
+	if ocagent.CloneTraceRequest(nil) != nil {
+		t.Error("Expected nil for a nil request")
+	}
+}
+
+func TestCloneMetricsRequest(t *testing.T) {
+	req := &agentmetricspb.ExportMetricsServiceRequest{
+		Node:    &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}},
+		Metrics: []*metricspb.Metric{{MetricDescriptor: &metricspb.MetricDescriptor{Name: "original"}}},
+	}
+
+	clone := ocagent.CloneMetricsRequest(req)
+	if !proto.Equal(clone, req) {
+		t.Fatalf("Expected the clone to equal the original\n\tGot  %v\n\tWant %v", clone, req)
+	}
+	clone.Metrics[0].MetricDescriptor.Name = "modified"
+	if g := req.Metrics[0].MetricDescriptor.Name; g != "original" {
+		t.Errorf("Metric name of the original changed to %q", g)
+	}
+
+	if ocagent.CloneMetricsRequest(nil) != nil {
+		t.Error("Expected nil for a nil request")
+	}
+}
+
+func TestPartitionMetricsByFamily(t *testing.T) {
+	newMetric := func(name string, typ metricspb.MetricDescriptor_Type) *metricspb.Metric {
+		return &metricspb.Metric{MetricDescriptor: &metricspb.MetricDescriptor{Name: name, Type: typ}}
+	}
+	node := &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}}
+	rs := &resourcepb.Resource{Type: "host"}
+	req := &agentmetricspb.ExportMetricsServiceRequest{
+		Node:     node,
+		Resource: rs,
+		Metrics: []*metricspb.Metric{
+			newMetric("temperature", metricspb.MetricDescriptor_GAUGE_DOUBLE),
+			newMetric("requests", metricspb.MetricDescriptor_CUMULATIVE_INT64),
+			newMetric("latency", metricspb.MetricDescriptor_SUMMARY),
+			newMetric("queue_size", metricspb.MetricDescriptor_GAUGE_INT64),
+			newMetric("latency_histogram", metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION),
+			newMetric("snapshot", metricspb.MetricDescriptor_GAUGE_DISTRIBUTION),
+			newMetric("unknown", metricspb.MetricDescriptor_UNSPECIFIED),
+		},
+	}
+
+	partitions := ocagent.PartitionMetricsByFamily(req)
+	want := map[string][]string{
+		"gauge":      {"temperature", "queue_size", "snapshot"},
+		"cumulative": {"requests", "latency_histogram"},
+		"summary":    {"latency"},
+	}
+	got := make(map[string][]string)
+	for family, partition := range partitions {
+		if partition.Node != node || partition.Resource != rs {
+			t.Errorf("%s: expected the Node and Resource to be kept", family)
+		}
+		for _, metric := range partition.Metrics {
+			got[family] = append(got[family], metric.MetricDescriptor.Name)
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Partitions mismatch\n\tGot  %v\n\tWant %v", got, want)
+	}
+	if g, w := len(req.Metrics), 7; g != w {
+		t.Errorf("Expected the request to be unmodified, got %d metrics", g)
+	}
+}
+
+func TestCollectExemplars(t *testing.T) {
+	e1 := &metricspb.DistributionValue_Exemplar{Value: 1.5, Attachments: map[string]string{"trace_id": "a"}}
+	e2 := &metricspb.DistributionValue_Exemplar{Value: 12}
+	e3 := &metricspb.DistributionValue_Exemplar{Value: 250}
+	distributionPoint := func(buckets ...*metricspb.DistributionValue_Bucket) *metricspb.Point {
+		return &metricspb.Point{Value: &metricspb.Point_DistributionValue{
+			DistributionValue: &metricspb.DistributionValue{Buckets: buckets},
+		}}
+	}
+	req := &agentmetricspb.ExportMetricsServiceRequest{
+		Metrics: []*metricspb.Metric{
+			{
+				Timeseries: []*metricspb.TimeSeries{
+					{Points: []*metricspb.Point{distributionPoint(&metricspb.DistributionValue_Bucket{Count: 1, Exemplar: e1}, &metricspb.DistributionValue_Bucket{})}},
+					{Points: []*metricspb.Point{{Value: &metricspb.Point_Int64Value{Int64Value: 4}}}},
+				},
+			},
+			nil,
+			{
+				Timeseries: []*metricspb.TimeSeries{
+					{Points: []*metricspb.Point{distributionPoint(&metricspb.DistributionValue_Bucket{Exemplar: e2}, &metricspb.DistributionValue_Bucket{Exemplar: e3})}},
+				},
+			},
+		},
+	}
+
+	got := ocagent.CollectExemplars(req)
+	want := []*metricspb.DistributionValue_Exemplar{e1, e2, e3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v want %v", got, want)
+	}
+
+	if got := ocagent.CollectExemplars(nil); len(got) != 0 {
+		t.Errorf("Nil request: got %v", got)
+	}
+}
+
+func TestSplitMetricsRequestByResource(t *testing.T) {
+	node := &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}}
+	reqResource := &resourcepb.Resource{Type: "host", Labels: map[string]string{"name": "h1"}}
+	pod := &resourcepb.Resource{Type: "k8s", Labels: map[string]string{"pod": "p1", "ns": "default"}}
+	// Equal to pod but a distinct pointer with a different label insertion order.
+	podCopy := &resourcepb.Resource{Type: "k8s", Labels: map[string]string{"ns": "default", "pod": "p1"}}
+	metric := func(name string, rs *resourcepb.Resource) *metricspb.Metric {
+		return &metricspb.Metric{
+			MetricDescriptor: &metricspb.MetricDescriptor{Name: name},
+			Resource:         rs,
+		}
+	}
+	req := &agentmetricspb.ExportMetricsServiceRequest{
+		Node:     node,
+		Resource: reqResource,
+		Metrics: []*metricspb.Metric{
+			metric("a", nil),
+			metric("b", pod),
+			nil,
+			metric("c", &resourcepb.Resource{Type: "host", Labels: map[string]string{"name": "h1"}}),
+			metric("d", podCopy),
+			metric("e", &resourcepb.Resource{Type: "container"}),
+		},
+	}
+
+	reqs := ocagent.SplitMetricsRequestByResource(req)
+	wantResources := []*resourcepb.Resource{reqResource, pod, {Type: "container"}}
+	wantNames := [][]string{{"a", "c"}, {"b", "d"}, {"e"}}
+	if g, w := len(reqs), len(wantNames); g != w {
+		t.Fatalf("Number of requests: got %d want %d", g, w)
+	}
+	for i, r := range reqs {
+		if r.Node != node {
+			t.Errorf("#%d: expected the Node to be preserved", i)
+		}
+		if !ocagent.ResourcePbEqual(r.Resource, wantResources[i]) {
+			t.Errorf("#%d: Resource got %v want %v", i, r.Resource, wantResources[i])
+		}
+		var names []string
+		for _, m := range r.Metrics {
+			names = append(names, m.MetricDescriptor.Name)
+			if m.Resource != nil {
+				t.Errorf("#%d: metric %q kept its Resource", i, m.MetricDescriptor.Name)
+			}
+		}
+		if !reflect.DeepEqual(names, wantNames[i]) {
+			t.Errorf("#%d: metrics got %v want %v", i, names, wantNames[i])
+		}
+	}
+
+	if req.Metrics[1].Resource != pod {
+		t.Error("The original request was modified")
+	}
+}
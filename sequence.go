@@ -0,0 +1,72 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/golang/protobuf/proto"
+
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+)
+
+// SequenceNodeAttribute is the Node attribute under which
+// SequenceStamper records the sequence number of a request.
+const SequenceNodeAttribute = "seq"
+
+// SequenceStamper numbers requests by setting the "seq" attribute of their Node
+// to a monotonically increasing sequence number starting at 1, which allows
+// collectors to detect dropped requests from gaps in the sequence.
+// The zero value is ready to use, and a SequenceStamper is safe for
+// concurrent use, in which case the order of the numbers follows the order
+// in which the calls happen.
+type SequenceStamper struct {
+	// last is accessed atomically and must stay the first
+	// field for 64-bit alignment on 32-bit platforms.
+	last uint64
+}
+
+// StampTraceRequest sets the Node of req to a copy of it carrying the next
+// sequence number, leaving the original Node, which is commonly shared
+// between requests, untouched. A nil Node is replaced by one with only
+// the sequence number. It returns the sequence number.
+func (s *SequenceStamper) StampTraceRequest(req *agenttracepb.ExportTraceServiceRequest) uint64 {
+	var seq uint64
+	req.Node, seq = s.stamp(req.Node)
+	return seq
+}
+
+// StampMetricsRequest is like StampTraceRequest, for metrics requests.
+func (s *SequenceStamper) StampMetricsRequest(req *agentmetricspb.ExportMetricsServiceRequest) uint64 {
+	var seq uint64
+	req.Node, seq = s.stamp(req.Node)
+	return seq
+}
+
+func (s *SequenceStamper) stamp(node *commonpb.Node) (*commonpb.Node, uint64) {
+	seq := atomic.AddUint64(&s.last, 1)
+	stamped := new(commonpb.Node)
+	if node != nil {
+		stamped = proto.Clone(node).(*commonpb.Node)
+	}
+	if stamped.Attributes == nil {
+		stamped.Attributes = make(map[string]string)
+	}
+	stamped.Attributes[SequenceNodeAttribute] = strconv.FormatUint(seq, 10)
+	return stamped, seq
+}
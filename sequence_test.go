@@ -0,0 +1,78 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+)
+
+func TestSequenceStamper(t *testing.T) {
+	node := &commonpb.Node{Attributes: map[string]string{"env": "prod"}}
+	var stamper ocagent.SequenceStamper
+
+	for i := 1; i <= 3; i++ {
+		req := &agenttracepb.ExportTraceServiceRequest{Node: node}
+		if g, w := stamper.StampTraceRequest(req), uint64(2*i-1); g != w {
+			t.Errorf("Trace request #%d: got sequence %d want %d", i, g, w)
+		}
+		if g, w := req.Node.Attributes["seq"], strconv.Itoa(2*i-1); g != w {
+			t.Errorf("Trace request #%d: got seq attribute %q want %q", i, g, w)
+		}
+		if g := req.Node.Attributes["env"]; g != "prod" {
+			t.Errorf("Trace request #%d: lost the env attribute, got %q", i, g)
+		}
+
+		mreq := new(agentmetricspb.ExportMetricsServiceRequest)
+		stamper.StampMetricsRequest(mreq)
+		if g, w := mreq.Node.Attributes["seq"], strconv.Itoa(2*i); g != w {
+			t.Errorf("Metrics request #%d: got seq attribute %q want %q", i, g, w)
+		}
+	}
+
+	if _, ok := node.Attributes["seq"]; ok {
+		t.Errorf("The shared Node was modified: %v", node)
+	}
+}
+
+func TestSequenceStamper_concurrent(t *testing.T) {
+	var stamper ocagent.SequenceStamper
+	const n = 50
+	seqs := make([]int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			seqs[i] = int(stamper.StampTraceRequest(new(agenttracepb.ExportTraceServiceRequest)))
+		}(i)
+	}
+	wg.Wait()
+
+	sort.Ints(seqs)
+	for i, seq := range seqs {
+		if seq != i+1 {
+			t.Fatalf("Expected unique sequence numbers 1 through %d, got %v", n, seqs)
+		}
+	}
+}
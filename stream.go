@@ -0,0 +1,96 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"context"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+	"go.opencensus.io/trace"
+)
+
+const defaultMaxSpansPerStreamedRequest = 512
+
+// StreamOptions customizes StreamConvertAndWrite.
+type StreamOptions struct {
+	// MaxSpansPerRequest is the maximum number of spans per written request.
+	// If non-positive, 512 spans are used.
+	MaxSpansPerRequest int
+
+	// SpanConversionOptions customizes the conversion of each batch of spans.
+	SpanConversionOptions *SpanConversionOptions
+}
+
+// StreamConvertAndWrite reads spans from in until it is closed, converting them in
+// batches to ExportTraceServiceRequests that are written to w as varint length-delimited
+// protos. Only one batch is held in memory at a time, which allows converting archives
+// that are too large to fit in a single request. The written requests don't carry a Node.
+//
+// It returns the first error encountered while marshaling or writing, or ctx.Err()
+// if ctx is done before in is closed. A nil opts uses the default options.
+func StreamConvertAndWrite(ctx context.Context, w io.Writer, in <-chan *trace.SpanData, opts *StreamOptions) error {
+	if opts == nil {
+		opts = new(StreamOptions)
+	}
+	maxSpans := opts.MaxSpansPerRequest
+	if maxSpans <= 0 {
+		maxSpans = defaultMaxSpansPerStreamedRequest
+	}
+
+	batch := make([]*trace.SpanData, 0, maxSpans)
+	buf := proto.NewBuffer(nil)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		req := OpenCensusSpanDataToProtoSpansWithOptions(batch, opts.SpanConversionOptions)
+		for i := range batch {
+			batch[i] = nil
+		}
+		batch = batch[:0]
+		if req == nil {
+			return nil
+		}
+
+		buf.Reset()
+		if err := buf.EncodeMessage(req); err != nil {
+			return err
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case sd, ok := <-in:
+			if !ok {
+				return flush()
+			}
+			if sd == nil {
+				continue
+			}
+			batch = append(batch, sd)
+			if len(batch) >= maxSpans {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/orijtech/ocagent_structs_no_grpc"
+	"go.opencensus.io/trace"
+
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+)
+
+func streamSpans(n int) <-chan *trace.SpanData {
+	in := make(chan *trace.SpanData)
+	go func() {
+		defer close(in)
+		startTime := time.Now()
+		for i := 0; i < n; i++ {
+			in <- &trace.SpanData{
+				SpanContext: trace.SpanContext{
+					TraceID: testTraceID,
+					SpanID:  trace.SpanID{0xFF, 0xFE, 0xFD, 0xFC, 0xFB, 0xFA, 0xF9, byte(i)},
+				},
+				Name:       "streamed",
+				StartTime:  startTime,
+				EndTime:    startTime.Add(time.Millisecond),
+				Attributes: map[string]interface{}{"agent": "ocagent"},
+			}
+		}
+	}()
+	return in
+}
+
+func TestStreamConvertAndWrite(t *testing.T) {
+	buf := new(bytes.Buffer)
+	opts := &ocagent.StreamOptions{MaxSpansPerRequest: 4}
+	if err := ocagent.StreamConvertAndWrite(context.Background(), buf, streamSpans(10), opts); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	pbuf := proto.NewBuffer(buf.Bytes())
+	var spansPerRequest []int
+	for len(pbuf.Unread()) > 0 {
+		req := new(agenttracepb.ExportTraceServiceRequest)
+		if err := pbuf.DecodeMessage(req); err != nil {
+			t.Fatalf("Failed to decode request #%d: %v", len(spansPerRequest), err)
+		}
+		spansPerRequest = append(spansPerRequest, len(req.Spans))
+	}
+	want := []int{4, 4, 2}
+	if len(spansPerRequest) != len(want) {
+		t.Fatalf("Spans per request: got %v want %v", spansPerRequest, want)
+	}
+	for i := range want {
+		if spansPerRequest[i] != want[i] {
+			t.Errorf("Spans per request: got %v want %v", spansPerRequest, want)
+			break
+		}
+	}
+}
+
+func TestStreamConvertAndWrite_canceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	in := make(chan *trace.SpanData)
+	if err := ocagent.StreamConvertAndWrite(ctx, ioutil.Discard, in, nil); err != context.Canceled {
+		t.Errorf("Got error %v want %v", err, context.Canceled)
+	}
+}
+
+// peakHeapWriter records the peak of the live heap, as measured after a
+// garbage collection on every Write, while a converted batch is held.
+type peakHeapWriter struct {
+	peak uint64
+}
+
+func (w *peakHeapWriter) Write(p []byte) (int, error) {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if ms.HeapAlloc > w.peak {
+		w.peak = ms.HeapAlloc
+	}
+	return len(p), nil
+}
+
+func TestStreamConvertAndWrite_boundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the memory measurements in short mode")
+	}
+	opts := &ocagent.StreamOptions{MaxSpansPerRequest: 100}
+	sizes := []int{1000, 10000, 50000}
+	peaks := make([]uint64, 0, len(sizes))
+	for _, n := range sizes {
+		w := new(peakHeapWriter)
+		if err := ocagent.StreamConvertAndWrite(context.Background(), w, streamSpans(n), opts); err != nil {
+			t.Fatalf("%d spans: unexpected error: %v", n, err)
+		}
+		peaks = append(peaks, w.peak)
+	}
+	t.Logf("Peak heap per input size %v: %v", sizes, peaks)
+
+	// Holding all the spans of the largest input would take tens of MiB,
+	// while a batch of 100 spans takes well under 1MiB.
+	const maxGrowth = 1 << 20
+	for i, peak := range peaks[1:] {
+		if peak > peaks[0]+maxGrowth {
+			t.Errorf("%d spans: peak heap %d grew by more than %d bytes from %d for %d spans",
+				sizes[i+1], peak, maxGrowth, peaks[0], sizes[0])
+		}
+	}
+}
+
+// The allocations per span, and hence the memory held at any time,
+// are independent of b.N since only one batch is held in memory, as
+// TestStreamConvertAndWrite_boundedMemory checks.
+func BenchmarkStreamConvertAndWrite(b *testing.B) {
+	b.ReportAllocs()
+	in := streamSpans(b.N)
+	b.ResetTimer()
+	if err := ocagent.StreamConvertAndWrite(context.Background(), ioutil.Discard, in, nil); err != nil {
+		b.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+)
+
+// PercentileOutOfRangeError is returned by NewSummaryValue
+// for a percentile that isn't in the interval (0, 100].
+type PercentileOutOfRangeError struct {
+	Percentile float64
+}
+
+func (e *PercentileOutOfRangeError) Error() string {
+	return fmt.Sprintf("percentile %v is not in the interval (0, 100]", e.Percentile)
+}
+
+// NewSummaryValue creates a SummaryValue from count, sum and percentiles, which
+// maps each percentile to its value. A nil count or sum leaves the respective
+// field unset, which is distinguishable from a zero value. The percentiles
+// are stored in the Snapshot sorted in increasing order, and a
+// *PercentileOutOfRangeError is returned for any that isn't in (0, 100].
+func NewSummaryValue(count *int64, sum *float64, percentiles map[float64]float64) (*metricspb.SummaryValue, error) {
+	sv := new(metricspb.SummaryValue)
+	if count != nil {
+		sv.Count = &wrappers.Int64Value{Value: *count}
+	}
+	if sum != nil {
+		sv.Sum = &wrappers.DoubleValue{Value: *sum}
+	}
+	if len(percentiles) == 0 {
+		return sv, nil
+	}
+
+	values := make([]*metricspb.SummaryValue_Snapshot_ValueAtPercentile, 0, len(percentiles))
+	for percentile, value := range percentiles {
+		if !isValidPercentile(percentile) {
+			return nil, &PercentileOutOfRangeError{Percentile: percentile}
+		}
+		values = append(values, &metricspb.SummaryValue_Snapshot_ValueAtPercentile{
+			Percentile: percentile,
+			Value:      value,
+		})
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Percentile < values[j].Percentile
+	})
+	sv.Snapshot = &metricspb.SummaryValue_Snapshot{PercentileValues: values}
+	return sv, nil
+}
+
+// SanitizeSummaryValue removes from the Snapshot of sv the percentile values whose
+// percentile isn't in the interval (0, 100], which the agent would reject, as well
+// as nil ones. It modifies sv in place and returns the number of entries removed.
+func SanitizeSummaryValue(sv *metricspb.SummaryValue) int {
+	snapshot := sv.GetSnapshot()
+	if snapshot == nil {
+		return 0
+	}
+	kept := snapshot.PercentileValues[:0]
+	for _, pv := range snapshot.PercentileValues {
+		if pv != nil && isValidPercentile(pv.Percentile) {
+			kept = append(kept, pv)
+		} else {
+			logDrop(dropReasonInvalidPercentile, "percentile value %v", pv)
+		}
+	}
+	dropped := len(snapshot.PercentileValues) - len(kept)
+	for i := len(kept); i < len(snapshot.PercentileValues); i++ {
+		// Release the dropped entries.
+		snapshot.PercentileValues[i] = nil
+	}
+	snapshot.PercentileValues = kept
+	return dropped
+}
+
+// isValidPercentile reports whether p is in the interval (0, 100].
+// The negated comparison also rejects NaN.
+func isValidPercentile(p float64) bool {
+	return p > 0 && p <= 100
+}
@@ -0,0 +1,127 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+)
+
+func TestNewSummaryValue_countAndSum(t *testing.T) {
+	sv, err := ocagent.NewSummaryValue(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sv.Count != nil || sv.Sum != nil || sv.Snapshot != nil {
+		t.Errorf("Expected unset fields, got %v", sv)
+	}
+
+	count, sum := int64(0), 0.0
+	sv, err = ocagent.NewSummaryValue(&count, &sum, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sv.Count == nil || sv.Count.Value != 0 {
+		t.Errorf("Expected a set zero count, got %v", sv.Count)
+	}
+	if sv.Sum == nil || sv.Sum.Value != 0 {
+		t.Errorf("Expected a set zero sum, got %v", sv.Sum)
+	}
+
+	count, sum = 10, 42.5
+	sv, _ = ocagent.NewSummaryValue(&count, &sum, nil)
+	if sv.Count.Value != 10 || sv.Sum.Value != 42.5 {
+		t.Errorf("Got count %v and sum %v", sv.Count, sv.Sum)
+	}
+}
+
+func TestNewSummaryValue_percentiles(t *testing.T) {
+	sv, err := ocagent.NewSummaryValue(nil, nil, map[float64]float64{99: 120, 50: 40, 100: 300, 90: 80, 0.5: 1})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := [][2]float64{{0.5, 1}, {50, 40}, {90, 80}, {99, 120}, {100, 300}}
+	if g, w := len(sv.Snapshot.PercentileValues), len(want); g != w {
+		t.Fatalf("Number of percentiles: got %d want %d", g, w)
+	}
+	for i, pv := range sv.Snapshot.PercentileValues {
+		if g := [2]float64{pv.Percentile, pv.Value}; g != want[i] {
+			t.Errorf("#%d: got %v want %v", i, g, want[i])
+		}
+	}
+	if err := ocagent.ValidateSummaryValue(sv); err != nil {
+		t.Errorf("Expected a valid SummaryValue, got %v", err)
+	}
+
+	for _, percentile := range []float64{0, -5, 100.5, math.NaN()} {
+		sv, err := ocagent.NewSummaryValue(nil, nil, map[float64]float64{50: 1, percentile: 2})
+		if sv != nil {
+			t.Errorf("%v: expected no SummaryValue, got %v", percentile, sv)
+		}
+		rangeErr, ok := err.(*ocagent.PercentileOutOfRangeError)
+		if !ok {
+			t.Errorf("%v: got error %v (%T), want a *PercentileOutOfRangeError", percentile, err, err)
+			continue
+		}
+		if g := rangeErr.Percentile; g != percentile && !(math.IsNaN(g) && math.IsNaN(percentile)) {
+			t.Errorf("%v: error reports percentile %v", percentile, g)
+		}
+	}
+}
+
+func TestSanitizeSummaryValue(t *testing.T) {
+	sv := &metricspb.SummaryValue{
+		Snapshot: &metricspb.SummaryValue_Snapshot{
+			PercentileValues: []*metricspb.SummaryValue_Snapshot_ValueAtPercentile{
+				{Percentile: 0, Value: 1},
+				{Percentile: 50, Value: 10},
+				{Percentile: -1, Value: 2},
+				nil,
+				{Percentile: 90, Value: 20},
+				{Percentile: 100.1, Value: 3},
+				{Percentile: math.NaN(), Value: 4},
+				{Percentile: 100, Value: 30},
+			},
+		},
+	}
+
+	if g, w := ocagent.SanitizeSummaryValue(sv), 5; g != w {
+		t.Errorf("Dropped: got %d want %d", g, w)
+	}
+	want := [][2]float64{{50, 10}, {90, 20}, {100, 30}}
+	if g, w := len(sv.Snapshot.PercentileValues), len(want); g != w {
+		t.Fatalf("Remaining percentiles: got %d want %d", g, w)
+	}
+	for i, pv := range sv.Snapshot.PercentileValues {
+		if g := [2]float64{pv.Percentile, pv.Value}; g != want[i] {
+			t.Errorf("#%d: got %v want %v", i, g, want[i])
+		}
+	}
+	if err := ocagent.ValidateSummaryValue(sv); err != nil {
+		t.Errorf("Expected a valid SummaryValue, got %v", err)
+	}
+
+	// Sanitizing again drops nothing.
+	if g := ocagent.SanitizeSummaryValue(sv); g != 0 {
+		t.Errorf("Second pass dropped %d", g)
+	}
+	if g := ocagent.SanitizeSummaryValue(nil); g != 0 {
+		t.Errorf("Nil SummaryValue: dropped %d", g)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+)
+
+var (
+	// minValidSeconds and maxValidSeconds are the bounds of the seconds
+	// of a valid Timestamp, 0001-01-01T00:00:00Z and 9999-12-31T23:59:59Z.
+	minValidSeconds = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	maxValidSeconds = time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC).Unix() - 1
+)
+
+// TimeToProto converts t to a proto Timestamp. Unlike ptypes.TimestampProto it never
+// fails: the zero time.Time, for example the EndTime of a span that is still in progress,
+// and times outside the range of valid Timestamps are converted to nil.
+func TimeToProto(t time.Time) *timestamp.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	seconds := t.Unix()
+	if seconds < minValidSeconds || seconds > maxValidSeconds {
+		return nil
+	}
+	return &timestamp.Timestamp{
+		Seconds: seconds,
+		Nanos:   int32(t.Nanosecond()),
+	}
+}
+
+// ProtoToTime converts ts to a time.Time in UTC. Unlike ptypes.Timestamp it never
+// fails: a nil or invalid ts is converted to the zero time.Time.
+func ProtoToTime(ts *timestamp.Timestamp) time.Time {
+	if ts == nil || ts.Seconds < minValidSeconds || ts.Seconds > maxValidSeconds || ts.Nanos < 0 || ts.Nanos >= 1e9 {
+		return time.Time{}
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC()
+}
+
+// ShiftTimestamps adds delta to every timestamp in req, which must be either an
+// ExportTraceServiceRequest or an ExportMetricsServiceRequest; other messages are
+// left untouched. This is useful for replaying archived data against an agent
+// that rejects old timestamps. req is modified in place, but timestamps shared
+// between messages, as produced by the converters, are only shifted once.
+// Invalid timestamps, which ProtoToTime rejects, are left untouched.
+func ShiftTimestamps(req proto.Message, delta time.Duration) {
+	visitTimestamps(req, func(ts *timestamp.Timestamp) *timestamp.Timestamp {
+		t := ProtoToTime(ts)
+		if t.IsZero() {
+			return ts
+		}
+		return TimeToProto(t.Add(delta))
+	})
+}
+
+// FillMissingPointTimestamps sets the Timestamp of every point in req that has
+// none to t, for backends that require point timestamps. Points that have
+// a Timestamp are left untouched. The filled points share one Timestamp.
+func FillMissingPointTimestamps(req *agentmetricspb.ExportMetricsServiceRequest, t time.Time) {
+	ts := TimeToProto(t)
+	for _, metric := range req.GetMetrics() {
+		for _, series := range metric.GetTimeseries() {
+			for _, point := range series.GetPoints() {
+				if point != nil && point.Timestamp == nil {
+					point.Timestamp = ts
+				}
+			}
+		}
+	}
+}
+
+// TimestampsToEpochMillis returns every timestamp in req, which must be either an
+// ExportTraceServiceRequest or an ExportMetricsServiceRequest, as milliseconds
+// since the Unix epoch, for correlating requests with logs. The timestamps are
+// in traversal order: Node timestamps come first, then those of each span or
+// metric in order. Timestamps shared between messages appear once per message.
+func TimestampsToEpochMillis(req proto.Message) []int64 {
+	var millis []int64
+	visitTimestamps(req, func(ts *timestamp.Timestamp) *timestamp.Timestamp {
+		millis = append(millis, ts.Seconds*1e3+int64(ts.Nanos)/1e6)
+		return ts
+	})
+	return millis
+}
+
+// visitTimestamps calls fn with every non-nil timestamp in req, in traversal order,
+// replacing each timestamp by the result of fn. Node timestamps come first, then
+// those of each span or metric in order.
+func visitTimestamps(req proto.Message, fn func(*timestamp.Timestamp) *timestamp.Timestamp) {
+	visit := func(ts **timestamp.Timestamp) {
+		if *ts != nil {
+			*ts = fn(*ts)
+		}
+	}
+	visitNode := func(node *commonpb.Node) {
+		if node != nil && node.Identifier != nil {
+			visit(&node.Identifier.StartTimestamp)
+		}
+	}
+
+	switch req := req.(type) {
+	case *agenttracepb.ExportTraceServiceRequest:
+		if req == nil {
+			return
+		}
+		visitNode(req.Node)
+		for _, span := range req.Spans {
+			if span == nil {
+				continue
+			}
+			visit(&span.StartTime)
+			visit(&span.EndTime)
+			for _, te := range span.GetTimeEvents().GetTimeEvent() {
+				if te != nil {
+					visit(&te.Time)
+				}
+			}
+		}
+
+	case *agentmetricspb.ExportMetricsServiceRequest:
+		if req == nil {
+			return
+		}
+		visitNode(req.Node)
+		for _, metric := range req.Metrics {
+			if metric == nil {
+				continue
+			}
+			for _, ts := range metric.Timeseries {
+				if ts == nil {
+					continue
+				}
+				visit(&ts.StartTimestamp)
+				for _, pt := range ts.Points {
+					if pt == nil {
+						continue
+					}
+					visit(&pt.Timestamp)
+					for _, bucket := range pt.GetDistributionValue().GetBuckets() {
+						if bucket != nil && bucket.Exemplar != nil {
+							visit(&bucket.Exemplar.Timestamp)
+						}
+					}
+				}
+			}
+		}
+	}
+}
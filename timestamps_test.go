@@ -0,0 +1,220 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/orijtech/ocagent_structs_no_grpc"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+func TestShiftTimestamps_trace(t *testing.T) {
+	startTime := time.Date(2019, 3, 14, 15, 9, 26, 535, time.UTC)
+	endTime := startTime.Add(time.Second)
+	req := &agenttracepb.ExportTraceServiceRequest{
+		Node: ocagent.NodeWithStartTime("shift", startTime),
+		Spans: []*tracepb.Span{
+			{
+				StartTime: timeToTimestamp(startTime),
+				EndTime:   timeToTimestamp(endTime),
+				TimeEvents: &tracepb.Span_TimeEvents{
+					TimeEvent: []*tracepb.Span_TimeEvent{{Time: timeToTimestamp(endTime)}},
+				},
+			},
+		},
+	}
+
+	ocagent.ShiftTimestamps(req, time.Hour)
+	span := req.Spans[0]
+	checks := []struct {
+		name string
+		got  interface{}
+		want time.Time
+	}{
+		{"Node.StartTimestamp", req.Node.Identifier.StartTimestamp, startTime.Add(time.Hour)},
+		{"StartTime", span.StartTime, startTime.Add(time.Hour)},
+		{"EndTime", span.EndTime, endTime.Add(time.Hour)},
+		{"TimeEvent.Time", span.TimeEvents.TimeEvent[0].Time, endTime.Add(time.Hour)},
+	}
+	for _, check := range checks {
+		if g, w := check.got, timeToTimestamp(check.want); !reflect.DeepEqual(g, w) {
+			t.Errorf("%s: got %v want %v", check.name, g, w)
+		}
+	}
+}
+
+func TestShiftTimestamps_metrics(t *testing.T) {
+	startTime := time.Date(2019, 3, 14, 15, 9, 26, 535, time.UTC)
+	endTime := startTime.Add(time.Second)
+	vd := &view.Data{
+		Start: startTime,
+		End:   endTime,
+		View: &view.View{
+			Name:        "ocagent.io/count",
+			Aggregation: view.Count(),
+			Measure:     stats.Int64("fouls", "The number of fouls reported", "1"),
+		},
+		Rows: []*view.Row{
+			{Data: &view.CountData{Value: 1}},
+			{Data: &view.CountData{Value: 2}},
+		},
+	}
+	req := ocagent.OpenCensusViewDataToProtoMetrics([]*view.Data{vd})
+
+	ocagent.ShiftTimestamps(req, time.Hour)
+	// The converter shares timestamps between timeseries,
+	// which must nonetheless only be shifted once.
+	for i, ts := range req.Metrics[0].Timeseries {
+		if g, w := ts.StartTimestamp, timeToTimestamp(startTime.Add(time.Hour)); !reflect.DeepEqual(g, w) {
+			t.Errorf("#%d: StartTimestamp: got %v want %v", i, g, w)
+		}
+		if g, w := ts.Points[0].Timestamp, timeToTimestamp(endTime.Add(time.Hour)); !reflect.DeepEqual(g, w) {
+			t.Errorf("#%d: Point.Timestamp: got %v want %v", i, g, w)
+		}
+	}
+}
+
+func TestShiftTimestamps_invalid(t *testing.T) {
+	outOfRange := &timestamp.Timestamp{Seconds: -1e12}
+	badNanos := &timestamp.Timestamp{Seconds: 1552576166, Nanos: 2e9}
+	req := &agenttracepb.ExportTraceServiceRequest{
+		Spans: []*tracepb.Span{{StartTime: outOfRange, EndTime: badNanos}},
+	}
+
+	ocagent.ShiftTimestamps(req, time.Hour)
+	span := req.Spans[0]
+	if g, w := span.StartTime, (&timestamp.Timestamp{Seconds: -1e12}); !reflect.DeepEqual(g, w) {
+		t.Errorf("Out of range StartTime: got %v want %v", g, w)
+	}
+	if g, w := span.EndTime, (&timestamp.Timestamp{Seconds: 1552576166, Nanos: 2e9}); !reflect.DeepEqual(g, w) {
+		t.Errorf("EndTime with invalid nanos: got %v want %v", g, w)
+	}
+}
+
+func TestTimeToProto(t *testing.T) {
+	if g := ocagent.TimeToProto(time.Time{}); g != nil {
+		t.Errorf("Zero time: got %v want nil", g)
+	}
+
+	now := time.Date(2019, 3, 14, 15, 9, 26, 535897932, time.UTC)
+	want := &timestamp.Timestamp{Seconds: now.Unix(), Nanos: 535897932}
+	if g := ocagent.TimeToProto(now); !reflect.DeepEqual(g, want) {
+		t.Errorf("Normal time: got %v want %v", g, want)
+	}
+
+	// Times before the epoch must have non-negative nanos.
+	before := time.Date(1969, 12, 31, 23, 59, 59, 500, time.UTC)
+	want = &timestamp.Timestamp{Seconds: -1, Nanos: 500}
+	if g := ocagent.TimeToProto(before); !reflect.DeepEqual(g, want) {
+		t.Errorf("Pre-epoch time: got %v want %v", g, want)
+	}
+
+	if g := ocagent.TimeToProto(time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)); g != nil {
+		t.Errorf("Out of range time: got %v want nil", g)
+	}
+}
+
+func TestProtoToTime(t *testing.T) {
+	if g := ocagent.ProtoToTime(nil); !g.IsZero() {
+		t.Errorf("Nil proto: got %v want the zero time", g)
+	}
+
+	now := time.Date(2019, 3, 14, 15, 9, 26, 535897932, time.UTC)
+	if g := ocagent.ProtoToTime(ocagent.TimeToProto(now)); !g.Equal(now) {
+		t.Errorf("Normal time: got %v want %v", g, now)
+	}
+
+	if g := ocagent.ProtoToTime(&timestamp.Timestamp{Nanos: -1}); !g.IsZero() {
+		t.Errorf("Invalid proto: got %v want the zero time", g)
+	}
+}
+
+func TestTimestampsToEpochMillis(t *testing.T) {
+	traceReq := &agenttracepb.ExportTraceServiceRequest{
+		Node: &commonpb.Node{Identifier: &commonpb.ProcessIdentifier{
+			StartTimestamp: &timestamp.Timestamp{Seconds: 1000, Nanos: 5e6},
+		}},
+		Spans: []*tracepb.Span{
+			{
+				StartTime: &timestamp.Timestamp{Seconds: 2000, Nanos: 999999},
+				EndTime:   &timestamp.Timestamp{Seconds: 2001, Nanos: 250e6},
+				TimeEvents: &tracepb.Span_TimeEvents{TimeEvent: []*tracepb.Span_TimeEvent{
+					{Time: &timestamp.Timestamp{Seconds: 2000, Nanos: 500e6}},
+				}},
+			},
+			{StartTime: &timestamp.Timestamp{Seconds: 3000}},
+		},
+	}
+	if g, w := ocagent.TimestampsToEpochMillis(traceReq), []int64{1000005, 2000000, 2001250, 2000500, 3000000}; !reflect.DeepEqual(g, w) {
+		t.Errorf("Trace request: got %v want %v", g, w)
+	}
+
+	metricsReq := &agentmetricspb.ExportMetricsServiceRequest{
+		Metrics: []*metricspb.Metric{{
+			Timeseries: []*metricspb.TimeSeries{{
+				StartTimestamp: &timestamp.Timestamp{Seconds: 10},
+				Points: []*metricspb.Point{
+					{Timestamp: &timestamp.Timestamp{Seconds: 11, Nanos: 1e6}},
+					{Timestamp: &timestamp.Timestamp{Seconds: 12}},
+				},
+			}},
+		}},
+	}
+	if g, w := ocagent.TimestampsToEpochMillis(metricsReq), []int64{10000, 11001, 12000}; !reflect.DeepEqual(g, w) {
+		t.Errorf("Metrics request: got %v want %v", g, w)
+	}
+
+	if g := ocagent.TimestampsToEpochMillis(&tracepb.Span{}); len(g) != 0 {
+		t.Errorf("Other message: got %v", g)
+	}
+}
+
+func TestFillMissingPointTimestamps(t *testing.T) {
+	existing := &timestamp.Timestamp{Seconds: 100}
+	req := &agentmetricspb.ExportMetricsServiceRequest{
+		Metrics: []*metricspb.Metric{{
+			Timeseries: []*metricspb.TimeSeries{
+				{Points: []*metricspb.Point{{Timestamp: existing}, {}, nil}},
+				{Points: []*metricspb.Point{{}}},
+			},
+		}},
+	}
+	now := time.Date(2019, 3, 14, 15, 9, 26, 535, time.UTC)
+
+	ocagent.FillMissingPointTimestamps(req, now)
+	series := req.Metrics[0].Timeseries
+	if series[0].Points[0].Timestamp != existing {
+		t.Errorf("Existing timestamp was replaced by %v", series[0].Points[0].Timestamp)
+	}
+	for _, pt := range []*metricspb.Point{series[0].Points[1], series[1].Points[0]} {
+		if g := ocagent.ProtoToTime(pt.Timestamp); !g.Equal(now) {
+			t.Errorf("Filled timestamp: got %v want %v", g, now)
+		}
+	}
+
+	// A nil request is a no-op.
+	ocagent.FillMissingPointTimestamps(nil, now)
+}
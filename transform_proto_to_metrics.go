@@ -0,0 +1,54 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"go.opencensus.io/stats/view"
+
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+)
+
+// ProtoDistributionToOpenCensusDistributionData converts an OpenCensus-Proto
+// DistributionValue back to an OpenCensus DistributionData. It is the reverse
+// of the conversion of distribution rows by OpenCensusViewDataToProtoMetrics,
+// except that Min and Max, which the proto doesn't carry, are left zero.
+func ProtoDistributionToOpenCensusDistributionData(dv *metricspb.DistributionValue) *view.DistributionData {
+	if dv == nil {
+		return nil
+	}
+	dd := &view.DistributionData{
+		Count:           dv.Count,
+		SumOfSquaredDev: dv.SumOfSquaredDeviation,
+	}
+	if dv.Count > 0 {
+		dd.Mean = dv.Sum / float64(dv.Count)
+	}
+	if len(dv.Buckets) > 0 {
+		dd.CountPerBucket = make([]int64, len(dv.Buckets))
+		for i, bucket := range dv.Buckets {
+			dd.CountPerBucket[i] = bucket.GetCount()
+		}
+	}
+	return dd
+}
+
+// Variance returns the sample variance of dv, that is its SumOfSquaredDeviation
+// divided by Count-1. It returns 0 if dv has fewer than two values.
+func Variance(dv *metricspb.DistributionValue) float64 {
+	if dv.GetCount() <= 1 {
+		return 0
+	}
+	return dv.SumOfSquaredDeviation / float64(dv.Count-1)
+}
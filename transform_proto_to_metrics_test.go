@@ -0,0 +1,70 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+func TestProtoDistributionToOpenCensusDistributionData(t *testing.T) {
+	mLatency := stats.Float64("latency", "The latency", "ms")
+	vd := &view.Data{
+		View: &view.View{Name: "latency", Aggregation: view.Distribution(0, 10, 20), Measure: mLatency},
+		Rows: []*view.Row{{
+			Data: &view.DistributionData{
+				// Points: [2, 4, 4, 4, 5, 5, 7, 9]
+				Count:           8,
+				Min:             2,
+				Max:             9,
+				Mean:            5,
+				SumOfSquaredDev: 32,
+				CountPerBucket:  []int64{0, 8, 0, 0},
+			},
+		}},
+	}
+
+	dv := ocagent.OpenCensusViewDataToProtoMetrics([]*view.Data{vd}).Metrics[0].Timeseries[0].Points[0].GetDistributionValue()
+	got := ocagent.ProtoDistributionToOpenCensusDistributionData(dv)
+	want := &view.DistributionData{
+		Count:           8,
+		Mean:            5,
+		SumOfSquaredDev: 32,
+		CountPerBucket:  []int64{0, 8, 0, 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DistributionData mismatch\n\tGot  %+v\n\tWant %+v", got, want)
+	}
+
+	// The sample variance of the points is 32/7.
+	if g, w := ocagent.Variance(dv), 32.0/7; g != w {
+		t.Errorf("Variance: got %v want %v", g, w)
+	}
+
+	dv.Count = 1
+	if g := ocagent.Variance(dv); g != 0 {
+		t.Errorf("Variance of a single value: got %v want 0", g)
+	}
+	if g := ocagent.Variance(nil); g != 0 {
+		t.Errorf("Variance of nil: got %v want 0", g)
+	}
+	if g := ocagent.ProtoDistributionToOpenCensusDistributionData(nil); g != nil {
+		t.Errorf("Expected nil for a nil DistributionValue, got %v", g)
+	}
+}
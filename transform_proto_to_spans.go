@@ -0,0 +1,352 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.opencensus.io/trace"
+	"go.opencensus.io/trace/tracestate"
+
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+var errNilSpanData = errors.New("expecting a non-nil trace.SpanData")
+
+// ProtoSpansToOpenCensusSpanData converts the OpenCensus-Proto Spans in req back to OpenCensus Spans.
+// It is the reverse of OpenCensusSpanDataToProtoSpans and stops at the first span that can't be converted.
+func ProtoSpansToOpenCensusSpanData(req *agenttracepb.ExportTraceServiceRequest) ([]*trace.SpanData, error) {
+	if req == nil || len(req.Spans) == 0 {
+		return nil, nil
+	}
+	sdl := make([]*trace.SpanData, 0, len(req.Spans))
+	for i, span := range req.Spans {
+		if span == nil {
+			continue
+		}
+		sd, err := protoSpanToOCSpan(span)
+		if err != nil {
+			return nil, fmt.Errorf("span #%d: %v", i, err)
+		}
+		sdl = append(sdl, sd)
+	}
+	return sdl, nil
+}
+
+func protoSpanToOCSpan(span *tracepb.Span) (*trace.SpanData, error) {
+	sd := &trace.SpanData{
+		Name:       span.GetName().GetValue(),
+		SpanKind:   protoSpanKindToOCSpanKind(span.Kind),
+		StartTime:  ProtoToTime(span.StartTime),
+		EndTime:    ProtoToTime(span.EndTime),
+		Attributes: protoAttributesToOCAttributes(span.Attributes),
+	}
+	if err := copyID(sd.TraceID[:], span.TraceId, "TraceId"); err != nil {
+		return nil, err
+	}
+	if err := copyID(sd.SpanID[:], span.SpanId, "SpanId"); err != nil {
+		return nil, err
+	}
+	if len(span.ParentSpanId) > 0 {
+		if err := copyID(sd.ParentSpanID[:], span.ParentSpanId, "ParentSpanId"); err != nil {
+			return nil, err
+		}
+	}
+	if status := span.Status; status != nil {
+		sd.Status = trace.Status{Code: status.Code, Message: status.Message}
+	}
+	if ts := span.Tracestate; ts != nil && len(ts.Entries) > 0 {
+		entries := make([]tracestate.Entry, 0, len(ts.Entries))
+		for _, entry := range ts.Entries {
+			entries = append(entries, tracestate.Entry{Key: entry.GetKey(), Value: entry.GetValue()})
+		}
+		ocTracestate, err := tracestate.New(nil, entries...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Tracestate: %v", err)
+		}
+		sd.Tracestate = ocTracestate
+	}
+
+	for _, link := range span.GetLinks().GetLink() {
+		if link != nil {
+			sd.Links = append(sd.Links, protoLinkToOCLink(link))
+		}
+	}
+
+	for _, te := range span.GetTimeEvents().GetTimeEvent() {
+		if te == nil {
+			continue
+		}
+		switch {
+		case te.GetAnnotation() != nil:
+			a := te.GetAnnotation()
+			sd.Annotations = append(sd.Annotations, trace.Annotation{
+				Time:       ProtoToTime(te.Time),
+				Message:    a.GetDescription().GetValue(),
+				Attributes: protoAttributesToOCAttributes(a.Attributes),
+			})
+
+		case te.GetMessageEvent() != nil:
+			e := te.GetMessageEvent()
+			sd.MessageEvents = append(sd.MessageEvents, trace.MessageEvent{
+				Time:                 ProtoToTime(te.Time),
+				EventType:            protoMessageEventTypeToOCType(e.Type),
+				MessageID:            int64(e.Id),
+				UncompressedByteSize: int64(e.UncompressedSize),
+				CompressedByteSize:   int64(e.CompressedSize),
+			})
+		}
+	}
+
+	return sd, nil
+}
+
+// protoLinkToOCLink converts l to an OpenCensus Link.
+// IDs of the wrong length are left zero.
+func protoLinkToOCLink(l *tracepb.Span_Link) trace.Link {
+	link := trace.Link{
+		Type:       protoLinkTypeToOCLinkType(l.Type),
+		Attributes: protoAttributesToOCAttributes(l.Attributes),
+	}
+	if len(l.TraceId) == len(link.TraceID) {
+		copy(link.TraceID[:], l.TraceId)
+	}
+	if len(l.SpanId) == len(link.SpanID) {
+		copy(link.SpanID[:], l.SpanId)
+	}
+	return link
+}
+
+func protoLinkTypeToOCLinkType(t tracepb.Span_Link_Type) trace.LinkType {
+	switch t {
+	case tracepb.Span_Link_CHILD_LINKED_SPAN:
+		return trace.LinkTypeChild
+	case tracepb.Span_Link_PARENT_LINKED_SPAN:
+		return trace.LinkTypeParent
+	default:
+		return trace.LinkTypeUnspecified
+	}
+}
+
+func copyID(dst, src []byte, field string) error {
+	if len(src) != len(dst) {
+		return fmt.Errorf("%s has length %d, expected %d", field, len(src), len(dst))
+	}
+	copy(dst, src)
+	return nil
+}
+
+func protoSpanKindToOCSpanKind(kind tracepb.Span_SpanKind) int {
+	switch kind {
+	case tracepb.Span_CLIENT:
+		return trace.SpanKindClient
+	case tracepb.Span_SERVER:
+		return trace.SpanKindServer
+	default:
+		return trace.SpanKindUnspecified
+	}
+}
+
+func protoMessageEventTypeToOCType(t tracepb.Span_TimeEvent_MessageEvent_Type) trace.MessageEventType {
+	switch t {
+	case tracepb.Span_TimeEvent_MessageEvent_SENT:
+		return trace.MessageEventTypeSent
+	case tracepb.Span_TimeEvent_MessageEvent_RECEIVED:
+		return trace.MessageEventTypeRecv
+	default:
+		return trace.MessageEventTypeUnspecified
+	}
+}
+
+func protoAttributesToOCAttributes(attrs *tracepb.Span_Attributes) map[string]interface{} {
+	if attrs == nil || len(attrs.AttributeMap) == 0 {
+		return nil
+	}
+	out := make(map[string]interface{}, len(attrs.AttributeMap))
+	for k, av := range attrs.AttributeMap {
+		if v := protoAttributeValueToOC(av); v != nil {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func protoAttributeValueToOC(av *tracepb.AttributeValue) interface{} {
+	switch v := av.GetValue().(type) {
+	case *tracepb.AttributeValue_StringValue:
+		return v.StringValue.GetValue()
+	case *tracepb.AttributeValue_IntValue:
+		return v.IntValue
+	case *tracepb.AttributeValue_BoolValue:
+		return v.BoolValue
+	case *tracepb.AttributeValue_DoubleValue:
+		return v.DoubleValue
+	default:
+		return nil
+	}
+}
+
+// RoundTripSpanData converts sd to an OpenCensus-Proto Span and back, returning
+// the result or an error if any field that the conversion preserves differs.
+// It is meant for fuzz and property tests of the converters.
+//
+// The following are lossy and hence not compared:
+//   - attributes of types unsupported by AttributeValueFromInterface and nil
+//     attributes; integers of all sizes are returned as int64
+//   - annotations and message events beyond the per-span limits
+//   - HasRemoteParent and SpanContext.TraceOptions
+//   - the order of annotations and message events, which are sorted by time
+//   - the location and monotonic clock reading of times, and times outside
+//     the range of the proto Timestamp, which are clamped to the zero time.
+func RoundTripSpanData(sd *trace.SpanData) (*trace.SpanData, error) {
+	if sd == nil {
+		return nil, errNilSpanData
+	}
+	got, err := protoSpanToOCSpan(ocSpanToProtoSpan(sd, new(SpanConversionOptions)))
+	if err != nil {
+		return nil, err
+	}
+	if err := compareRoundTrippedSpanData(sd, got); err != nil {
+		return got, err
+	}
+	return got, nil
+}
+
+func compareRoundTrippedSpanData(want, got *trace.SpanData) error {
+	mismatch := func(field string, g, w interface{}) error {
+		return fmt.Errorf("round trip: %s mismatch: got %v want %v", field, g, w)
+	}
+
+	switch {
+	case got.TraceID != want.TraceID:
+		return mismatch("TraceID", got.TraceID, want.TraceID)
+	case got.SpanID != want.SpanID:
+		return mismatch("SpanID", got.SpanID, want.SpanID)
+	case got.ParentSpanID != want.ParentSpanID:
+		return mismatch("ParentSpanID", got.ParentSpanID, want.ParentSpanID)
+	case got.Name != want.Name:
+		return mismatch("Name", got.Name, want.Name)
+	case got.SpanKind != protoSpanKindToOCSpanKind(ocSpanKindToProtoSpanKind(want.SpanKind)):
+		return mismatch("SpanKind", got.SpanKind, want.SpanKind)
+	case !got.StartTime.Equal(roundTrippedTime(want.StartTime)):
+		return mismatch("StartTime", got.StartTime, want.StartTime)
+	case !got.EndTime.Equal(roundTrippedTime(want.EndTime)):
+		return mismatch("EndTime", got.EndTime, want.EndTime)
+	case got.Status != want.Status:
+		return mismatch("Status", got.Status, want.Status)
+	}
+
+	if err := compareRoundTrippedAttributes("Attributes", got.Attributes, want.Attributes); err != nil {
+		return err
+	}
+
+	var gotEntries, wantEntries []tracestate.Entry
+	if got.Tracestate != nil {
+		gotEntries = got.Tracestate.Entries()
+	}
+	if want.Tracestate != nil {
+		wantEntries = want.Tracestate.Entries()
+	}
+	if len(gotEntries) != len(wantEntries) || (len(wantEntries) > 0 && !reflect.DeepEqual(gotEntries, wantEntries)) {
+		return mismatch("Tracestate", gotEntries, wantEntries)
+	}
+
+	if len(want.Annotations) <= maxAnnotationEventsPerSpan {
+		if len(got.Annotations) != len(want.Annotations) {
+			return mismatch("len(Annotations)", len(got.Annotations), len(want.Annotations))
+		}
+		// Time events are converted in chronological order.
+		wantAnnotations := append([]trace.Annotation(nil), want.Annotations...)
+		sort.SliceStable(wantAnnotations, func(i, j int) bool {
+			return timestampLess(TimeToProto(wantAnnotations[i].Time), TimeToProto(wantAnnotations[j].Time))
+		})
+		for i, wa := range wantAnnotations {
+			ga := got.Annotations[i]
+			field := fmt.Sprintf("Annotations[%d]", i)
+			if !ga.Time.Equal(roundTrippedTime(wa.Time)) || ga.Message != wa.Message {
+				return mismatch(field, ga, wa)
+			}
+			if err := compareRoundTrippedAttributes(field+".Attributes", ga.Attributes, wa.Attributes); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(got.Links) != len(want.Links) {
+		return mismatch("len(Links)", len(got.Links), len(want.Links))
+	}
+	for i, wl := range want.Links {
+		gl := got.Links[i]
+		field := fmt.Sprintf("Links[%d]", i)
+		if gl.TraceID != wl.TraceID || gl.SpanID != wl.SpanID || gl.Type != protoLinkTypeToOCLinkType(ocLinkTypeToProtoLinkType(wl.Type)) {
+			return mismatch(field, gl, wl)
+		}
+		if err := compareRoundTrippedAttributes(field+".Attributes", gl.Attributes, wl.Attributes); err != nil {
+			return err
+		}
+	}
+
+	if len(want.MessageEvents) <= maxMessageEventsPerSpan {
+		if len(got.MessageEvents) != len(want.MessageEvents) {
+			return mismatch("len(MessageEvents)", len(got.MessageEvents), len(want.MessageEvents))
+		}
+		wantMessageEvents := append([]trace.MessageEvent(nil), want.MessageEvents...)
+		sort.SliceStable(wantMessageEvents, func(i, j int) bool {
+			return timestampLess(TimeToProto(wantMessageEvents[i].Time), TimeToProto(wantMessageEvents[j].Time))
+		})
+		for i, we := range wantMessageEvents {
+			ge := got.MessageEvents[i]
+			we.EventType = protoMessageEventTypeToOCType(ocMessageEventTypeToProtoType(we.EventType))
+			if !ge.Time.Equal(roundTrippedTime(we.Time)) || ge.EventType != we.EventType || ge.MessageID != we.MessageID ||
+				ge.UncompressedByteSize != we.UncompressedByteSize || ge.CompressedByteSize != we.CompressedByteSize {
+				return mismatch(fmt.Sprintf("MessageEvents[%d]", i), ge, we)
+			}
+		}
+	}
+
+	return nil
+}
+
+func compareRoundTrippedAttributes(field string, got, want map[string]interface{}) error {
+	for k, wv := range want {
+		av := AttributeValueFromInterface(wv)
+		if av == nil {
+			// Lossy, so not compared.
+			continue
+		}
+		wv = protoAttributeValueToOC(av)
+		gv, ok := got[k]
+		if !ok || (gv != wv && !(isNaN(gv) && isNaN(wv))) {
+			return fmt.Errorf("round trip: %s[%q] mismatch: got %v want %v", field, k, gv, wv)
+		}
+	}
+	return nil
+}
+
+// roundTrippedTime returns t as converted to a proto Timestamp and back.
+func roundTrippedTime(t time.Time) time.Time {
+	return ProtoToTime(TimeToProto(t))
+}
+
+func isNaN(v interface{}) bool {
+	f, ok := v.(float64)
+	return ok && math.IsNaN(f)
+}
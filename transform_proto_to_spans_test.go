@@ -0,0 +1,155 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/orijtech/ocagent_structs_no_grpc"
+	"go.opencensus.io/trace"
+	"go.opencensus.io/trace/tracestate"
+)
+
+// exampleSpanData returns the trace.SpanData used in ExampleTrace_jsonExport.
+func exampleSpanData(t *testing.T) *trace.SpanData {
+	startTime := time.Now()
+	endTime := startTime.Add(17 * time.Second)
+	ocTracestate, err := tracestate.New(new(tracestate.Tracestate), tracestate.Entry{Key: "foo", Value: "bar"},
+		tracestate.Entry{Key: "a", Value: "b"})
+	if err != nil || ocTracestate == nil {
+		t.Fatalf("Failed to create ocTracestate: %v", err)
+	}
+	return &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID:    testTraceID,
+			SpanID:     testSpanID,
+			Tracestate: ocTracestate,
+		},
+		SpanKind:     trace.SpanKindServer,
+		ParentSpanID: trace.SpanID{0xEF, 0xEE, 0xED, 0xEC, 0xEB, 0xEA, 0xE9, 0xE8},
+		Name:         "End-To-End Here",
+		StartTime:    startTime,
+		EndTime:      endTime,
+		Annotations: []trace.Annotation{
+			{
+				Time:    startTime,
+				Message: "start",
+				Attributes: map[string]interface{}{
+					"timeout_ns": int64(12e9),
+					"agent":      "ocagent",
+					"cache_hit":  true,
+				},
+			},
+		},
+		MessageEvents: []trace.MessageEvent{
+			{Time: startTime, EventType: trace.MessageEventTypeSent, UncompressedByteSize: 1024, CompressedByteSize: 512},
+			{Time: endTime, EventType: trace.MessageEventTypeRecv, UncompressedByteSize: 1024, CompressedByteSize: 1000},
+		},
+		Links: []trace.Link{
+			{
+				TraceID: trace.TraceID{0xE0, 0xE1, 0xE2, 0xE3, 0xE4, 0xE5, 0xE6, 0xE7, 0xE8, 0xE9, 0xEA, 0xEB, 0xEC, 0xED, 0xEE, 0xEF},
+				SpanID:  trace.SpanID{0xD0, 0xD1, 0xD2, 0xD3, 0xD4, 0xD5, 0xD6, 0xD7},
+				Type:    trace.LinkTypeChild,
+			},
+		},
+		Status: trace.Status{
+			Code:    trace.StatusCodeInternal,
+			Message: "This is not a drill!",
+		},
+		HasRemoteParent: true,
+		Attributes: map[string]interface{}{
+			"timeout_ns": int64(12e9),
+			"agent":      "ocagent",
+			"cache_hit":  true,
+			"ping_count": int(25),
+		},
+	}
+}
+
+func TestRoundTripSpanData(t *testing.T) {
+	sd := exampleSpanData(t)
+	if _, err := ocagent.RoundTripSpanData(sd); err != nil {
+		t.Fatalf("Round trip of the example span failed: %v", err)
+	}
+
+	// Seeded mutations of the example span.
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		sd := exampleSpanData(t)
+		rng.Read(sd.TraceID[:])
+		rng.Read(sd.SpanID[:])
+		sd.SpanKind = rng.Intn(4)
+		sd.StartTime = time.Unix(0, rng.Int63())
+		sd.Attributes["random"] = rng.Int63()
+		sd.MessageEvents[0].EventType = trace.MessageEventType(rng.Intn(4))
+		if _, err := ocagent.RoundTripSpanData(sd); err != nil {
+			t.Errorf("#%d: round trip failed: %v", i, err)
+		}
+	}
+}
+
+func TestRoundTripSpanData_outOfOrderTimeEvents(t *testing.T) {
+	sd := exampleSpanData(t)
+	sd.Annotations = append([]trace.Annotation{
+		{Time: sd.EndTime, Message: "end"},
+		{Time: sd.StartTime.Add(time.Second), Message: "middle"},
+	}, sd.Annotations...)
+	sd.MessageEvents[0], sd.MessageEvents[1] = sd.MessageEvents[1], sd.MessageEvents[0]
+	if _, err := ocagent.RoundTripSpanData(sd); err != nil {
+		t.Fatalf("Round trip of out of order time events failed: %v", err)
+	}
+}
+
+func TestRoundTripSpanData_lossyValues(t *testing.T) {
+	sd := exampleSpanData(t)
+	sd.Attributes["ratio"] = math.NaN()
+	sd.Annotations[0].Attributes["ratio"] = math.NaN()
+	sd.EndTime = time.Date(20000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := ocagent.RoundTripSpanData(sd); err != nil {
+		t.Fatalf("Round trip of NaN attributes and out of range times failed: %v", err)
+	}
+}
+
+func TestProtoSpansToOpenCensusSpanData_links(t *testing.T) {
+	sd := exampleSpanData(t)
+	sd.Links = []trace.Link{
+		{
+			TraceID:    trace.TraceID{0xE0, 0xE1, 0xE2, 0xE3, 0xE4, 0xE5, 0xE6, 0xE7, 0xE8, 0xE9, 0xEA, 0xEB, 0xEC, 0xED, 0xEE, 0xEF},
+			SpanID:     trace.SpanID{0xD0, 0xD1, 0xD2, 0xD3, 0xD4, 0xD5, 0xD6, 0xD7},
+			Type:       trace.LinkTypeChild,
+			Attributes: map[string]interface{}{"reason": "retry", "attempt": int64(2), "sampled": true},
+		},
+		{
+			TraceID: trace.TraceID{0xC0, 0xC1, 0xC2, 0xC3, 0xC4, 0xC5, 0xC6, 0xC7, 0xC8, 0xC9, 0xCA, 0xCB, 0xCC, 0xCD, 0xCE, 0xCF},
+			SpanID:  trace.SpanID{0xB0, 0xB1, 0xB2, 0xB3, 0xB4, 0xB5, 0xB6, 0xB7},
+			Type:    trace.LinkTypeParent,
+		},
+	}
+
+	sdl, err := ocagent.ProtoSpansToOpenCensusSpanData(ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{sd}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := sdl[0].Links, sd.Links; !reflect.DeepEqual(g, w) {
+		t.Errorf("Links mismatch\n\tGot  %+v\n\tWant %+v", g, w)
+	}
+	if _, err := ocagent.RoundTripSpanData(sd); err != nil {
+		t.Errorf("Round trip failed: %v", err)
+	}
+}
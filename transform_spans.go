@@ -15,14 +15,24 @@
 package ocagent
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"math"
+	"sort"
+	"strings"
 	"time"
+	"unicode/utf8"
 
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"go.opencensus.io/stats"
 	"go.opencensus.io/trace"
 	"go.opencensus.io/trace/tracestate"
 
-	"github.com/golang/protobuf/ptypes/timestamp"
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
 	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	resourcepb "github.com/orijtech/ocagent_structs_no_grpc/pb/resource/v1"
 	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
 )
 
@@ -31,68 +41,431 @@ const (
 	maxMessageEventsPerSpan    = 128
 )
 
+// SpanConversionOptions customizes the conversion of OpenCensus Spans
+// to OpenCensus-Proto Spans. The zero value yields the default conversion.
+type SpanConversionOptions struct {
+	// KeepNilAttributes if set, converts attributes whose value is nil
+	// into an empty AttributeValue instead of skipping them.
+	// By default, nil-valued attributes are skipped and counted
+	// in the DroppedAttributesCount.
+	KeepNilAttributes bool
+
+	// MaxAttributeValueLength if positive, is the maximum length in bytes
+	// of string attribute values of spans, annotations and links.
+	// Longer values are truncated and suffixed with truncationMarker.
+	MaxAttributeValueLength int
+
+	// SampledAttribute if set, adds the reserved boolean attribute
+	// "oc.sampled" to spans whose SpanContext is sampled, given that
+	// OpenCensus-Proto Spans have no field for the sampling decision.
+	// Being added last, it replaces any span attribute of the same key.
+	SampledAttribute bool
+
+	// PromoteAnnotationAttributes if set, copies the attributes of the first
+	// annotation of each span to the span itself, for backends that don't
+	// show annotation attributes. Existing span attributes aren't overwritten.
+	PromoteAnnotationAttributes bool
+
+	// MaxNameLength if positive, is the maximum length in bytes of span
+	// names and annotation messages. Longer ones are truncated, with
+	// TruncatedByteCount recording the number of bytes dropped.
+	MaxNameLength int
+
+	// SortTimeEvents used to order the time events of each span by time
+	// instead of listing all annotations before all message events. Time
+	// events are now always ordered by time, with ties broken as described
+	// by sortTimeEvents, so it has no effect.
+	//
+	// Deprecated: time events are always ordered by time.
+	SortTimeEvents bool
+
+	// InferMissingStartTime if set, uses the EndTime of spans that have
+	// an EndTime but no StartTime as their StartTime, yielding zero
+	// duration spans. By default, such spans are dropped.
+	InferMissingStartTime bool
+
+	// DropSpanNamePrefixes lists span name prefixes, such as "/healthz",
+	// of spans to skip during conversion. Skipped spans are counted by
+	// the MeasureDroppedSpans measure.
+	DropSpanNamePrefixes []string
+
+	// FixInvertedTimestamps if set, swaps the StartTime and EndTime of spans
+	// whose EndTime precedes their StartTime, as clock adjustments may cause,
+	// calling InvertedTimestampsHandler if non-nil. By default, such spans
+	// are dropped.
+	FixInvertedTimestamps bool
+
+	// InvertedTimestampsHandler is called with the name and the original
+	// StartTime and EndTime of every span whose timestamps are swapped.
+	// See FixInvertedTimestamps.
+	InvertedTimestampsHandler func(name string, startTime, endTime time.Time)
+
+	// AttributeKeyNormalizer if non-nil, is applied to every attribute key,
+	// for example strings.ToLower for backends that lowercase keys. If keys
+	// collide after normalization, the value of the key that sorts last wins
+	// and DroppedAttributesCount is incremented for each of the others.
+	AttributeKeyNormalizer func(key string) string
+
+	// ChildSpanCounts if non-nil, maps SpanIDs to the number of children of
+	// the span, for callers that track it out-of-band. Spans found in it get
+	// their ChildSpanCount set, while that of other spans is left unset.
+	ChildSpanCounts map[trace.SpanID]uint32
+
+	// EmitBaggageAsAttributes if set, copies the baggage of spans, which
+	// OpenCensus carries in their Tracestate, to string attributes whose
+	// keys are those of the entries prefixed with "baggage.", for backends
+	// that don't show tracestate. Existing span attributes aren't overwritten.
+	EmitBaggageAsAttributes bool
+
+	// Node if non-nil, is the Node of the converted request.
+	Node *commonpb.Node
+
+	// Resource if non-nil, is the Resource of the converted request.
+	Resource *resourcepb.Resource
+
+	// SpanResources if non-nil, maps SpanIDs to the Resource of the span,
+	// for callers whose spans originate from different resources.
+	SpanResources map[trace.SpanID]*resourcepb.Resource
+
+	// MinimizeSpanResources if set, leaves the Resource of spans unset when
+	// it equals the Resource of the request, as determined by ResourcePbEqual,
+	// since it is then redundant. Only spans with differing resources keep theirs.
+	MinimizeSpanResources bool
+
+	// warnings if non-nil, collects the attributes omitted from the converted
+	// spans because of their unsupported types.
+	warnings *[]ConversionWarning
+
+	// unsupportedAttribute if non-nil, is called with every attribute omitted
+	// because of its unsupported type.
+	unsupportedAttribute func(key string, v interface{})
+}
+
+// MeasureDroppedSpans records the number of spans dropped during conversion
+// because they have an all-zero TraceID or SpanID, because their names match
+// SpanConversionOptions.DropSpanNamePrefixes, because they lack a StartTime
+// or because they end before they start.
+var MeasureDroppedSpans = stats.Int64("ocagent.io/dropped_spans", "The number of spans dropped during conversion", stats.UnitDimensionless)
+
+// sampledAttributeKey is the reserved attribute key recording
+// that a span was sampled. See SpanConversionOptions.SampledAttribute.
+const sampledAttributeKey = "oc.sampled"
+
+// baggageAttributePrefix prefixes the keys of attributes copied from
+// baggage. See SpanConversionOptions.EmitBaggageAsAttributes.
+const baggageAttributePrefix = "baggage."
+
 // OpenCensusSpanDataToProtoSpans converts OpenCensus Spans to OpenCensus-Proto Spans.
 func OpenCensusSpanDataToProtoSpans(sdl []*trace.SpanData) *agenttracepb.ExportTraceServiceRequest {
-	protoSpans := ocSpanDataToPbSpans(sdl)
+	return OpenCensusSpanDataToProtoSpansWithOptions(sdl, nil)
+}
+
+// OpenCensusSpanDataToProtoSpansNoResource converts OpenCensus Spans to OpenCensus-Proto Spans
+// without ever attaching a Resource or detecting one from the environment, for agents behind
+// proxies that inject resource information. The default conversion doesn't attach a Resource
+// either; unlike it, this function guarantees that the Resource stays nil.
+func OpenCensusSpanDataToProtoSpansNoResource(sdl []*trace.SpanData) *agenttracepb.ExportTraceServiceRequest {
+	req := OpenCensusSpanDataToProtoSpans(sdl)
+	if req != nil {
+		req.Resource = nil
+	}
+	return req
+}
+
+// OpenCensusSpanDataToProtoSpansWithMaxAttrValueLen converts OpenCensus Spans to OpenCensus-Proto Spans,
+// truncating string attribute values longer than maxLen bytes. A non-positive maxLen disables truncation.
+func OpenCensusSpanDataToProtoSpansWithMaxAttrValueLen(sdl []*trace.SpanData, maxLen int) *agenttracepb.ExportTraceServiceRequest {
+	return OpenCensusSpanDataToProtoSpansWithOptions(sdl, &SpanConversionOptions{MaxAttributeValueLength: maxLen})
+}
+
+// OpenCensusSpanDataToProtoSpansWithMaxNameLen converts OpenCensus Spans to OpenCensus-Proto Spans,
+// truncating span names and annotation messages longer than maxLen bytes. A non-positive maxLen disables truncation.
+func OpenCensusSpanDataToProtoSpansWithMaxNameLen(sdl []*trace.SpanData, maxLen int) *agenttracepb.ExportTraceServiceRequest {
+	return OpenCensusSpanDataToProtoSpansWithOptions(sdl, &SpanConversionOptions{MaxNameLength: maxLen})
+}
+
+// OpenCensusSpanDataToProtoSpansWithKeyNormalizer converts OpenCensus Spans to OpenCensus-Proto Spans,
+// applying normalize to every attribute key. On collision, the value of the key that sorts last is kept
+// and the DroppedAttributesCount is incremented. A nil normalize leaves the keys as is.
+func OpenCensusSpanDataToProtoSpansWithKeyNormalizer(sdl []*trace.SpanData, normalize func(string) string) *agenttracepb.ExportTraceServiceRequest {
+	return OpenCensusSpanDataToProtoSpansWithOptions(sdl, &SpanConversionOptions{AttributeKeyNormalizer: normalize})
+}
+
+// OpenCensusSpanDataToProtoSpansWithChildCounts converts OpenCensus Spans to OpenCensus-Proto Spans,
+// setting the ChildSpanCount of each span from counts, keyed by SpanID. Spans absent from counts
+// have no ChildSpanCount.
+func OpenCensusSpanDataToProtoSpansWithChildCounts(sdl []*trace.SpanData, counts map[trace.SpanID]uint32) *agenttracepb.ExportTraceServiceRequest {
+	return OpenCensusSpanDataToProtoSpansWithOptions(sdl, &SpanConversionOptions{ChildSpanCounts: counts})
+}
+
+// OpenCensusSpanDataToProtoSpansWithNode converts OpenCensus Spans to OpenCensus-Proto Spans
+// in a request carrying node, returning an error if node is set yet fails ValidateNode.
+func OpenCensusSpanDataToProtoSpansWithNode(sdl []*trace.SpanData, node *commonpb.Node) (*agenttracepb.ExportTraceServiceRequest, error) {
+	if node != nil {
+		if err := ValidateNode(node); err != nil {
+			return nil, err
+		}
+	}
+	return OpenCensusSpanDataToProtoSpansWithOptions(sdl, &SpanConversionOptions{Node: node}), nil
+}
+
+// OpenCensusSpanDataToProtoSpansWithSampledAttribute converts OpenCensus Spans to OpenCensus-Proto Spans,
+// adding the boolean attribute "oc.sampled" to sampled spans, which lets backends filter out
+// unsampled spans that were force-flushed.
+func OpenCensusSpanDataToProtoSpansWithSampledAttribute(sdl []*trace.SpanData) *agenttracepb.ExportTraceServiceRequest {
+	return OpenCensusSpanDataToProtoSpansWithOptions(sdl, &SpanConversionOptions{SampledAttribute: true})
+}
+
+// OpenCensusSpanDataToProtoSpansStrict converts OpenCensus Spans to OpenCensus-Proto Spans,
+// returning an error that lists every span with an all-zero TraceID or SpanID, which agents
+// reject as invalid, instead of skipping such spans like OpenCensusSpanDataToProtoSpans does.
+func OpenCensusSpanDataToProtoSpansStrict(sdl []*trace.SpanData) (*agenttracepb.ExportTraceServiceRequest, error) {
+	var invalid []string
+	for i, sd := range sdl {
+		if sd == nil {
+			continue
+		}
+		if sd.TraceID == (trace.TraceID{}) {
+			invalid = append(invalid, fmt.Sprintf("span #%d %q has an all-zero TraceID", i, sd.Name))
+		}
+		if sd.SpanID == (trace.SpanID{}) {
+			invalid = append(invalid, fmt.Sprintf("span #%d %q has an all-zero SpanID", i, sd.Name))
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("invalid spans: %s", strings.Join(invalid, "; "))
+	}
+	return OpenCensusSpanDataToProtoSpans(sdl), nil
+}
+
+// ConversionWarning describes an attribute that OpenCensusSpanDataToProtoSpansReport
+// couldn't convert because of its unsupported type.
+type ConversionWarning struct {
+	SpanName     string
+	AttributeKey string
+	// Type is the Go type of the attribute value, such as "[]string".
+	Type string
+}
+
+func (w ConversionWarning) String() string {
+	return fmt.Sprintf("span %q: attribute %q of unsupported type %s", w.SpanName, w.AttributeKey, w.Type)
+}
+
+// OpenCensusSpanDataToProtoSpansReport converts OpenCensus Spans to OpenCensus-Proto Spans like
+// OpenCensusSpanDataToProtoSpans does, additionally reporting every attribute of the converted spans,
+// their annotations and their links that was omitted because its type is unsupported, for data-quality
+// audits. Spans dropped during conversion aren't reported. The warnings of each span are ordered by
+// attribute key.
+func OpenCensusSpanDataToProtoSpansReport(sdl []*trace.SpanData) (*agenttracepb.ExportTraceServiceRequest, []ConversionWarning) {
+	var warnings []ConversionWarning
+	req := OpenCensusSpanDataToProtoSpansWithOptions(sdl, &SpanConversionOptions{warnings: &warnings})
+	return req, warnings
+}
+
+// OpenCensusSpanDataToProtoSpansWithOptions converts OpenCensus Spans to OpenCensus-Proto Spans
+// as customized by opts. A nil opts is equivalent to the zero SpanConversionOptions.
+func OpenCensusSpanDataToProtoSpansWithOptions(sdl []*trace.SpanData, opts *SpanConversionOptions) *agenttracepb.ExportTraceServiceRequest {
+	if opts == nil {
+		opts = new(SpanConversionOptions)
+	}
+	protoSpans := ocSpanDataToPbSpans(sdl, opts)
 	if len(protoSpans) == 0 {
 		return nil
 	}
 
-	return &agenttracepb.ExportTraceServiceRequest{
-		Spans: protoSpans,
+	req := &agenttracepb.ExportTraceServiceRequest{
+		Node:     opts.Node,
+		Resource: opts.Resource,
+		Spans:    protoSpans,
+	}
+	if opts.MinimizeSpanResources {
+		minimizeSpanResources(req)
+	}
+	return req
+}
+
+// minimizeSpanResources clears the Resource of the spans in req whose
+// Resource equals that of req.
+func minimizeSpanResources(req *agenttracepb.ExportTraceServiceRequest) {
+	if req.Resource == nil {
+		return
+	}
+	for _, span := range req.Spans {
+		if ResourcePbEqual(span.Resource, req.Resource) {
+			span.Resource = nil
+		}
 	}
 }
 
-func ocSpanDataToPbSpans(sdl []*trace.SpanData) []*tracepb.Span {
+func ocSpanDataToPbSpans(sdl []*trace.SpanData, opts *SpanConversionOptions) []*tracepb.Span {
 	if len(sdl) == 0 {
 		return nil
 	}
 	protoSpans := make([]*tracepb.Span, 0, len(sdl))
+	var dropped int64
 	for _, sd := range sdl {
-		if sd != nil {
-			protoSpans = append(protoSpans, ocSpanToProtoSpan(sd))
+		if sd == nil {
+			continue
+		}
+		if sd.TraceID == (trace.TraceID{}) || sd.SpanID == (trace.SpanID{}) {
+			// Agents reject all-zero IDs as invalid.
+			dropped++
+			logDrop(dropReasonInvalidSpanID, "span %q", sd.Name)
+			continue
+		}
+		if hasAnyPrefix(sd.Name, opts.DropSpanNamePrefixes) {
+			dropped++
+			logDrop(dropReasonSpanNamePrefix, "span %q", sd.Name)
+			continue
+		}
+		if sd.StartTime.IsZero() && !sd.EndTime.IsZero() {
+			if !opts.InferMissingStartTime {
+				dropped++
+				logDrop(dropReasonMissingStartTime, "span %q", sd.Name)
+				continue
+			}
+			inferred := *sd
+			inferred.StartTime = sd.EndTime
+			sd = &inferred
+		}
+		if !sd.EndTime.IsZero() && sd.EndTime.Before(sd.StartTime) {
+			if !opts.FixInvertedTimestamps {
+				dropped++
+				logDrop(dropReasonInvertedTimestamps, "span %q", sd.Name)
+				continue
+			}
+			if opts.InvertedTimestampsHandler != nil {
+				opts.InvertedTimestampsHandler(sd.Name, sd.StartTime, sd.EndTime)
+			}
+			fixed := *sd
+			fixed.StartTime, fixed.EndTime = sd.EndTime, sd.StartTime
+			sd = &fixed
 		}
+		if opts.warnings != nil {
+			protoSpans = append(protoSpans, ocSpanToProtoSpanWithWarnings(sd, opts))
+		} else {
+			protoSpans = append(protoSpans, ocSpanToProtoSpan(sd, opts))
+		}
+	}
+	if dropped > 0 {
+		stats.Record(context.Background(), MeasureDroppedSpans.M(dropped))
 	}
 	return protoSpans
 }
 
-func ocSpanToProtoSpan(sd *trace.SpanData) *tracepb.Span {
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ocSpanToProtoSpanWithWarnings is like ocSpanToProtoSpan, but also appends
+// the attributes of sd omitted because of their types to opts.warnings.
+func ocSpanToProtoSpanWithWarnings(sd *trace.SpanData, opts *SpanConversionOptions) *tracepb.Span {
+	var warnings []ConversionWarning
+	spanOpts := *opts
+	spanOpts.unsupportedAttribute = func(key string, v interface{}) {
+		warnings = append(warnings, ConversionWarning{
+			SpanName:     sd.Name,
+			AttributeKey: key,
+			Type:         fmt.Sprintf("%T", v),
+		})
+	}
+	span := ocSpanToProtoSpan(sd, &spanOpts)
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].AttributeKey < warnings[j].AttributeKey
+	})
+	*opts.warnings = append(*opts.warnings, warnings...)
+	return span
+}
+
+func ocSpanToProtoSpan(sd *trace.SpanData, opts *SpanConversionOptions) *tracepb.Span {
 	if sd == nil {
 		return nil
 	}
 	var namePtr *tracepb.TruncatableString
 	if sd.Name != "" {
-		namePtr = &tracepb.TruncatableString{Value: sd.Name}
+		namePtr = truncatableString(sd.Name, opts.MaxNameLength, "")
 	}
-	return &tracepb.Span{
+	// A zero ParentSpanID denotes a root span, even if the SpanData
+	// inconsistently claims to have a remote parent.
+	var parentSpanID []byte
+	if sd.ParentSpanID != (trace.SpanID{}) {
+		parentSpanID = sd.ParentSpanID[:]
+	}
+	span := &tracepb.Span{
 		TraceId:      sd.TraceID[:],
 		SpanId:       sd.SpanID[:],
-		ParentSpanId: sd.ParentSpanID[:],
+		ParentSpanId: parentSpanID,
 		Status:       ocStatusToProtoStatus(sd.Status),
-		StartTime:    timeToTimestamp(sd.StartTime),
-		EndTime:      timeToTimestamp(sd.EndTime),
-		Links:        ocLinksToProtoLinks(sd.Links),
+		StartTime:    TimeToProto(sd.StartTime),
+		EndTime:      TimeToProto(sd.EndTime),
+		Links:        ocLinksToProtoLinks(sd.Links, opts),
 		Kind:         ocSpanKindToProtoSpanKind(sd.SpanKind),
 		Name:         namePtr,
-		Attributes:   ocAttributesToProtoAttributes(sd.Attributes),
-		TimeEvents:   ocTimeEventsToProtoTimeEvents(sd.Annotations, sd.MessageEvents),
+		Attributes:   ocAttributesToProtoAttributes(sd.Attributes, opts),
+		TimeEvents:   ocTimeEventsToProtoTimeEvents(sd.Annotations, sd.MessageEvents, opts),
 		Tracestate:   ocTracestateToProtoTracestate(sd.Tracestate),
 	}
+	if opts.PromoteAnnotationAttributes && len(sd.Annotations) > 0 {
+		promoted := ocAttributesToProtoAttributes(sd.Annotations[0].Attributes, opts)
+		for k, av := range promoted.GetAttributeMap() {
+			if _, ok := span.Attributes.GetAttributeMap()[k]; !ok {
+				setSpanAttribute(span, k, av)
+			}
+		}
+	}
+	if opts.EmitBaggageAsAttributes && sd.Tracestate != nil {
+		for _, entry := range sd.Tracestate.Entries() {
+			key := baggageAttributePrefix + entry.Key
+			if _, ok := span.Attributes.GetAttributeMap()[key]; !ok {
+				setSpanAttribute(span, key, &tracepb.AttributeValue{
+					Value: &tracepb.AttributeValue_StringValue{
+						StringValue: truncatableString(entry.Value, opts.MaxAttributeValueLength, truncationMarker),
+					},
+				})
+			}
+		}
+	}
+	if rs, ok := opts.SpanResources[sd.SpanID]; ok {
+		span.Resource = rs
+	}
+	if count, ok := opts.ChildSpanCounts[sd.SpanID]; ok {
+		span.ChildSpanCount = &wrappers.UInt32Value{Value: count}
+	}
+	if opts.SampledAttribute && sd.IsSampled() {
+		setSpanAttribute(span, sampledAttributeKey, &tracepb.AttributeValue{
+			Value: &tracepb.AttributeValue_BoolValue{BoolValue: true},
+		})
+	}
+	return span
 }
 
-var blankStatus trace.Status
+// setSpanAttribute sets the attribute key of span to av.
+func setSpanAttribute(span *tracepb.Span, key string, av *tracepb.AttributeValue) {
+	if span.Attributes == nil {
+		span.Attributes = new(tracepb.Span_Attributes)
+	}
+	if span.Attributes.AttributeMap == nil {
+		span.Attributes.AttributeMap = make(map[string]*tracepb.AttributeValue)
+	}
+	span.Attributes.AttributeMap[key] = av
+}
 
+// ocStatusToProtoStatus always returns a Status, given that OpenCensus treats
+// the zero Status as OK, so that backends that require a status see OK
+// rather than an unset status.
 func ocStatusToProtoStatus(status trace.Status) *tracepb.Status {
-	if status == blankStatus {
-		return nil
-	}
 	return &tracepb.Status{
 		Code:    status.Code,
 		Message: status.Message,
 	}
 }
 
-func ocLinksToProtoLinks(links []trace.Link) *tracepb.Span_Links {
+func ocLinksToProtoLinks(links []trace.Link, opts *SpanConversionOptions) *tracepb.Span_Links {
 	if len(links) == 0 {
 		return nil
 	}
@@ -104,9 +477,10 @@ func ocLinksToProtoLinks(links []trace.Link) *tracepb.Span_Links {
 		ocLink := ocLink
 
 		sl = append(sl, &tracepb.Span_Link{
-			TraceId: ocLink.TraceID[:],
-			SpanId:  ocLink.SpanID[:],
-			Type:    ocLinkTypeToProtoLinkType(ocLink.Type),
+			TraceId:    ocLink.TraceID[:],
+			SpanId:     ocLink.SpanID[:],
+			Type:       ocLinkTypeToProtoLinkType(ocLink.Type),
+			Attributes: ocAttributesToProtoAttributes(ocLink.Attributes, opts),
 		})
 	}
 
@@ -126,38 +500,169 @@ func ocLinkTypeToProtoLinkType(oct trace.LinkType) tracepb.Span_Link_Type {
 	}
 }
 
-func ocAttributesToProtoAttributes(attrs map[string]interface{}) *tracepb.Span_Attributes {
+func ocAttributesToProtoAttributes(attrs map[string]interface{}, opts *SpanConversionOptions) *tracepb.Span_Attributes {
 	if len(attrs) == 0 {
 		return nil
 	}
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	normalize := opts.AttributeKeyNormalizer
+	if normalize != nil {
+		// Sort the keys to deterministically decide which value wins on collision.
+		sort.Strings(keys)
+	}
+
 	outMap := make(map[string]*tracepb.AttributeValue)
-	for k, v := range attrs {
-		switch v := v.(type) {
-		case bool:
-			outMap[k] = &tracepb.AttributeValue{Value: &tracepb.AttributeValue_BoolValue{BoolValue: v}}
-
-		case int:
-			outMap[k] = &tracepb.AttributeValue{Value: &tracepb.AttributeValue_IntValue{IntValue: int64(v)}}
-
-		case int64:
-			outMap[k] = &tracepb.AttributeValue{Value: &tracepb.AttributeValue_IntValue{IntValue: v}}
-
-		case string:
-			outMap[k] = &tracepb.AttributeValue{
-				Value: &tracepb.AttributeValue_StringValue{
-					StringValue: &tracepb.TruncatableString{Value: v},
-				},
+	var droppedAttributesCount int
+	put := func(k string, av *tracepb.AttributeValue) {
+		if normalize != nil {
+			nk := normalize(k)
+			if _, ok := outMap[nk]; ok {
+				droppedAttributesCount++
+				logDrop(dropReasonAttributeKeyCollision, "attribute %q normalized to %q", k, nk)
 			}
+			k = nk
 		}
+		outMap[k] = av
+	}
+	for _, k := range keys {
+		v := attrs[k]
+		if v == nil {
+			if opts.KeepNilAttributes {
+				put(k, &tracepb.AttributeValue{})
+			} else {
+				droppedAttributesCount++
+				logDrop(dropReasonNilAttribute, "attribute %q", k)
+			}
+			continue
+		}
+		av := AttributeValueFromInterface(v)
+		if av == nil {
+			logDrop(dropReasonUnsupportedAttribute, "attribute %q of type %T", k, v)
+			if opts.unsupportedAttribute != nil {
+				opts.unsupportedAttribute(k, v)
+			}
+			continue
+		}
+		if sv := av.GetStringValue(); sv != nil && opts.MaxAttributeValueLength > 0 {
+			av.Value = &tracepb.AttributeValue_StringValue{
+				StringValue: truncatableString(sv.Value, opts.MaxAttributeValueLength, truncationMarker),
+			}
+		}
+		put(k, av)
 	}
 	return &tracepb.Span_Attributes{
-		AttributeMap: outMap,
+		AttributeMap:           outMap,
+		DroppedAttributesCount: clip32(droppedAttributesCount),
+	}
+}
+
+// truncationMarker is appended to string attribute values that were truncated.
+const truncationMarker = "...(truncated)"
+
+// truncatableString converts s to a TruncatableString holding at most maxLen bytes,
+// including marker which is appended if s was truncated. Truncation only happens
+// at UTF-8 rune boundaries. TruncatedByteCount records the number of bytes of s
+// that were dropped. A non-positive maxLen disables truncation.
+func truncatableString(s string, maxLen int, marker string) *tracepb.TruncatableString {
+	if maxLen <= 0 || len(s) <= maxLen {
+		return &tracepb.TruncatableString{Value: s}
+	}
+	if len(marker) >= maxLen {
+		marker = ""
+	}
+	n := maxLen - len(marker)
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return &tracepb.TruncatableString{
+		Value:              s[:n] + marker,
+		TruncatedByteCount: clip32(len(s) - n),
 	}
 }
 
+// AttributeValueFromInterface converts v to an AttributeValue, the same way
+// that the attributes of OpenCensus Spans are converted. It supports bool,
+// string, float64 and json.Number values as well as signed and unsigned
+// integers of all sizes, with unsigned integers beyond math.MaxInt64 clamped
+// to math.MaxInt64. time.Time values are converted to RFC 3339 strings with
+// nanosecond precision. It returns nil if v's type is unsupported.
+func AttributeValueFromInterface(v interface{}) *tracepb.AttributeValue {
+	switch v := v.(type) {
+	case bool:
+		return &tracepb.AttributeValue{Value: &tracepb.AttributeValue_BoolValue{BoolValue: v}}
+
+	case int:
+		return intAttributeValue(int64(v))
+	case int8:
+		return intAttributeValue(int64(v))
+	case int16:
+		return intAttributeValue(int64(v))
+	case int32:
+		return intAttributeValue(int64(v))
+	case int64:
+		return intAttributeValue(v)
+
+	case uint:
+		return uintAttributeValue(uint64(v))
+	case uint8:
+		return uintAttributeValue(uint64(v))
+	case uint16:
+		return uintAttributeValue(uint64(v))
+	case uint32:
+		return uintAttributeValue(uint64(v))
+	case uint64:
+		return uintAttributeValue(v)
+
+	case float64:
+		return &tracepb.AttributeValue{Value: &tracepb.AttributeValue_DoubleValue{DoubleValue: v}}
+
+	case string:
+		return &tracepb.AttributeValue{
+			Value: &tracepb.AttributeValue_StringValue{
+				StringValue: &tracepb.TruncatableString{Value: v},
+			},
+		}
+
+	case time.Time:
+		return &tracepb.AttributeValue{
+			Value: &tracepb.AttributeValue_StringValue{
+				StringValue: &tracepb.TruncatableString{Value: v.Format(time.RFC3339Nano)},
+			},
+		}
+
+	case json.Number:
+		// Attributes decoded from JSON with json.Decoder.UseNumber.
+		// Numbers without a decimal point are integers, unless they
+		// overflow an int64 or are in exponent form.
+		if !strings.Contains(v.String(), ".") {
+			if i, err := v.Int64(); err == nil {
+				return intAttributeValue(i)
+			}
+		}
+		if f, err := v.Float64(); err == nil {
+			return &tracepb.AttributeValue{Value: &tracepb.AttributeValue_DoubleValue{DoubleValue: f}}
+		}
+	}
+	return nil
+}
+
+func intAttributeValue(i int64) *tracepb.AttributeValue {
+	return &tracepb.AttributeValue{Value: &tracepb.AttributeValue_IntValue{IntValue: i}}
+}
+
+func uintAttributeValue(u uint64) *tracepb.AttributeValue {
+	if u > math.MaxInt64 {
+		u = math.MaxInt64
+	}
+	return intAttributeValue(int64(u))
+}
+
 // This code is mostly copied from
 // https://github.com/census-ecosystem/opencensus-go-exporter-stackdriver/blob/master/trace_proto.go#L46
-func ocTimeEventsToProtoTimeEvents(as []trace.Annotation, es []trace.MessageEvent) *tracepb.Span_TimeEvents {
+func ocTimeEventsToProtoTimeEvents(as []trace.Annotation, es []trace.MessageEvent, opts *SpanConversionOptions) *tracepb.Span_TimeEvents {
 	if len(as) == 0 && len(es) == 0 {
 		return nil
 	}
@@ -170,13 +675,14 @@ func ocTimeEventsToProtoTimeEvents(as []trace.Annotation, es []trace.MessageEven
 	for i, a := range as {
 		if annotations >= maxAnnotationEventsPerSpan {
 			droppedAnnotationsCount = len(as) - i
+			logDrop(dropReasonAnnotationLimit, "%d annotations over the limit of %d", droppedAnnotationsCount, maxAnnotationEventsPerSpan)
 			break
 		}
 		annotations++
 		timeEvents.TimeEvent = append(timeEvents.TimeEvent,
 			&tracepb.Span_TimeEvent{
-				Time:  timeToTimestamp(a.Time),
-				Value: transformAnnotationToTimeEvent(&a),
+				Time:  TimeToProto(a.Time),
+				Value: transformAnnotationToTimeEvent(&a, opts),
 			},
 		)
 	}
@@ -185,17 +691,21 @@ func ocTimeEventsToProtoTimeEvents(as []trace.Annotation, es []trace.MessageEven
 	for i, e := range es {
 		if messageEvents >= maxMessageEventsPerSpan {
 			droppedMessageEventsCount = len(es) - i
+			logDrop(dropReasonMessageEventLimit, "%d message events over the limit of %d", droppedMessageEventsCount, maxMessageEventsPerSpan)
 			break
 		}
 		messageEvents++
 		timeEvents.TimeEvent = append(timeEvents.TimeEvent,
 			&tracepb.Span_TimeEvent{
-				Time:  timeToTimestamp(e.Time),
+				Time:  TimeToProto(e.Time),
 				Value: transformMessageEventToTimeEvent(&e),
 			},
 		)
 	}
 
+	// Annotations and message events share a single chronological list.
+	sortTimeEvents(timeEvents.TimeEvent)
+
 	// Process dropped counter
 	timeEvents.DroppedAnnotationsCount = clip32(droppedAnnotationsCount)
 	timeEvents.DroppedMessageEventsCount = clip32(droppedMessageEventsCount)
@@ -203,11 +713,41 @@ func ocTimeEventsToProtoTimeEvents(as []trace.Annotation, es []trace.MessageEven
 	return timeEvents
 }
 
-func transformAnnotationToTimeEvent(a *trace.Annotation) *tracepb.Span_TimeEvent_Annotation_ {
+// sortTimeEvents stably sorts tes by time. Time events at the same instant
+// are ordered annotations first, then message events, each group retaining
+// its original relative order. Time events without a time sort first.
+func sortTimeEvents(tes []*tracepb.Span_TimeEvent) {
+	rank := func(te *tracepb.Span_TimeEvent) int {
+		if te.GetAnnotation() != nil {
+			return 0
+		}
+		return 1
+	}
+	sort.SliceStable(tes, func(i, j int) bool {
+		ti, tj := tes[i].GetTime(), tes[j].GetTime()
+		if timestampLess(ti, tj) || timestampLess(tj, ti) {
+			return timestampLess(ti, tj)
+		}
+		return rank(tes[i]) < rank(tes[j])
+	})
+}
+
+// timestampLess reports whether a precedes b, nil timestamps preceding all others.
+func timestampLess(a, b *timestamp.Timestamp) bool {
+	if (a == nil) != (b == nil) {
+		return a == nil
+	}
+	if a.GetSeconds() != b.GetSeconds() {
+		return a.GetSeconds() < b.GetSeconds()
+	}
+	return a.GetNanos() < b.GetNanos()
+}
+
+func transformAnnotationToTimeEvent(a *trace.Annotation, opts *SpanConversionOptions) *tracepb.Span_TimeEvent_Annotation_ {
 	return &tracepb.Span_TimeEvent_Annotation_{
 		Annotation: &tracepb.Span_TimeEvent_Annotation{
-			Description: &tracepb.TruncatableString{Value: a.Message},
-			Attributes:  ocAttributesToProtoAttributes(a.Attributes),
+			Description: truncatableString(a.Message, opts.MaxNameLength, ""),
+			Attributes:  ocAttributesToProtoAttributes(a.Attributes, opts),
 		},
 	}
 }
@@ -215,7 +755,7 @@ func transformAnnotationToTimeEvent(a *trace.Annotation) *tracepb.Span_TimeEvent
 func transformMessageEventToTimeEvent(e *trace.MessageEvent) *tracepb.Span_TimeEvent_MessageEvent_ {
 	return &tracepb.Span_TimeEvent_MessageEvent_{
 		MessageEvent: &tracepb.Span_TimeEvent_MessageEvent{
-			Type:             tracepb.Span_TimeEvent_MessageEvent_Type(e.EventType),
+			Type:             ocMessageEventTypeToProtoType(e.EventType),
 			Id:               uint64(e.MessageID),
 			UncompressedSize: uint64(e.UncompressedByteSize),
 			CompressedSize:   uint64(e.CompressedByteSize),
@@ -223,6 +763,20 @@ func transformMessageEventToTimeEvent(e *trace.MessageEvent) *tracepb.Span_TimeE
 	}
 }
 
+// ocMessageEventTypeToProtoType maps any unrecognized
+// message event type to TYPE_UNSPECIFIED instead of
+// producing an invalid enum value.
+func ocMessageEventTypeToProtoType(et trace.MessageEventType) tracepb.Span_TimeEvent_MessageEvent_Type {
+	switch et {
+	case trace.MessageEventTypeSent:
+		return tracepb.Span_TimeEvent_MessageEvent_SENT
+	case trace.MessageEventTypeRecv:
+		return tracepb.Span_TimeEvent_MessageEvent_RECEIVED
+	default:
+		return tracepb.Span_TimeEvent_MessageEvent_TYPE_UNSPECIFIED
+	}
+}
+
 // clip32 clips an int to the range of an int32.
 func clip32(x int) int32 {
 	if x < math.MinInt32 {
@@ -234,14 +788,6 @@ func clip32(x int) int32 {
 	return int32(x)
 }
 
-func timeToTimestamp(t time.Time) *timestamp.Timestamp {
-	nanoTime := t.UnixNano()
-	return &timestamp.Timestamp{
-		Seconds: nanoTime / 1e9,
-		Nanos:   int32(nanoTime % 1e9),
-	}
-}
-
 func ocSpanKindToProtoSpanKind(kind int) tracepb.Span_SpanKind {
 	switch kind {
 	case trace.SpanKindClient:
@@ -15,18 +15,32 @@
 package ocagent_test
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/orijtech/ocagent_structs_no_grpc"
+	"go.opencensus.io/stats/view"
 	"go.opencensus.io/trace"
 	"go.opencensus.io/trace/tracestate"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes/timestamp"
+	resourcepb "github.com/orijtech/ocagent_structs_no_grpc/pb/resource/v1"
 	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
 )
 
+// testTraceID, testSpanID and testSpanContext identify the spans of the fixtures.
+var (
+	testTraceID     = trace.TraceID{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E, 0x0F}
+	testSpanID      = trace.SpanID{0xFF, 0xFE, 0xFD, 0xFC, 0xFB, 0xFA, 0xF9, 0xF8}
+	testSpanContext = trace.SpanContext{TraceID: testTraceID, SpanID: testSpanID}
+)
+
 func TestOCSpanToProtoSpan_endToEnd(t *testing.T) {
 	// The goal of this test is to ensure that each
 	// spanData is transformed and exported correctly!
@@ -124,7 +138,7 @@ func TestOCSpanToProtoSpan_endToEnd(t *testing.T) {
 		},
 		TimeEvents: &tracepb.Span_TimeEvents{
 			TimeEvent: []*tracepb.Span_TimeEvent{
-				// annotation
+				// annotation and message event at the start
 				{
 					Time: timeToTimestamp(startTime),
 					Value: &tracepb.Span_TimeEvent_Annotation_{
@@ -143,6 +157,18 @@ func TestOCSpanToProtoSpan_endToEnd(t *testing.T) {
 						},
 					},
 				},
+				{
+					Time: timeToTimestamp(startTime),
+					Value: &tracepb.Span_TimeEvent_MessageEvent_{
+						MessageEvent: &tracepb.Span_TimeEvent_MessageEvent{
+							Type:             tracepb.Span_TimeEvent_MessageEvent_SENT,
+							UncompressedSize: 1024,
+							CompressedSize:   512,
+						},
+					},
+				},
+
+				// annotation and message event at the end
 				{
 					Time: timeToTimestamp(endTime),
 					Value: &tracepb.Span_TimeEvent_Annotation_{
@@ -161,18 +187,6 @@ func TestOCSpanToProtoSpan_endToEnd(t *testing.T) {
 						},
 					},
 				},
-
-				// message event
-				{
-					Time: timeToTimestamp(startTime),
-					Value: &tracepb.Span_TimeEvent_MessageEvent_{
-						MessageEvent: &tracepb.Span_TimeEvent_MessageEvent{
-							Type:             tracepb.Span_TimeEvent_MessageEvent_SENT,
-							UncompressedSize: 1024,
-							CompressedSize:   512,
-						},
-					},
-				},
 				{
 					Time: timeToTimestamp(endTime),
 					Value: &tracepb.Span_TimeEvent_MessageEvent_{
@@ -232,3 +246,914 @@ func timeToTimestamp(t time.Time) *timestamp.Timestamp {
 		Nanos:   int32(nanoTime % 1e9),
 	}
 }
+
+func TestOCSpanToProtoSpan_nilAttributes(t *testing.T) {
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "nil-attributes",
+		Attributes: map[string]interface{}{
+			"agent":   "ocagent",
+			"missing": nil,
+		},
+	}
+
+	req := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData})
+	wantAttributes := &tracepb.Span_Attributes{
+		AttributeMap: map[string]*tracepb.AttributeValue{
+			"agent": {Value: &tracepb.AttributeValue_StringValue{
+				StringValue: &tracepb.TruncatableString{Value: "ocagent"},
+			}},
+		},
+		DroppedAttributesCount: 1,
+	}
+	if g, w := req.Spans[0].Attributes, wantAttributes; !reflect.DeepEqual(g, w) {
+		t.Errorf("Default attributes mismatch\n\tGot  %+v\n\tWant %+v", g, w)
+	}
+
+	req = ocagent.OpenCensusSpanDataToProtoSpansWithOptions([]*trace.SpanData{ocSpanData}, &ocagent.SpanConversionOptions{
+		KeepNilAttributes: true,
+	})
+	attrs := req.Spans[0].Attributes
+	if g, w := attrs.DroppedAttributesCount, int32(0); g != w {
+		t.Errorf("DroppedAttributesCount: got %d want %d", g, w)
+	}
+	if av, ok := attrs.AttributeMap["missing"]; !ok || av.Value != nil {
+		t.Errorf("Expected an empty AttributeValue for the nil attribute, got %+v", av)
+	}
+}
+
+func TestOCSpanToProtoSpan_zeroEndTime(t *testing.T) {
+	startTime := time.Now()
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "in-progress",
+		StartTime:   startTime,
+	}
+
+	span := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData}).Spans[0]
+	if span.EndTime != nil {
+		t.Errorf("Expected a nil EndTime, got %v", span.EndTime)
+	}
+	if g, w := span.StartTime, timeToTimestamp(startTime); !reflect.DeepEqual(g, w) {
+		t.Errorf("StartTime: got %v want %v", g, w)
+	}
+}
+
+func TestOCSpanToProtoSpan_jsonNumberAttributes(t *testing.T) {
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "json-numbers",
+		Attributes: map[string]interface{}{
+			"retries": json.Number("3"),
+			"ratio":   json.Number("0.25"),
+		},
+	}
+
+	span := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData}).Spans[0]
+	want := map[string]*tracepb.AttributeValue{
+		"retries": {Value: &tracepb.AttributeValue_IntValue{IntValue: 3}},
+		"ratio":   {Value: &tracepb.AttributeValue_DoubleValue{DoubleValue: 0.25}},
+	}
+	if g, w := span.Attributes.AttributeMap, want; !reflect.DeepEqual(g, w) {
+		t.Errorf("Attributes mismatch\n\tGot  %+v\n\tWant %+v", g, w)
+	}
+}
+
+func TestOCSpanToProtoSpan_maxAttributeValueLength(t *testing.T) {
+	longValue := strings.Repeat("abcdefghij", 10)
+	attrs := map[string]interface{}{
+		"long":  longValue,
+		"short": "ok",
+	}
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "truncation",
+		Attributes:  attrs,
+		Annotations: []trace.Annotation{{Message: "annotation", Attributes: attrs}},
+		Links:       []trace.Link{{Type: trace.LinkTypeChild, Attributes: attrs}},
+	}
+
+	const maxLen = 32
+	span := ocagent.OpenCensusSpanDataToProtoSpansWithMaxAttrValueLen([]*trace.SpanData{ocSpanData}, maxLen).Spans[0]
+	attributeMaps := []*tracepb.Span_Attributes{
+		span.Attributes,
+		span.TimeEvents.TimeEvent[0].GetAnnotation().Attributes,
+		span.Links.Link[0].Attributes,
+	}
+	for i, attributes := range attributeMaps {
+		long := attributes.AttributeMap["long"].GetStringValue()
+		if g := len(long.Value); g > maxLen {
+			t.Errorf("#%d: truncated value has length %d, exceeding %d", i, g, maxLen)
+		}
+		if !strings.HasSuffix(long.Value, "...(truncated)") {
+			t.Errorf("#%d: expected a truncation marker in %q", i, long.Value)
+		}
+		if !strings.HasPrefix(longValue, strings.TrimSuffix(long.Value, "...(truncated)")) {
+			t.Errorf("#%d: truncated value %q isn't a prefix of the original", i, long.Value)
+		}
+		if g, w := attributes.AttributeMap["short"].GetStringValue().Value, "ok"; g != w {
+			t.Errorf("#%d: short value: got %q want %q", i, g, w)
+		}
+		if g, w := attributes.DroppedAttributesCount, int32(0); g != w {
+			t.Errorf("#%d: DroppedAttributesCount: got %d want %d", i, g, w)
+		}
+	}
+
+	// A non-positive maxLen means no truncation.
+	span = ocagent.OpenCensusSpanDataToProtoSpansWithMaxAttrValueLen([]*trace.SpanData{ocSpanData}, 0).Spans[0]
+	if g, w := span.Attributes.AttributeMap["long"].GetStringValue().Value, longValue; g != w {
+		t.Errorf("Expected no truncation, got %q", g)
+	}
+}
+
+func TestOCSpanToProtoSpan_unknownMessageEventType(t *testing.T) {
+	startTime := time.Now()
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "unknown-message-event",
+		MessageEvents: []trace.MessageEvent{
+			{Time: startTime, EventType: trace.MessageEventType(42), UncompressedByteSize: 10},
+			{Time: startTime, EventType: trace.MessageEventTypeRecv, UncompressedByteSize: 20},
+		},
+	}
+
+	timeEvents := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData}).Spans[0].TimeEvents.TimeEvent
+	if g, w := len(timeEvents), 2; g != w {
+		t.Fatalf("Number of time events: got %d want %d", g, w)
+	}
+	wantTypes := []tracepb.Span_TimeEvent_MessageEvent_Type{
+		tracepb.Span_TimeEvent_MessageEvent_TYPE_UNSPECIFIED,
+		tracepb.Span_TimeEvent_MessageEvent_RECEIVED,
+	}
+	for i, te := range timeEvents {
+		if g, w := te.GetMessageEvent().Type, wantTypes[i]; g != w {
+			t.Errorf("#%d: Type: got %v want %v", i, g, w)
+		}
+	}
+}
+
+func TestOCSpanToProtoSpan_remoteParentWithoutParentSpanID(t *testing.T) {
+	ocSpanData := &trace.SpanData{
+		SpanContext:     testSpanContext,
+		Name:            "inconsistent-root",
+		HasRemoteParent: true,
+	}
+
+	span := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData}).Spans[0]
+	if len(span.ParentSpanId) != 0 {
+		t.Errorf("Expected a root span without a ParentSpanId, got %x", span.ParentSpanId)
+	}
+	if span.SameProcessAsParentSpan != nil {
+		t.Errorf("Expected SameProcessAsParentSpan to be unset, got %v", span.SameProcessAsParentSpan)
+	}
+}
+
+func TestOCSpanToProtoSpan_sampledAttribute(t *testing.T) {
+	sampled := &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID:      testTraceID,
+			SpanID:       testSpanID,
+			TraceOptions: 1,
+		},
+		Name: "sampled",
+	}
+	unsampled := &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID: testTraceID,
+			SpanID:  trace.SpanID{0xEF, 0xEE, 0xED, 0xEC, 0xEB, 0xEA, 0xE9, 0xE8},
+		},
+		Name:       "unsampled",
+		Attributes: map[string]interface{}{"agent": "ocagent"},
+	}
+
+	spans := ocagent.OpenCensusSpanDataToProtoSpansWithSampledAttribute([]*trace.SpanData{sampled, unsampled}).Spans
+	want := &tracepb.AttributeValue{Value: &tracepb.AttributeValue_BoolValue{BoolValue: true}}
+	if g := spans[0].Attributes.GetAttributeMap()["oc.sampled"]; !reflect.DeepEqual(g, want) {
+		t.Errorf("Sampled span: got %v want %v", g, want)
+	}
+	if g, ok := spans[1].Attributes.GetAttributeMap()["oc.sampled"]; ok {
+		t.Errorf("Unsampled span: unexpected attribute %v", g)
+	}
+
+	spans = ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{sampled}).Spans
+	if spans[0].Attributes != nil {
+		t.Errorf("Expected no attributes by default, got %v", spans[0].Attributes)
+	}
+}
+
+func TestOCSpanToProtoSpan_promoteAnnotationAttributes(t *testing.T) {
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "promotion",
+		Attributes:  map[string]interface{}{"agent": "ocagent"},
+		Annotations: []trace.Annotation{
+			{Message: "first", Attributes: map[string]interface{}{"agent": "annotation", "cache_hit": true}},
+			{Message: "second", Attributes: map[string]interface{}{"ping_count": int64(25)}},
+		},
+	}
+
+	span := ocagent.OpenCensusSpanDataToProtoSpansWithOptions([]*trace.SpanData{ocSpanData}, &ocagent.SpanConversionOptions{
+		PromoteAnnotationAttributes: true,
+	}).Spans[0]
+	want := map[string]*tracepb.AttributeValue{
+		"agent": {Value: &tracepb.AttributeValue_StringValue{
+			StringValue: &tracepb.TruncatableString{Value: "ocagent"},
+		}},
+		"cache_hit": {Value: &tracepb.AttributeValue_BoolValue{BoolValue: true}},
+	}
+	if g, w := span.Attributes.AttributeMap, want; !reflect.DeepEqual(g, w) {
+		t.Errorf("Attributes mismatch\n\tGot  %+v\n\tWant %+v", g, w)
+	}
+}
+
+func TestOCSpanToProtoSpan_maxNameLength(t *testing.T) {
+	longName := strings.Repeat("abcdefghij", 5)
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        longName,
+		Annotations: []trace.Annotation{{Message: longName}, {Message: "short"}},
+	}
+
+	span := ocagent.OpenCensusSpanDataToProtoSpansWithMaxNameLen([]*trace.SpanData{ocSpanData}, 20).Spans[0]
+	want := &tracepb.TruncatableString{Value: longName[:20], TruncatedByteCount: 30}
+	if g := span.Name; !reflect.DeepEqual(g, want) {
+		t.Errorf("Name mismatch\n\tGot  %+v\n\tWant %+v", g, want)
+	}
+	if g := span.TimeEvents.TimeEvent[0].GetAnnotation().Description; !reflect.DeepEqual(g, want) {
+		t.Errorf("Annotation description mismatch\n\tGot  %+v\n\tWant %+v", g, want)
+	}
+	short := &tracepb.TruncatableString{Value: "short"}
+	if g := span.TimeEvents.TimeEvent[1].GetAnnotation().Description; !reflect.DeepEqual(g, short) {
+		t.Errorf("Short description mismatch\n\tGot  %+v\n\tWant %+v", g, short)
+	}
+
+	span = ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData}).Spans[0]
+	if g, w := span.Name, (&tracepb.TruncatableString{Value: longName}); !reflect.DeepEqual(g, w) {
+		t.Errorf("Default name mismatch\n\tGot  %+v\n\tWant %+v", g, w)
+	}
+}
+
+func TestOCSpanToProtoSpan_sortTimeEvents(t *testing.T) {
+	start := time.Date(2019, time.January, 2, 3, 4, 5, 6, time.UTC)
+	tie := start.Add(10 * time.Millisecond)
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "sorted",
+		Annotations: []trace.Annotation{
+			{Time: start.Add(20 * time.Millisecond), Message: "late"},
+			{Time: tie, Message: "tie-1"},
+			{Time: tie, Message: "tie-2"},
+		},
+		MessageEvents: []trace.MessageEvent{
+			{Time: tie, EventType: trace.MessageEventTypeSent, MessageID: 1},
+			{Time: start, EventType: trace.MessageEventTypeRecv, MessageID: 2},
+		},
+	}
+
+	describe := func(te *tracepb.Span_TimeEvent) string {
+		if a := te.GetAnnotation(); a != nil {
+			return a.Description.Value
+		}
+		return fmt.Sprintf("message-%d", te.GetMessageEvent().Id)
+	}
+	want := []string{"message-2", "tie-1", "tie-2", "message-1", "late"}
+	for i := 0; i < 6; i++ {
+		// Time events are sorted whether or not the deprecated option is set.
+		opts := &ocagent.SpanConversionOptions{SortTimeEvents: i%2 == 0}
+		span := ocagent.OpenCensusSpanDataToProtoSpansWithOptions([]*trace.SpanData{ocSpanData}, opts).Spans[0]
+		var got []string
+		for _, te := range span.TimeEvents.TimeEvent {
+			got = append(got, describe(te))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("Run #%d: got %v want %v", i, got, want)
+		}
+	}
+}
+
+func TestOCSpanToProtoSpan_missingStartTime(t *testing.T) {
+	end := time.Date(2019, time.January, 2, 3, 4, 5, 6, time.UTC)
+	newSpanData := func(name string, start time.Time) *trace.SpanData {
+		return &trace.SpanData{
+			SpanContext: testSpanContext,
+			Name:        name,
+			StartTime:   start,
+			EndTime:     end,
+		}
+	}
+	sdl := []*trace.SpanData{
+		newSpanData("no-start", time.Time{}),
+		newSpanData("complete", end.Add(-time.Second)),
+	}
+
+	// By default, the span without a StartTime is dropped.
+	spans := ocagent.OpenCensusSpanDataToProtoSpans(sdl).Spans
+	if len(spans) != 1 || spans[0].Name.Value != "complete" {
+		t.Fatalf("Expected only the complete span, got %v", spans)
+	}
+	if g, w := countDroppedSpans(t, sdl, nil), 1.0; g != w {
+		t.Errorf("Dropped spans: got %v want %v", g, w)
+	}
+
+	spans = ocagent.OpenCensusSpanDataToProtoSpansWithOptions(sdl, &ocagent.SpanConversionOptions{
+		InferMissingStartTime: true,
+	}).Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected both spans, got %d", len(spans))
+	}
+	if g, w := spans[0].StartTime, timeToTimestamp(end); !reflect.DeepEqual(g, w) {
+		t.Errorf("Inferred StartTime: got %v want %v", g, w)
+	}
+	if g, w := spans[0].EndTime, timeToTimestamp(end); !reflect.DeepEqual(g, w) {
+		t.Errorf("EndTime: got %v want %v", g, w)
+	}
+	if sdl[0].StartTime != (time.Time{}) {
+		t.Errorf("Expected the SpanData to be left unmodified, got StartTime %v", sdl[0].StartTime)
+	}
+}
+
+func TestAttributeValueFromInterface(t *testing.T) {
+	intValue := func(i int64) *tracepb.AttributeValue {
+		return &tracepb.AttributeValue{Value: &tracepb.AttributeValue_IntValue{IntValue: i}}
+	}
+	tests := []struct {
+		in   interface{}
+		want *tracepb.AttributeValue
+	}{
+		{in: "ocagent", want: &tracepb.AttributeValue{Value: &tracepb.AttributeValue_StringValue{
+			StringValue: &tracepb.TruncatableString{Value: "ocagent"},
+		}}},
+		{in: true, want: &tracepb.AttributeValue{Value: &tracepb.AttributeValue_BoolValue{BoolValue: true}}},
+		{in: int(-1), want: intValue(-1)},
+		{in: int8(-8), want: intValue(-8)},
+		{in: int16(-16), want: intValue(-16)},
+		{in: int32(-32), want: intValue(-32)},
+		{in: int64(math.MinInt64), want: intValue(math.MinInt64)},
+		{in: uint(1), want: intValue(1)},
+		{in: uint8(8), want: intValue(8)},
+		{in: uint16(16), want: intValue(16)},
+		{in: uint32(math.MaxUint32), want: intValue(math.MaxUint32)},
+		{in: uint64(math.MaxInt64), want: intValue(math.MaxInt64)},
+		{in: uint64(math.MaxUint64), want: intValue(math.MaxInt64)},
+		{in: 2.5, want: &tracepb.AttributeValue{Value: &tracepb.AttributeValue_DoubleValue{DoubleValue: 2.5}}},
+		{in: struct{ Name string }{"unsupported"}, want: nil},
+		{in: nil, want: nil},
+	}
+
+	for _, tt := range tests {
+		if got := ocagent.AttributeValueFromInterface(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%T(%v): got %v want %v", tt.in, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOCSpanToProtoSpan_dropSpanNamePrefixes(t *testing.T) {
+	var sdl []*trace.SpanData
+	for _, name := range []string{"/healthz", "/api/users", "/healthz/ready", "/readyz", "/api/healthz"} {
+		sdl = append(sdl, &trace.SpanData{
+			SpanContext: testSpanContext,
+			Name:        name,
+		})
+	}
+
+	opts := &ocagent.SpanConversionOptions{
+		DropSpanNamePrefixes: []string{"/healthz", "/readyz"},
+	}
+	req := ocagent.OpenCensusSpanDataToProtoSpansWithOptions(sdl, opts)
+	var got []string
+	for _, span := range req.Spans {
+		got = append(got, span.Name.Value)
+	}
+	if want := []string{"/api/users", "/api/healthz"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Kept spans: got %v want %v", got, want)
+	}
+	if g, w := countDroppedSpans(t, sdl, opts), 3.0; g != w {
+		t.Errorf("Dropped spans: got %v want %v", g, w)
+	}
+}
+
+// countDroppedSpans returns the number of spans that MeasureDroppedSpans
+// records while converting sdl with opts.
+func countDroppedSpans(t *testing.T, sdl []*trace.SpanData, opts *ocagent.SpanConversionOptions) float64 {
+	t.Helper()
+	droppedView := &view.View{
+		Name:        "ocagent.io/dropped_spans_test",
+		Measure:     ocagent.MeasureDroppedSpans,
+		Aggregation: view.Sum(),
+	}
+	if err := view.Register(droppedView); err != nil {
+		t.Fatalf("Failed to register the view: %v", err)
+	}
+	defer view.Unregister(droppedView)
+
+	ocagent.OpenCensusSpanDataToProtoSpansWithOptions(sdl, opts)
+	rows, err := view.RetrieveData(droppedView.Name)
+	if err != nil {
+		t.Fatalf("Failed to retrieve the dropped spans: %v", err)
+	}
+	if len(rows) == 0 {
+		return 0
+	}
+	return rows[0].Data.(*view.SumData).Value
+}
+
+func TestOpenCensusSpanDataToProtoSpansStrict(t *testing.T) {
+	valid := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "valid",
+	}
+	zeroTraceID := &trace.SpanData{
+		SpanContext: trace.SpanContext{SpanID: testSpanID},
+		Name:        "zero-trace-id",
+	}
+
+	req, err := ocagent.OpenCensusSpanDataToProtoSpansStrict([]*trace.SpanData{valid})
+	if err != nil {
+		t.Fatalf("Valid span: unexpected error: %v", err)
+	}
+	if len(req.Spans) != 1 || req.Spans[0].Name.Value != "valid" {
+		t.Errorf("Valid span: got %v", req.Spans)
+	}
+
+	req, err = ocagent.OpenCensusSpanDataToProtoSpansStrict([]*trace.SpanData{valid, zeroTraceID})
+	if err == nil {
+		t.Fatalf("Expected an error, got %v", req)
+	}
+	if !strings.Contains(err.Error(), `span #1 "zero-trace-id" has an all-zero TraceID`) {
+		t.Errorf("Error does not name the invalid span: %v", err)
+	}
+	if strings.Contains(err.Error(), "SpanID") || strings.Contains(err.Error(), `"valid"`) {
+		t.Errorf("Error names more than the invalid span: %v", err)
+	}
+
+	// The non-strict conversion skips the invalid span.
+	spans := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{valid, zeroTraceID}).Spans
+	if len(spans) != 1 || spans[0].Name.Value != "valid" {
+		t.Errorf("Non-strict: expected only the valid span, got %v", spans)
+	}
+	if g, w := countDroppedSpans(t, []*trace.SpanData{valid, zeroTraceID}, nil), 1.0; g != w {
+		t.Errorf("Non-strict: dropped spans: got %v want %v", g, w)
+	}
+}
+
+func TestOCSpanToProtoSpan_reservedAttributeKeys(t *testing.T) {
+	keys := []string{"name", "value", "attributes", "span_id", "string_value", "@type", "oc.sampled.not", "key with spaces", "ключ"}
+	attrs := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		attrs[key] = key + "-value"
+	}
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "reserved",
+		Attributes:  attrs,
+	}
+
+	span := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData}).Spans[0]
+	if g, w := len(span.Attributes.AttributeMap), len(keys); g != w {
+		t.Errorf("Number of attributes: got %d want %d", g, w)
+	}
+	for _, key := range keys {
+		av, ok := span.Attributes.AttributeMap[key]
+		if !ok {
+			t.Errorf("Attribute %q is missing", key)
+			continue
+		}
+		if g, w := av.GetStringValue().GetValue(), key+"-value"; g != w {
+			t.Errorf("Attribute %q: got %q want %q", key, g, w)
+		}
+	}
+	if g, w := span.Name.Value, "reserved"; g != w {
+		t.Errorf("Span name: got %q want %q", g, w)
+	}
+}
+
+func TestOCSpanToProtoSpan_interleavedTimeEvents(t *testing.T) {
+	start := time.Date(2019, time.January, 2, 3, 4, 5, 6, time.UTC)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "interleaved",
+		Annotations: []trace.Annotation{{Time: at(1), Message: "a1"}, {Time: at(3), Message: "a3"}, {Time: at(5), Message: "a5"}},
+		MessageEvents: []trace.MessageEvent{
+			{Time: at(0), EventType: trace.MessageEventTypeSent, MessageID: 1},
+			{Time: at(2), EventType: trace.MessageEventTypeRecv, MessageID: 2},
+			{Time: at(4), EventType: trace.MessageEventTypeSent, MessageID: 3},
+		},
+	}
+
+	span := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData}).Spans[0]
+	tes := span.TimeEvents.TimeEvent
+	if g, w := len(tes), 6; g != w {
+		t.Fatalf("Number of time events: got %d want %d", g, w)
+	}
+	for i, te := range tes {
+		if g, w := te.Time, timeToTimestamp(at(i)); !reflect.DeepEqual(g, w) {
+			t.Errorf("#%d: time got %v want %v", i, g, w)
+		}
+		if isAnnotation := te.GetAnnotation() != nil; isAnnotation != (i%2 == 1) {
+			t.Errorf("#%d: unexpected time event %v", i, te)
+		}
+	}
+}
+
+func TestOpenCensusSpanDataToProtoSpansNoResource(t *testing.T) {
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "no-resource",
+	}
+
+	req := ocagent.OpenCensusSpanDataToProtoSpansNoResource([]*trace.SpanData{ocSpanData})
+	if req.Resource != nil {
+		t.Errorf("Expected no Resource, got %v", req.Resource)
+	}
+	if len(req.Spans) != 1 || req.Spans[0].Resource != nil {
+		t.Errorf("Expected a single span without a Resource, got %v", req.Spans)
+	}
+	if req := ocagent.OpenCensusSpanDataToProtoSpansNoResource(nil); req != nil {
+		t.Errorf("Expected nil for no spans, got %v", req)
+	}
+}
+
+func TestOCSpanToProtoSpan_unknownLinkTypeAndSpanKind(t *testing.T) {
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "unknown-enums",
+		SpanKind:    42,
+		Links: []trace.Link{
+			{Type: trace.LinkType(42)},
+			{Type: trace.LinkType(-1)},
+			{Type: trace.LinkTypeParent},
+		},
+	}
+
+	span := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData}).Spans[0]
+	if g, w := span.Kind, tracepb.Span_SPAN_KIND_UNSPECIFIED; g != w {
+		t.Errorf("Kind: got %v want %v", g, w)
+	}
+	wantTypes := []tracepb.Span_Link_Type{
+		tracepb.Span_Link_TYPE_UNSPECIFIED,
+		tracepb.Span_Link_TYPE_UNSPECIFIED,
+		tracepb.Span_Link_PARENT_LINKED_SPAN,
+	}
+	for i, link := range span.Links.Link {
+		if g, w := link.Type, wantTypes[i]; g != w {
+			t.Errorf("Link #%d: Type: got %v want %v", i, g, w)
+		}
+	}
+}
+
+func TestOCSpanToProtoSpan_zeroStatusIsOK(t *testing.T) {
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "zero-status",
+	}
+
+	span := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData}).Spans[0]
+	if span.Status == nil {
+		t.Fatal("Expected a Status for the zero trace.Status")
+	}
+	if g, w := span.Status.Code, int32(trace.StatusCodeOK); g != w {
+		t.Errorf("Code: got %d want %d", g, w)
+	}
+	if span.Status.Message != "" {
+		t.Errorf("Expected an empty message, got %q", span.Status.Message)
+	}
+}
+
+func TestOCSpanToProtoSpan_invertedTimestamps(t *testing.T) {
+	start := time.Date(2019, time.January, 2, 3, 4, 5, 6, time.UTC)
+	end := start.Add(-250 * time.Millisecond)
+	sdl := []*trace.SpanData{{
+		SpanContext: testSpanContext,
+		Name:        "inverted",
+		StartTime:   start,
+		EndTime:     end,
+	}}
+
+	// By default, the inverted span is dropped.
+	if req := ocagent.OpenCensusSpanDataToProtoSpans(sdl); req != nil && len(req.Spans) != 0 {
+		t.Fatalf("Expected the inverted span to be dropped, got %v", req.Spans)
+	}
+	if g, w := countDroppedSpans(t, sdl, nil), 1.0; g != w {
+		t.Errorf("Dropped spans: got %v want %v", g, w)
+	}
+
+	var handled []time.Time
+	spans := ocagent.OpenCensusSpanDataToProtoSpansWithOptions(sdl, &ocagent.SpanConversionOptions{
+		FixInvertedTimestamps: true,
+		InvertedTimestampsHandler: func(name string, startTime, endTime time.Time) {
+			handled = append(handled, startTime, endTime)
+		},
+	}).Spans
+	if len(spans) != 1 {
+		t.Fatalf("Expected the fixed span, got %d spans", len(spans))
+	}
+	if g, w := spans[0].StartTime, timeToTimestamp(end); !reflect.DeepEqual(g, w) {
+		t.Errorf("StartTime: got %v want %v", g, w)
+	}
+	if g, w := spans[0].EndTime, timeToTimestamp(start); !reflect.DeepEqual(g, w) {
+		t.Errorf("EndTime: got %v want %v", g, w)
+	}
+	if g, w := handled, []time.Time{start, end}; !reflect.DeepEqual(g, w) {
+		t.Errorf("Handler: got %v want %v", g, w)
+	}
+	if g := countDroppedSpans(t, sdl, &ocagent.SpanConversionOptions{FixInvertedTimestamps: true}); g != 0 {
+		t.Errorf("Fixed: expected no dropped spans, got %v", g)
+	}
+	if sdl[0].StartTime != start {
+		t.Errorf("Expected the SpanData to be left unmodified, got StartTime %v", sdl[0].StartTime)
+	}
+}
+
+func TestOCSpanToProtoSpan_int64Attributes(t *testing.T) {
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "large-ints",
+		Attributes: map[string]interface{}{
+			"timeout_ns": int64(12e9),
+			"age":        int(25),
+		},
+	}
+
+	attributes := ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData}).Spans[0].Attributes.AttributeMap
+	for key, want := range map[string]int64{"timeout_ns": 12e9, "age": 25} {
+		iv, ok := attributes[key].GetValue().(*tracepb.AttributeValue_IntValue)
+		if !ok {
+			t.Errorf("%q: got %T want an IntValue", key, attributes[key].GetValue())
+			continue
+		}
+		if iv.IntValue != want {
+			t.Errorf("%q: got %d want %d", key, iv.IntValue, want)
+		}
+	}
+}
+
+func TestOCSpanToProtoSpan_nilAndEmptySlices(t *testing.T) {
+	newSpanData := func(as []trace.Annotation, es []trace.MessageEvent, links []trace.Link) *trace.SpanData {
+		return &trace.SpanData{
+			SpanContext:   testSpanContext,
+			Name:          "no-events",
+			Annotations:   as,
+			MessageEvents: es,
+			Links:         links,
+		}
+	}
+	sdl := []*trace.SpanData{
+		newSpanData(nil, nil, nil),
+		newSpanData([]trace.Annotation{}, []trace.MessageEvent{}, []trace.Link{}),
+	}
+
+	spans := ocagent.OpenCensusSpanDataToProtoSpans(sdl).Spans
+	if len(spans) != len(sdl) {
+		t.Fatalf("Got %d spans want %d", len(spans), len(sdl))
+	}
+	for i, span := range spans {
+		if span.TimeEvents != nil {
+			t.Errorf("#%d: expected no TimeEvents, got %v", i, span.TimeEvents)
+		}
+		if span.Links != nil {
+			t.Errorf("#%d: expected no Links, got %v", i, span.Links)
+		}
+		if g, w := span.Name.GetValue(), "no-events"; g != w {
+			t.Errorf("#%d: Name got %q want %q", i, g, w)
+		}
+		if _, err := proto.Marshal(span); err != nil {
+			t.Errorf("#%d: failed to marshal: %v", i, err)
+		}
+	}
+}
+
+func TestOpenCensusSpanDataToProtoSpansWithKeyNormalizer(t *testing.T) {
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "colliding-keys",
+		Attributes: map[string]interface{}{
+			"Host": "upper",
+			"host": "lower",
+			"Port": int64(80),
+		},
+	}
+	sdl := []*trace.SpanData{ocSpanData}
+
+	attributes := ocagent.OpenCensusSpanDataToProtoSpansWithKeyNormalizer(sdl, strings.ToLower).Spans[0].Attributes
+	want := map[string]*tracepb.AttributeValue{
+		// "host" sorts after "Host" hence its value wins.
+		"host": {Value: &tracepb.AttributeValue_StringValue{StringValue: &tracepb.TruncatableString{Value: "lower"}}},
+		"port": {Value: &tracepb.AttributeValue_IntValue{IntValue: 80}},
+	}
+	if !reflect.DeepEqual(attributes.AttributeMap, want) {
+		t.Errorf("AttributeMap: got %v want %v", attributes.AttributeMap, want)
+	}
+	if g, w := attributes.DroppedAttributesCount, int32(1); g != w {
+		t.Errorf("DroppedAttributesCount: got %d want %d", g, w)
+	}
+
+	// A nil normalizer leaves the keys as is.
+	attributes = ocagent.OpenCensusSpanDataToProtoSpansWithKeyNormalizer(sdl, nil).Spans[0].Attributes
+	if g, w := len(attributes.AttributeMap), 3; g != w {
+		t.Errorf("Nil normalizer: got %d attributes want %d", g, w)
+	}
+	if attributes.DroppedAttributesCount != 0 {
+		t.Errorf("Nil normalizer: got DroppedAttributesCount %d", attributes.DroppedAttributesCount)
+	}
+}
+
+func TestAttributeValueFromInterface_time(t *testing.T) {
+	tests := []struct {
+		in   time.Time
+		want string
+	}{
+		{in: time.Date(2019, time.March, 14, 15, 9, 26, 535897932, time.UTC), want: "2019-03-14T15:09:26.535897932Z"},
+		{in: time.Date(2019, time.March, 14, 15, 9, 26, 0, time.FixedZone("", -7*60*60)), want: "2019-03-14T15:09:26-07:00"},
+	}
+	for _, tt := range tests {
+		av := ocagent.AttributeValueFromInterface(tt.in)
+		if g := av.GetStringValue().GetValue(); g != tt.want {
+			t.Errorf("%v: got %q want %q", tt.in, g, tt.want)
+		}
+	}
+}
+
+func TestOCSpanToProtoSpan_duplicateAttributeKeys(t *testing.T) {
+	ocSpanData := &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID:      testTraceID,
+			SpanID:       testSpanID,
+			TraceOptions: 1,
+		},
+		Name: "merged",
+		// Every source below contributes the keys "host" or "oc.sampled".
+		Attributes: map[string]interface{}{
+			"Host":       "span-upper",
+			"host":       "span-lower",
+			"oc.sampled": "user-value",
+		},
+		Annotations: []trace.Annotation{{
+			Message:    "first",
+			Attributes: map[string]interface{}{"HOST": "annotation", "extra": int64(1)},
+		}},
+	}
+
+	span := ocagent.OpenCensusSpanDataToProtoSpansWithOptions([]*trace.SpanData{ocSpanData}, &ocagent.SpanConversionOptions{
+		AttributeKeyNormalizer:      strings.ToLower,
+		PromoteAnnotationAttributes: true,
+		SampledAttribute:            true,
+	}).Spans[0]
+
+	want := map[string]*tracepb.AttributeValue{
+		// The last of the colliding span attributes wins, and promoted
+		// annotation attributes don't replace span attributes.
+		"host":  {Value: &tracepb.AttributeValue_StringValue{StringValue: &tracepb.TruncatableString{Value: "span-lower"}}},
+		"extra": {Value: &tracepb.AttributeValue_IntValue{IntValue: 1}},
+		// The sampled attribute is added last, replacing the user value.
+		"oc.sampled": {Value: &tracepb.AttributeValue_BoolValue{BoolValue: true}},
+	}
+	if !reflect.DeepEqual(span.Attributes.AttributeMap, want) {
+		t.Errorf("AttributeMap: got %v want %v", span.Attributes.AttributeMap, want)
+	}
+}
+
+func TestOpenCensusSpanDataToProtoSpansWithChildCounts(t *testing.T) {
+	newSpanData := func(spanID trace.SpanID) *trace.SpanData {
+		return &trace.SpanData{
+			SpanContext: trace.SpanContext{
+				TraceID: testTraceID,
+				SpanID:  spanID,
+			},
+			Name: "span",
+		}
+	}
+	parentID := testSpanID
+	leafID := trace.SpanID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	counts := map[trace.SpanID]uint32{parentID: 3}
+
+	spans := ocagent.OpenCensusSpanDataToProtoSpansWithChildCounts([]*trace.SpanData{newSpanData(parentID), newSpanData(leafID)}, counts).Spans
+	if g := spans[0].ChildSpanCount; g == nil || g.Value != 3 {
+		t.Errorf("Parent: got ChildSpanCount %v want 3", g)
+	}
+	if g := spans[1].ChildSpanCount; g != nil {
+		t.Errorf("Leaf: got ChildSpanCount %v want nil", g)
+	}
+}
+
+func TestOpenCensusSpanDataToProtoSpansReport(t *testing.T) {
+	ocSpanData := &trace.SpanData{
+		SpanContext: testSpanContext,
+		Name:        "audited",
+		Attributes: map[string]interface{}{
+			"tags":   []string{"a", "b"},
+			"status": "ok",
+			"absent": nil,
+		},
+		Annotations: []trace.Annotation{{
+			Message:    "annotation",
+			Attributes: map[string]interface{}{"matrix": [][]int{{1}}},
+		}},
+	}
+
+	dropped := &trace.SpanData{
+		SpanContext: trace.SpanContext{SpanID: testSpanID},
+		Name:        "zero-trace-id",
+		Attributes:  map[string]interface{}{"tags": []string{"c"}},
+	}
+
+	req, warnings := ocagent.OpenCensusSpanDataToProtoSpansReport([]*trace.SpanData{ocSpanData, dropped})
+	// The dropped span isn't reported.
+	want := []ocagent.ConversionWarning{
+		{SpanName: "audited", AttributeKey: "matrix", Type: "[][]int"},
+		{SpanName: "audited", AttributeKey: "tags", Type: "[]string"},
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("Warnings: got %v want %v", warnings, want)
+	}
+
+	attributes := req.Spans[0].Attributes.AttributeMap
+	if _, ok := attributes["tags"]; ok {
+		t.Errorf("Expected the unsupported attribute to be omitted, got %v", attributes["tags"])
+	}
+	if g := attributes["status"].GetStringValue().GetValue(); g != "ok" {
+		t.Errorf("Supported attribute: got %q want %q", g, "ok")
+	}
+}
+
+func TestOpenCensusSpanDataToProtoSpansEmitBaggageAsAttributes(t *testing.T) {
+	baggage, err := tracestate.New(nil,
+		tracestate.Entry{Key: "tenant", Value: "acme"},
+		tracestate.Entry{Key: "region", Value: "eu"})
+	if err != nil {
+		t.Fatalf("Failed to create tracestate: %v", err)
+	}
+	ocSpanData := &trace.SpanData{
+		SpanContext: trace.SpanContext{
+			TraceID:    testTraceID,
+			SpanID:     testSpanID,
+			Tracestate: baggage,
+		},
+		Name:       "with-baggage",
+		Attributes: map[string]interface{}{"baggage.region": "us"},
+	}
+
+	req := ocagent.OpenCensusSpanDataToProtoSpansWithOptions([]*trace.SpanData{ocSpanData}, &ocagent.SpanConversionOptions{
+		EmitBaggageAsAttributes: true,
+	})
+	attributes := req.Spans[0].Attributes.AttributeMap
+	if g := attributes["baggage.tenant"].GetStringValue().GetValue(); g != "acme" {
+		t.Errorf("baggage.tenant: got %q want %q", g, "acme")
+	}
+	if g := attributes["baggage.region"].GetStringValue().GetValue(); g != "us" {
+		t.Errorf("Expected the existing attribute to be kept, got %q want %q", g, "us")
+	}
+	if g := len(req.Spans[0].Tracestate.GetEntries()); g != 2 {
+		t.Errorf("Expected the Tracestate to be kept, got %d entries", g)
+	}
+
+	req = ocagent.OpenCensusSpanDataToProtoSpans([]*trace.SpanData{ocSpanData})
+	if _, ok := req.Spans[0].Attributes.AttributeMap["baggage.tenant"]; ok {
+		t.Error("Expected no baggage attributes by default")
+	}
+}
+
+func TestOpenCensusSpanDataToProtoSpans_MinimizeSpanResources(t *testing.T) {
+	newSpanData := func(spanID trace.SpanID) *trace.SpanData {
+		return &trace.SpanData{
+			SpanContext: trace.SpanContext{TraceID: testTraceID, SpanID: spanID},
+			Name:        "span",
+		}
+	}
+	identicalID := testSpanID
+	differingID := trace.SpanID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	unsetID := trace.SpanID{0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18}
+	sdl := []*trace.SpanData{newSpanData(identicalID), newSpanData(differingID), newSpanData(unsetID)}
+
+	rs := &resourcepb.Resource{Type: "host", Labels: map[string]string{"zone": "a"}}
+	container := &resourcepb.Resource{Type: "container", Labels: map[string]string{"zone": "a"}}
+	opts := &ocagent.SpanConversionOptions{
+		Resource: rs,
+		SpanResources: map[trace.SpanID]*resourcepb.Resource{
+			identicalID: {Type: "host", Labels: map[string]string{"zone": "a"}},
+			differingID: container,
+		},
+		MinimizeSpanResources: true,
+	}
+
+	req := ocagent.OpenCensusSpanDataToProtoSpansWithOptions(sdl, opts)
+	if req.Resource != rs {
+		t.Errorf("Request Resource: got %v want %v", req.Resource, rs)
+	}
+	if g := req.Spans[0].Resource; g != nil {
+		t.Errorf("Identical span resource: expected it to be cleared, got %v", g)
+	}
+	if g := req.Spans[1].Resource; g != container {
+		t.Errorf("Differing span resource: got %v want %v", g, container)
+	}
+	if g := req.Spans[2].Resource; g != nil {
+		t.Errorf("Unset span resource: got %v", g)
+	}
+
+	// By default, span resources are kept even if redundant.
+	opts.MinimizeSpanResources = false
+	req = ocagent.OpenCensusSpanDataToProtoSpansWithOptions(sdl, opts)
+	if !proto.Equal(req.Spans[0].Resource, rs) {
+		t.Errorf("Default: got %v want %v", req.Spans[0].Resource, rs)
+	}
+}
@@ -16,14 +16,23 @@ package ocagent
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/resource"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
 
 	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/golang/protobuf/ptypes/wrappers"
 
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
 	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
 	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
 )
@@ -34,9 +43,190 @@ var (
 	errNilViewData = errors.New("expecting a non-nil view.Data")
 )
 
+// MetricsConversionOptions customizes the conversion of OpenCensus ViewData
+// to OpenCensus-Proto Metrics. The zero value yields the default conversion.
+type MetricsConversionOptions struct {
+	// DefaultDistributionUnit if non-empty, is the unit of distribution
+	// metrics whose measure has no unit, for example "ms" for latencies.
+	DefaultDistributionUnit string
+
+	// FlattenedResource if non-nil, is folded into the labels of every
+	// metric, for backends that don't support the Resource message.
+	// See OpenCensusViewDataToProtoMetricsFlattenResource.
+	FlattenedResource *resource.Resource
+
+	// SortTimeSeries if set, sorts the timeseries of each metric
+	// by their label values, making the output deterministic.
+	SortTimeSeries bool
+
+	// BucketMismatchHandler if non-nil, is called whenever the bucket counts
+	// of a DistributionData don't match the bounds of its view, for example
+	// because the view was reconfigured. The counts are always reconciled
+	// with the view's bounds; see reconcileBucketCounts.
+	BucketMismatchHandler func(v *view.View, gotBuckets, wantBuckets int)
+
+	// CompactIntegralDoubles if set, emits the DOUBLE points of a metric as
+	// INT64 points, adjusting the descriptor type accordingly, for storage
+	// efficiency. Given that the descriptor type applies to all the
+	// timeseries of a metric, this only happens if every point value of
+	// every timeseries of the metric is integral.
+	CompactIntegralDoubles bool
+
+	// ClampPointsToStart if set, moves the timestamp of any point of a
+	// cumulative metric that is earlier than the start timestamp of its
+	// timeseries, which is invalid, to that start timestamp.
+	ClampPointsToStart bool
+
+	// ClampedPointHandler if non-nil, is called with the original point
+	// time and the start time whenever a point is clamped.
+	// See ClampPointsToStart.
+	ClampedPointHandler func(v *view.View, pointTime, startTime time.Time)
+
+	// Resources if non-empty, holds per-metric resources: the metric
+	// converted from the i-th view.Data gets Resources[i] as its Resource.
+	// View data beyond the end of Resources, or whose entry is nil,
+	// produce metrics without a Resource.
+	Resources []*resource.Resource
+
+	// EmitConvertDuration if set, appends a GAUGE_DOUBLE metric named
+	// "scrape_duration_seconds" holding the time spent converting,
+	// for observability of the export pipeline.
+	EmitConvertDuration bool
+
+	// NearOverflowThreshold if positive, is the magnitude at or beyond which
+	// INT64 point values, such as long-running counts, are reported to
+	// NearOverflowHandler as being close to overflowing an int64.
+	NearOverflowThreshold int64
+
+	// NearOverflowHandler is called with every INT64 point value whose
+	// magnitude is at least NearOverflowThreshold.
+	NearOverflowHandler func(v *view.View, value int64)
+
+	// NormalizeLabelKeyCase if set, folds label keys to lower case, so that
+	// tag keys differing only in case, such as "Host" and "host", map to a
+	// single label key. Timeseries whose label values become identical are
+	// merged: the values of cumulative points are added up, while gauges
+	// keep the value of the last row.
+	NormalizeLabelKeyCase bool
+
+	// LabelKeyOrder if non-nil, maps metric names to the canonical order of
+	// their label keys, for example as defined by a schema registry. The label
+	// keys and values of those metrics are arranged in that order, with keys
+	// missing from a metric padded with label values whose HasValue is unset.
+	// Label keys that aren't part of the order follow in their original order.
+	LabelKeyOrder map[string][]string
+
+	// DistributionWithoutBucketsAsSummary if set, converts distribution
+	// metrics whose view has no bucket bounds, hence a single bucket that
+	// carries no histogram information, into SUMMARY metrics holding
+	// just the count and sum, for backends that prefer summaries.
+	DistributionWithoutBucketsAsSummary bool
+
+	// DistributionsAsGauge if set, converts distribution views into
+	// GAUGE_DISTRIBUTION metrics without start timestamps, for views
+	// that hold snapshots, such as current queue wait times, rather
+	// than cumulative distributions.
+	// See OpenCensusViewDataToProtoMetricsAsGauge.
+	DistributionsAsGauge bool
+
+	// ExplodeDistributions if set, follows every distribution metric by
+	// the companion gauges "<name>_sum", a GAUGE_DOUBLE of the sums of its
+	// distribution points, and "<name>_count", a GAUGE_INT64 of their
+	// counts, with the same labels, for backends that ingest distributions
+	// better as separate series.
+	ExplodeDistributions bool
+
+	// DedupeTimeSeries if set, removes the timeseries of each metric whose
+	// label values duplicate those of an earlier timeseries, keeping the
+	// first one. See DedupeTimeSeries.
+	DedupeTimeSeries bool
+
+	// ValueScale if non-nil, maps metric names to factors by which their
+	// DOUBLE point values are multiplied, for unit conversions such as
+	// from bytes to kilobytes with 0.001. INT64 points, whose scaled values
+	// would commonly not be integral, and distribution points are left as is;
+	// the descriptor's unit is left for the caller to update.
+	ValueScale map[string]float64
+}
+
+// convertDurationMetricName is the name of the metric appended
+// if MetricsConversionOptions.EmitConvertDuration is set.
+const convertDurationMetricName = "scrape_duration_seconds"
+
+// resourceTypeLabelKey is the reserved label key under which
+// the type of a flattened resource is recorded.
+const resourceTypeLabelKey = "resource.type"
+
 // OpenCensusViewDataToProtoMetrics converts OpenCensus ViewData to OpenCensus-Proto Metrics.
+// Views without a Name or Description take those of their measure.
 func OpenCensusViewDataToProtoMetrics(vdl []*view.Data) *agentmetricspb.ExportMetricsServiceRequest {
-	protoMetrics := ocViewDataToPbMetrics(vdl)
+	return OpenCensusViewDataToProtoMetricsWithOptions(vdl, nil)
+}
+
+// OpenCensusViewDataToProtoMetricsNoResource converts OpenCensus ViewData to OpenCensus-Proto Metrics
+// without ever attaching a Resource or detecting one from the environment, for agents behind proxies
+// that inject resource information. The default conversion doesn't attach a Resource either;
+// unlike it, this function guarantees that the Resource of the request and its metrics stays nil.
+func OpenCensusViewDataToProtoMetricsNoResource(vdl []*view.Data) *agentmetricspb.ExportMetricsServiceRequest {
+	req := OpenCensusViewDataToProtoMetrics(vdl)
+	if req != nil {
+		req.Resource = nil
+		for _, metric := range req.Metrics {
+			metric.Resource = nil
+		}
+	}
+	return req
+}
+
+// OpenCensusViewDataToProtoMetricsFlattenResource converts OpenCensus ViewData to OpenCensus-Proto Metrics,
+// prepending the type and labels of rs to the label keys and values of every metric and timeseries
+// instead of setting Metric.Resource. The type is recorded under the "resource.type" key, followed
+// by the labels in sorted key order. Resource keys that collide with tag keys get a numeric suffix.
+func OpenCensusViewDataToProtoMetricsFlattenResource(vdl []*view.Data, rs *resource.Resource) *agentmetricspb.ExportMetricsServiceRequest {
+	return OpenCensusViewDataToProtoMetricsWithOptions(vdl, &MetricsConversionOptions{FlattenedResource: rs})
+}
+
+// OpenCensusViewDataToProtoMetricsSorted converts OpenCensus ViewData to OpenCensus-Proto Metrics,
+// sorting the timeseries of each metric lexicographically by their joined label values
+// so that converting the same input always produces the same output.
+func OpenCensusViewDataToProtoMetricsSorted(vdl []*view.Data) *agentmetricspb.ExportMetricsServiceRequest {
+	return OpenCensusViewDataToProtoMetricsWithOptions(vdl, &MetricsConversionOptions{SortTimeSeries: true})
+}
+
+// OpenCensusViewDataToProtoMetricsAsGauge converts OpenCensus ViewData to OpenCensus-Proto Metrics,
+// marking distribution metrics as GAUGE_DISTRIBUTION instead of CUMULATIVE_DISTRIBUTION and omitting
+// the start timestamps of their timeseries, for views whose distributions are snapshots.
+func OpenCensusViewDataToProtoMetricsAsGauge(vdl []*view.Data) *agentmetricspb.ExportMetricsServiceRequest {
+	return OpenCensusViewDataToProtoMetricsWithOptions(vdl, &MetricsConversionOptions{DistributionsAsGauge: true})
+}
+
+// OpenCensusViewDataToProtoMetricsDeduped converts OpenCensus ViewData to OpenCensus-Proto Metrics,
+// removing the timeseries of each metric whose label values duplicate those of an earlier one,
+// for backends that reject duplicate timeseries. The first of the duplicates is kept.
+func OpenCensusViewDataToProtoMetricsDeduped(vdl []*view.Data) *agentmetricspb.ExportMetricsServiceRequest {
+	return OpenCensusViewDataToProtoMetricsWithOptions(vdl, &MetricsConversionOptions{DedupeTimeSeries: true})
+}
+
+// OpenCensusViewDataToProtoMetricsWithResources converts OpenCensus ViewData to OpenCensus-Proto Metrics,
+// setting resources[i] as the Resource of the metric converted from vdl[i], for metrics that originate
+// from different resources, such as scraped remote targets. A shorter resources leaves the Resource of
+// the remaining metrics unset, as does a nil entry.
+func OpenCensusViewDataToProtoMetricsWithResources(vdl []*view.Data, resources []*resource.Resource) *agentmetricspb.ExportMetricsServiceRequest {
+	return OpenCensusViewDataToProtoMetricsWithOptions(vdl, &MetricsConversionOptions{Resources: resources})
+}
+
+// OpenCensusViewDataToProtoMetricsWithOptions converts OpenCensus ViewData to OpenCensus-Proto Metrics
+// as customized by opts. A nil opts is equivalent to the zero MetricsConversionOptions.
+func OpenCensusViewDataToProtoMetricsWithOptions(vdl []*view.Data, opts *MetricsConversionOptions) *agentmetricspb.ExportMetricsServiceRequest {
+	if opts == nil {
+		opts = new(MetricsConversionOptions)
+	}
+	start := time.Now()
+	protoMetrics := ocViewDataToPbMetrics(vdl, opts)
+	if opts.EmitConvertDuration {
+		end := time.Now()
+		protoMetrics = append(protoMetrics, convertDurationMetric(end.Sub(start), end))
+	}
 	if len(protoMetrics) == 0 {
 		return nil
 	}
@@ -50,34 +240,193 @@ func OpenCensusViewDataToProtoMetrics(vdl []*view.Data) *agentmetricspb.ExportMe
 	}
 }
 
-func ocViewDataToPbMetrics(vdl []*view.Data) []*metricspb.Metric {
+// OpenCensusViewDataToProtoMetricsBatched converts OpenCensus ViewData to OpenCensus-Proto Metrics,
+// splitting them into requests carrying at most maxTimeSeriesPerBatch timeseries each.
+// Metrics are packed whole into requests and are only split when a single metric alone
+// has more than maxTimeSeriesPerBatch timeseries. A non-positive maxTimeSeriesPerBatch
+// places all metrics in a single request.
+//
+// The requests carry no Node; use OpenCensusViewDataToProtoMetricsBatchedWithNode
+// or SetNodeOnFirst to set one.
+func OpenCensusViewDataToProtoMetricsBatched(vdl []*view.Data, maxTimeSeriesPerBatch int) []*agentmetricspb.ExportMetricsServiceRequest {
+	return OpenCensusViewDataToProtoMetricsBatchedWithNode(vdl, nil, maxTimeSeriesPerBatch)
+}
+
+// OpenCensusViewDataToProtoMetricsBatchedWithNode is like OpenCensusViewDataToProtoMetricsBatched
+// but the first request carries node, given that the agent only needs the Node to be sent
+// once per stream.
+func OpenCensusViewDataToProtoMetricsBatchedWithNode(vdl []*view.Data, node *commonpb.Node, maxTimeSeriesPerBatch int) []*agentmetricspb.ExportMetricsServiceRequest {
+	protoMetrics := ocViewDataToPbMetrics(vdl, new(MetricsConversionOptions))
+	if len(protoMetrics) == 0 {
+		return nil
+	}
+	if maxTimeSeriesPerBatch <= 0 {
+		return []*agentmetricspb.ExportMetricsServiceRequest{{Node: node, Metrics: protoMetrics}}
+	}
+
+	var reqs []*agentmetricspb.ExportMetricsServiceRequest
+	var cur *agentmetricspb.ExportMetricsServiceRequest
+	var curCount int
+	flush := func() {
+		if cur != nil {
+			reqs = append(reqs, cur)
+		}
+		cur, curCount = nil, 0
+	}
+	add := func(metric *metricspb.Metric) {
+		if cur == nil {
+			cur = new(agentmetricspb.ExportMetricsServiceRequest)
+		}
+		cur.Metrics = append(cur.Metrics, metric)
+		curCount += len(metric.Timeseries)
+	}
+
+	for _, metric := range protoMetrics {
+		n := len(metric.Timeseries)
+		if n <= maxTimeSeriesPerBatch {
+			if curCount+n > maxTimeSeriesPerBatch {
+				flush()
+			}
+			add(metric)
+			continue
+		}
+
+		// This metric alone exceeds the limit, so split up its timeseries.
+		flush()
+		for start := 0; start < n; start += maxTimeSeriesPerBatch {
+			end := start + maxTimeSeriesPerBatch
+			if end > n {
+				end = n
+			}
+			add(&metricspb.Metric{
+				MetricDescriptor: metric.MetricDescriptor,
+				Timeseries:       metric.Timeseries[start:end],
+				Resource:         metric.Resource,
+			})
+			if curCount == maxTimeSeriesPerBatch {
+				flush()
+			}
+		}
+	}
+	flush()
+	reqs[0].Node = node
+
+	return reqs
+}
+
+// convertDurationMetric returns a gauge metric of d in seconds, recorded at now.
+func convertDurationMetric(d time.Duration, now time.Time) *metricspb.Metric {
+	return &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name:        convertDurationMetricName,
+			Description: "The time spent converting OpenCensus ViewData to OpenCensus-Proto Metrics",
+			Unit:        "s",
+			Type:        metricspb.MetricDescriptor_GAUGE_DOUBLE,
+		},
+		Timeseries: []*metricspb.TimeSeries{{
+			Points: []*metricspb.Point{{
+				Timestamp: TimeToProto(now),
+				Value:     &metricspb.Point_DoubleValue{DoubleValue: d.Seconds()},
+			}},
+		}},
+	}
+}
+
+func ocViewDataToPbMetrics(vdl []*view.Data, opts *MetricsConversionOptions) []*metricspb.Metric {
 	if len(vdl) == 0 {
 		return nil
 	}
 	metrics := make([]*metricspb.Metric, 0, len(vdl))
-	for _, vd := range vdl {
+	for i, vd := range vdl {
 		if vd != nil {
-			vmetric, err := viewDataToMetric(vd)
-			// TODO: (@odeke-em) somehow report this error, if it is non-nil.
+			vmetric, err := viewDataToMetric(vd, opts)
+			if err != nil {
+				logDrop(dropReasonInvalidViewData, "%v", err)
+			}
 			if err == nil && vmetric != nil {
+				if i < len(opts.Resources) && opts.Resources[i] != nil {
+					vmetric.Resource = resourceToResourcePb(opts.Resources[i])
+				}
 				metrics = append(metrics, vmetric)
+				if opts.ExplodeDistributions && isDistributionType(vmetric.MetricDescriptor.Type) {
+					metrics = append(metrics, distributionCompanionMetrics(vmetric)...)
+				}
 			}
 		}
 	}
 	return metrics
 }
 
-func viewDataToMetric(vd *view.Data) (*metricspb.Metric, error) {
+// distributionCompanionMetrics returns the "<name>_sum" and "<name>_count"
+// gauges of the distribution points of metric.
+func distributionCompanionMetrics(metric *metricspb.Metric) []*metricspb.Metric {
+	descriptor := metric.MetricDescriptor
+	sumMetric := &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name:        descriptor.Name + "_sum",
+			Description: descriptor.Description,
+			Unit:        descriptor.Unit,
+			Type:        metricspb.MetricDescriptor_GAUGE_DOUBLE,
+			LabelKeys:   descriptor.LabelKeys,
+		},
+		Resource: metric.Resource,
+	}
+	countMetric := &metricspb.Metric{
+		MetricDescriptor: &metricspb.MetricDescriptor{
+			Name:        descriptor.Name + "_count",
+			Description: descriptor.Description,
+			Unit:        "1",
+			Type:        metricspb.MetricDescriptor_GAUGE_INT64,
+			LabelKeys:   descriptor.LabelKeys,
+		},
+		Resource: metric.Resource,
+	}
+	for _, ts := range metric.Timeseries {
+		var sumPoints, countPoints []*metricspb.Point
+		for _, point := range ts.Points {
+			dv := point.GetDistributionValue()
+			if dv == nil {
+				continue
+			}
+			sumPoints = append(sumPoints, &metricspb.Point{
+				Timestamp: point.Timestamp,
+				Value:     &metricspb.Point_DoubleValue{DoubleValue: dv.Sum},
+			})
+			countPoints = append(countPoints, &metricspb.Point{
+				Timestamp: point.Timestamp,
+				Value:     &metricspb.Point_Int64Value{Int64Value: dv.Count},
+			})
+		}
+		sumMetric.Timeseries = append(sumMetric.Timeseries, &metricspb.TimeSeries{
+			LabelValues: ts.LabelValues,
+			Points:      sumPoints,
+		})
+		countMetric.Timeseries = append(countMetric.Timeseries, &metricspb.TimeSeries{
+			LabelValues: ts.LabelValues,
+			Points:      countPoints,
+		})
+	}
+	return []*metricspb.Metric{sumMetric, countMetric}
+}
+
+func viewDataToMetric(vd *view.Data, opts *MetricsConversionOptions) (*metricspb.Metric, error) {
 	if vd == nil {
 		return nil, errNilViewData
 	}
 
-	descriptor, err := viewToMetricDescriptor(vd.View)
+	tagKeys := UnionTagKeys(vd)
+	descriptor, err := viewToMetricDescriptor(vd.View, tagKeys)
 	if err != nil {
 		return nil, err
 	}
+	if opts.DistributionsAsGauge && descriptor.Type == metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION {
+		descriptor.Type = metricspb.MetricDescriptor_GAUGE_DISTRIBUTION
+	}
+	if descriptor.Unit == "" && isDistributionType(descriptor.Type) {
+		descriptor.Unit = opts.DefaultDistributionUnit
+	}
 
-	timeseries, err := viewDataToTimeseries(vd)
+	timeseries, err := viewDataToTimeseries(vd, tagKeys, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -86,10 +435,397 @@ func viewDataToMetric(vd *view.Data) (*metricspb.Metric, error) {
 		MetricDescriptor: descriptor,
 		Timeseries:       timeseries,
 	}
+	if opts.NormalizeLabelKeyCase {
+		normalizeLabelKeyCase(vd, tagKeys, metric)
+	}
+	if order, ok := opts.LabelKeyOrder[descriptor.Name]; ok {
+		orderLabelKeys(metric, order)
+	}
+	if opts.DistributionWithoutBucketsAsSummary && isDistributionType(descriptor.Type) &&
+		vd.View.Aggregation != nil && len(vd.View.Aggregation.Buckets) == 0 {
+		distributionsToSummaries(metric)
+	}
+	if opts.DedupeTimeSeries {
+		DedupeTimeSeries(metric)
+	}
+	if factor, ok := opts.ValueScale[descriptor.Name]; ok {
+		scaleDoubleValues(metric, factor)
+	}
+	if opts.CompactIntegralDoubles {
+		compactIntegralDoubles(metric)
+	}
+	if opts.NearOverflowThreshold > 0 && opts.NearOverflowHandler != nil {
+		checkNearOverflow(vd.View, metric.Timeseries, opts.NearOverflowThreshold, opts.NearOverflowHandler)
+	}
+	if opts.ClampPointsToStart && isCumulativeType(descriptor.Type) {
+		clampPointsToStart(vd.View, metric.Timeseries, opts.ClampedPointHandler)
+	}
+	if opts.FlattenedResource != nil {
+		flattenResourceIntoLabels(metric, opts.FlattenedResource)
+	}
+	if opts.SortTimeSeries {
+		sortTimeSeries(metric.Timeseries)
+	}
 	return metric, nil
 }
 
-func viewToMetricDescriptor(v *view.View) (*metricspb.MetricDescriptor, error) {
+// normalizeLabelKeyCase replaces the label keys of metric, which correspond to
+// tagKeys, by their lower case forms, realigning the label values of its
+// timeseries, which were converted from the rows of vd, and merging
+// timeseries with identical label values.
+func normalizeLabelKeyCase(vd *view.Data, tagKeys []tag.Key, metric *metricspb.Metric) {
+	var labelKeys []*metricspb.LabelKey
+	keyIndices := make(map[string]int)
+	for _, tagKey := range tagKeys {
+		key := strings.ToLower(tagKey.Name())
+		if _, ok := keyIndices[key]; !ok {
+			keyIndices[key] = len(labelKeys)
+			labelKeys = append(labelKeys, &metricspb.LabelKey{Key: key})
+		}
+	}
+	metric.MetricDescriptor.LabelKeys = labelKeys
+
+	merged := make(map[string]*metricspb.TimeSeries)
+	timeseries := metric.Timeseries[:0]
+	for i, ts := range metric.Timeseries {
+		labelValues := make([]*metricspb.LabelValue, len(labelKeys))
+		for j := range labelValues {
+			labelValues[j] = new(metricspb.LabelValue)
+		}
+		for _, t := range vd.Rows[i].Tags {
+			if j, ok := keyIndices[strings.ToLower(t.Key.Name())]; ok {
+				labelValues[j].Value, labelValues[j].HasValue = t.Value, true
+			}
+		}
+		ts.LabelValues = labelValues
+
+		key := labelValuesKey(labelValues)
+		if prev, ok := merged[key]; ok {
+			mergePoints(prev.Points, ts.Points, metric.MetricDescriptor.Type)
+			continue
+		}
+		merged[key] = ts
+		timeseries = append(timeseries, ts)
+	}
+	metric.Timeseries = timeseries
+}
+
+// orderLabelKeys arranges the label keys of metric, and accordingly the label
+// values of its timeseries, as listed in order, followed by the remaining keys.
+func orderLabelKeys(metric *metricspb.Metric, order []string) {
+	oldIndices := make(map[string]int, len(metric.MetricDescriptor.LabelKeys))
+	for i, labelKey := range metric.MetricDescriptor.LabelKeys {
+		oldIndices[labelKey.Key] = i
+	}
+
+	// indices[i] is the former index of the i-th label key, or -1 if it is new.
+	labelKeys := make([]*metricspb.LabelKey, 0, len(order)+len(oldIndices))
+	indices := make([]int, 0, cap(labelKeys))
+	ordered := make(map[string]bool, len(order))
+	for _, key := range order {
+		if ordered[key] {
+			continue
+		}
+		ordered[key] = true
+		if i, ok := oldIndices[key]; ok {
+			labelKeys = append(labelKeys, metric.MetricDescriptor.LabelKeys[i])
+			indices = append(indices, i)
+		} else {
+			labelKeys = append(labelKeys, &metricspb.LabelKey{Key: key})
+			indices = append(indices, -1)
+		}
+	}
+	for i, labelKey := range metric.MetricDescriptor.LabelKeys {
+		if !ordered[labelKey.Key] {
+			labelKeys = append(labelKeys, labelKey)
+			indices = append(indices, i)
+		}
+	}
+	metric.MetricDescriptor.LabelKeys = labelKeys
+
+	for _, ts := range metric.Timeseries {
+		labelValues := make([]*metricspb.LabelValue, 0, len(indices))
+		for _, i := range indices {
+			if i >= 0 && i < len(ts.LabelValues) {
+				labelValues = append(labelValues, ts.LabelValues[i])
+			} else {
+				labelValues = append(labelValues, &metricspb.LabelValue{})
+			}
+		}
+		ts.LabelValues = labelValues
+	}
+}
+
+// DedupeTimeSeries removes from m the timeseries whose label values are identical
+// to those of an earlier timeseries, which some backends reject, keeping the first
+// one. Label values only match if both their Value and HasValue match. Nil
+// timeseries are removed as well. It returns the number of timeseries removed.
+func DedupeTimeSeries(m *metricspb.Metric) int {
+	if m == nil {
+		return 0
+	}
+	seen := make(map[string]bool, len(m.Timeseries))
+	kept := m.Timeseries[:0]
+	for _, ts := range m.Timeseries {
+		if ts == nil {
+			continue
+		}
+		key := labelValuesKey(ts.LabelValues)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept = append(kept, ts)
+	}
+	removed := len(m.Timeseries) - len(kept)
+	m.Timeseries = kept
+	return removed
+}
+
+// labelValuesKey returns a key identifying labelValues, distinguishing
+// missing label values from empty ones.
+func labelValuesKey(labelValues []*metricspb.LabelValue) string {
+	var b strings.Builder
+	for _, lv := range labelValues {
+		if lv.GetHasValue() {
+			b.WriteString("+")
+		} else {
+			b.WriteString("-")
+		}
+		b.WriteString(lv.GetValue())
+		b.WriteString("\x00")
+	}
+	return b.String()
+}
+
+// mergePoints merges the values of src into those of dst, position by position.
+// The values of cumulative points are added up whereas gauge points take
+// the values of src.
+func mergePoints(dst, src []*metricspb.Point, mType metricspb.MetricDescriptor_Type) {
+	for i := 0; i < len(dst) && i < len(src); i++ {
+		if !isCumulativeType(mType) {
+			dst[i].Value = src[i].Value
+			continue
+		}
+		switch value := dst[i].Value.(type) {
+		case *metricspb.Point_Int64Value:
+			dst[i].Value = &metricspb.Point_Int64Value{Int64Value: addInt64(value.Int64Value, src[i].GetInt64Value())}
+		case *metricspb.Point_DoubleValue:
+			dst[i].Value = &metricspb.Point_DoubleValue{DoubleValue: value.DoubleValue + src[i].GetDoubleValue()}
+		case *metricspb.Point_DistributionValue:
+			if srcValue := src[i].GetDistributionValue(); srcValue != nil {
+				dst[i].Value = &metricspb.Point_DistributionValue{
+					DistributionValue: mergeDistributionValues(value.DistributionValue, srcValue),
+				}
+			}
+		}
+	}
+}
+
+// mergeDistributionValues returns the distribution of the union of the
+// populations of a and b, which must have the same bucket bounds.
+func mergeDistributionValues(a, b *metricspb.DistributionValue) *metricspb.DistributionValue {
+	merged := &metricspb.DistributionValue{
+		Count:         addInt64(a.Count, b.Count),
+		Sum:           a.Sum + b.Sum,
+		BucketOptions: a.BucketOptions,
+	}
+	merged.SumOfSquaredDeviation = a.SumOfSquaredDeviation + b.SumOfSquaredDeviation
+	if a.Count > 0 && b.Count > 0 {
+		// Chan et al.'s formula for combining the sums of squared deviations.
+		delta := b.Sum/float64(b.Count) - a.Sum/float64(a.Count)
+		merged.SumOfSquaredDeviation += delta * delta * float64(a.Count) * float64(b.Count) / float64(merged.Count)
+	}
+	for i, bucket := range a.Buckets {
+		count, exemplar := bucket.GetCount(), bucket.GetExemplar()
+		if i < len(b.Buckets) {
+			count = addInt64(count, b.Buckets[i].GetCount())
+			if exemplar == nil {
+				exemplar = b.Buckets[i].GetExemplar()
+			}
+		}
+		merged.Buckets = append(merged.Buckets, &metricspb.DistributionValue_Bucket{Count: count, Exemplar: exemplar})
+	}
+	return merged
+}
+
+// clampPointsToStart sets the timestamp of every point that is earlier
+// than the start timestamp of its timeseries to the start timestamp.
+// Timestamps are shared between points, hence they are replaced
+// rather than modified in place.
+func clampPointsToStart(v *view.View, timeseries []*metricspb.TimeSeries, handler func(v *view.View, pointTime, startTime time.Time)) {
+	for _, ts := range timeseries {
+		if ts.StartTimestamp == nil {
+			continue
+		}
+		start := ProtoToTime(ts.StartTimestamp)
+		for _, point := range ts.Points {
+			if point.Timestamp == nil {
+				continue
+			}
+			if pointTime := ProtoToTime(point.Timestamp); pointTime.Before(start) {
+				if handler != nil {
+					handler(v, pointTime, start)
+				}
+				point.Timestamp = ts.StartTimestamp
+			}
+		}
+	}
+}
+
+// checkNearOverflow calls handler with every INT64 point value
+// in timeseries whose magnitude is at least threshold.
+func checkNearOverflow(v *view.View, timeseries []*metricspb.TimeSeries, threshold int64, handler func(v *view.View, value int64)) {
+	for _, ts := range timeseries {
+		for _, point := range ts.Points {
+			iv, ok := point.Value.(*metricspb.Point_Int64Value)
+			if !ok {
+				continue
+			}
+			// -threshold can't overflow since threshold is positive.
+			if iv.Int64Value >= threshold || iv.Int64Value <= -threshold {
+				handler(v, iv.Int64Value)
+			}
+		}
+	}
+}
+
+func isCumulativeType(t metricspb.MetricDescriptor_Type) bool {
+	switch t {
+	case metricspb.MetricDescriptor_CUMULATIVE_INT64, metricspb.MetricDescriptor_CUMULATIVE_DOUBLE,
+		metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION:
+		return true
+	default:
+		return false
+	}
+}
+
+// compactIntegralDoubles converts the DOUBLE points of metric into INT64
+// points, if all of them hold integral values that fit in an int64.
+func compactIntegralDoubles(metric *metricspb.Metric) {
+	var compactType metricspb.MetricDescriptor_Type
+	switch metric.MetricDescriptor.Type {
+	case metricspb.MetricDescriptor_GAUGE_DOUBLE:
+		compactType = metricspb.MetricDescriptor_GAUGE_INT64
+	case metricspb.MetricDescriptor_CUMULATIVE_DOUBLE:
+		compactType = metricspb.MetricDescriptor_CUMULATIVE_INT64
+	default:
+		return
+	}
+
+	for _, ts := range metric.Timeseries {
+		for _, point := range ts.Points {
+			dv, ok := point.Value.(*metricspb.Point_DoubleValue)
+			if !ok || !isIntegral(dv.DoubleValue) {
+				return
+			}
+		}
+	}
+
+	metric.MetricDescriptor.Type = compactType
+	for _, ts := range metric.Timeseries {
+		for _, point := range ts.Points {
+			value := point.GetDoubleValue()
+			point.Value = &metricspb.Point_Int64Value{Int64Value: int64(value)}
+		}
+	}
+}
+
+// distributionsToSummaries converts the distribution points
+// of metric into summary points with the same count and sum.
+func distributionsToSummaries(metric *metricspb.Metric) {
+	metric.MetricDescriptor.Type = metricspb.MetricDescriptor_SUMMARY
+	for _, ts := range metric.Timeseries {
+		for _, point := range ts.Points {
+			dv := point.GetDistributionValue()
+			if dv == nil {
+				continue
+			}
+			point.Value = &metricspb.Point_SummaryValue{
+				SummaryValue: &metricspb.SummaryValue{
+					Count: &wrappers.Int64Value{Value: dv.Count},
+					Sum:   &wrappers.DoubleValue{Value: dv.Sum},
+				},
+			}
+		}
+	}
+}
+
+// scaleDoubleValues multiplies the DOUBLE point values of metric by factor.
+func scaleDoubleValues(metric *metricspb.Metric, factor float64) {
+	for _, ts := range metric.Timeseries {
+		for _, point := range ts.Points {
+			if dv, ok := point.Value.(*metricspb.Point_DoubleValue); ok {
+				point.Value = &metricspb.Point_DoubleValue{DoubleValue: dv.DoubleValue * factor}
+			}
+		}
+	}
+}
+
+// isIntegral reports whether f has no fractional part and
+// can be converted to an int64 without loss.
+func isIntegral(f float64) bool {
+	return f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64
+}
+
+// sortTimeSeries sorts timeseries by their label values joined
+// by the NUL character, which can't appear in tag values.
+func sortTimeSeries(timeseries []*metricspb.TimeSeries) {
+	keys := make(map[*metricspb.TimeSeries]string, len(timeseries))
+	for _, ts := range timeseries {
+		values := make([]string, 0, len(ts.LabelValues))
+		for _, lv := range ts.LabelValues {
+			values = append(values, lv.GetValue())
+		}
+		keys[ts] = strings.Join(values, "\x00")
+	}
+	sort.SliceStable(timeseries, func(i, j int) bool {
+		return keys[timeseries[i]] < keys[timeseries[j]]
+	})
+}
+
+// flattenResourceIntoLabels prepends the type and labels of rs
+// to the label keys and values of metric.
+func flattenResourceIntoLabels(metric *metricspb.Metric, rs *resource.Resource) {
+	taken := make(map[string]bool)
+	for _, labelKey := range metric.MetricDescriptor.LabelKeys {
+		taken[labelKey.Key] = true
+	}
+	uniqueKey := func(key string) string {
+		candidate := key
+		for i := 1; taken[candidate]; i++ {
+			candidate = key + "_" + strconv.Itoa(i)
+		}
+		taken[candidate] = true
+		return candidate
+	}
+
+	resourceKeys := make([]string, 0, len(rs.Labels))
+	for key := range rs.Labels {
+		resourceKeys = append(resourceKeys, key)
+	}
+	sort.Strings(resourceKeys)
+
+	labelKeys := make([]*metricspb.LabelKey, 0, len(resourceKeys)+1+len(metric.MetricDescriptor.LabelKeys))
+	labelValues := make([]*metricspb.LabelValue, 0, len(resourceKeys)+1)
+	labelKeys = append(labelKeys, &metricspb.LabelKey{Key: uniqueKey(resourceTypeLabelKey)})
+	labelValues = append(labelValues, &metricspb.LabelValue{Value: rs.Type, HasValue: true})
+	for _, key := range resourceKeys {
+		labelKeys = append(labelKeys, &metricspb.LabelKey{Key: uniqueKey(key)})
+		labelValues = append(labelValues, &metricspb.LabelValue{Value: rs.Labels[key], HasValue: true})
+	}
+	metric.MetricDescriptor.LabelKeys = append(labelKeys, metric.MetricDescriptor.LabelKeys...)
+
+	for _, ts := range metric.Timeseries {
+		values := make([]*metricspb.LabelValue, 0, len(labelValues)+len(ts.LabelValues))
+		values = append(values, labelValues...)
+		ts.LabelValues = append(values, ts.LabelValues...)
+	}
+}
+
+// viewToMetricDescriptor returns the descriptor of the metric converted from
+// the data of v, whose label keys are tagKeys.
+func viewToMetricDescriptor(v *view.View, tagKeys []tag.Key) (*metricspb.MetricDescriptor, error) {
 	if v == nil {
 		return nil, errNilView
 	}
@@ -102,7 +838,7 @@ func viewToMetricDescriptor(v *view.View) (*metricspb.MetricDescriptor, error) {
 		Description: stringOrCall(v.Description, v.Measure.Description),
 		Unit:        v.Measure.Unit(),
 		Type:        aggregationToMetricDescriptorType(v),
-		LabelKeys:   tagKeysToLabelKeys(v.TagKeys),
+		LabelKeys:   tagKeysToLabelKeys(tagKeys),
 	}
 	return desc, nil
 }
@@ -173,6 +909,10 @@ func aggregationToMetricDescriptorType(v *view.View) metricspb.MetricDescriptor_
 	return metricspb.MetricDescriptor_UNSPECIFIED
 }
 
+func isDistributionType(t metricspb.MetricDescriptor_Type) bool {
+	return t == metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION || t == metricspb.MetricDescriptor_GAUGE_DISTRIBUTION
+}
+
 func tagKeysToLabelKeys(tagKeys []tag.Key) []*metricspb.LabelKey {
 	labelKeys := make([]*metricspb.LabelKey, 0, len(tagKeys))
 	for _, tagKey := range tagKeys {
@@ -183,7 +923,7 @@ func tagKeysToLabelKeys(tagKeys []tag.Key) []*metricspb.LabelKey {
 	return labelKeys
 }
 
-func viewDataToTimeseries(vd *view.Data) ([]*metricspb.TimeSeries, error) {
+func viewDataToTimeseries(vd *view.Data, tagKeys []tag.Key, opts *MetricsConversionOptions) ([]*metricspb.TimeSeries, error) {
 	if vd == nil || len(vd.Rows) == 0 {
 		return nil, nil
 	}
@@ -192,16 +932,22 @@ func viewDataToTimeseries(vd *view.Data) ([]*metricspb.TimeSeries, error) {
 	// the timestamps for all the row data will be the exact same
 	// per aggregation. However, the values will differ.
 	// Each row has its own tags.
-	startTimestamp := timeToProtoTimestamp(vd.Start)
-	endTimestamp := timeToProtoTimestamp(vd.End)
+	startTimestamp := TimeToProto(vd.Start)
+	if agg := vd.View.Aggregation; agg != nil &&
+		(agg.Type == view.AggTypeLastValue || agg.Type == view.AggTypeDistribution && opts.DistributionsAsGauge) {
+		// Gauges are instantaneous readings hence have no start time.
+		startTimestamp = nil
+	}
+	endTimestamp := TimeToProto(vd.End)
 
 	mType := measureTypeFromMeasure(vd.View.Measure)
 	timeseries := make([]*metricspb.TimeSeries, 0, len(vd.Rows))
 	// It is imperative that the ordering of "LabelValues" matches those
-	// of the Label keys in the metric descriptor.
+	// of the Label keys in the metric descriptor, hence they are aligned
+	// with tagKeys, from which the descriptor's label keys derive.
 	for _, row := range vd.Rows {
-		labelValues := labelValuesFromTags(row.Tags)
-		point := rowToPoint(vd.View, row, endTimestamp, mType)
+		labelValues := LabelValuesFromTags(row.Tags, tagKeys)
+		point := rowToPoint(vd.View, row, endTimestamp, mType, opts)
 		timeseries = append(timeseries, &metricspb.TimeSeries{
 			StartTimestamp: startTimestamp,
 			LabelValues:    labelValues,
@@ -216,15 +962,7 @@ func viewDataToTimeseries(vd *view.Data) ([]*metricspb.TimeSeries, error) {
 	return timeseries, nil
 }
 
-func timeToProtoTimestamp(t time.Time) *timestamp.Timestamp {
-	unixNano := t.UnixNano()
-	return &timestamp.Timestamp{
-		Seconds: int64(unixNano / 1e9),
-		Nanos:   int32(unixNano % 1e9),
-	}
-}
-
-func rowToPoint(v *view.View, row *view.Row, endTimestamp *timestamp.Timestamp, mType measureType) *metricspb.Point {
+func rowToPoint(v *view.View, row *view.Row, endTimestamp *timestamp.Timestamp, mType measureType, opts *MetricsConversionOptions) *metricspb.Point {
 	pt := &metricspb.Point{
 		Timestamp: endTimestamp,
 	}
@@ -234,12 +972,24 @@ func rowToPoint(v *view.View, row *view.Row, endTimestamp *timestamp.Timestamp,
 		pt.Value = &metricspb.Point_Int64Value{Int64Value: data.Value}
 
 	case *view.DistributionData:
+		countPerBucket := data.CountPerBucket
+		if want := len(v.Aggregation.Buckets) + 1; len(countPerBucket) != want {
+			if opts.BucketMismatchHandler != nil {
+				opts.BucketMismatchHandler(v, len(countPerBucket), want)
+			}
+			countPerBucket = reconcileBucketCounts(countPerBucket, want)
+		}
+		buckets := bucketsToProtoBuckets(countPerBucket)
+		for i, exemplar := range data.ExemplarsPerBucket {
+			if i < len(buckets) && exemplar != nil {
+				buckets[i].Exemplar = exemplarToProtoExemplar(exemplar)
+			}
+		}
 		pt.Value = &metricspb.Point_DistributionValue{
 			DistributionValue: &metricspb.DistributionValue{
-				Count: data.Count,
-				Sum:   float64(data.Count) * data.Mean, // because Mean := Sum/Count
-				// TODO: Add Exemplar
-				Buckets: bucketsToProtoBuckets(data.CountPerBucket),
+				Count:   data.Count,
+				Sum:     float64(data.Count) * data.Mean, // because Mean := Sum/Count
+				Buckets: buckets,
 				BucketOptions: &metricspb.DistributionValue_BucketOptions{
 					Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
 						Explicit: &metricspb.DistributionValue_BucketOptions_Explicit{
@@ -264,12 +1014,73 @@ func rowToPoint(v *view.View, row *view.Row, endTimestamp *timestamp.Timestamp,
 // interface hence we just have to set its value by pointer.
 func setPointValue(pt *metricspb.Point, value float64, mType measureType) {
 	if mType == measureInt64 {
-		pt.Value = &metricspb.Point_Int64Value{Int64Value: int64(value)}
+		pt.Value = &metricspb.Point_Int64Value{Int64Value: float64ToInt64(value)}
 	} else {
 		pt.Value = &metricspb.Point_DoubleValue{DoubleValue: value}
 	}
 }
 
+// addInt64 returns a+b, saturating at the int64 bounds instead of overflowing.
+func addInt64(a, b int64) int64 {
+	sum := a + b
+	switch {
+	case a > 0 && b > 0 && sum < 0:
+		return math.MaxInt64
+	case a < 0 && b < 0 && sum >= 0:
+		return math.MinInt64
+	default:
+		return sum
+	}
+}
+
+// float64ToInt64 converts f to an int64, saturating at the int64 bounds
+// instead of overflowing. NaN converts to zero.
+func float64ToInt64(f float64) int64 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f >= math.MaxInt64:
+		return math.MaxInt64
+	case f <= math.MinInt64:
+		return math.MinInt64
+	default:
+		return int64(f)
+	}
+}
+
+// reconcileBucketCounts resizes countPerBucket to n buckets, preferring the
+// bounds of the view over those implied by the data. Missing buckets are
+// padded with zero counts while the counts of excess buckets are folded into
+// the last bucket, so that the sum of the bucket counts still matches Count.
+func reconcileBucketCounts(countPerBucket []int64, n int) []int64 {
+	reconciled := make([]int64, n)
+	copy(reconciled, countPerBucket)
+	for i := n; i < len(countPerBucket); i++ {
+		reconciled[n-1] += countPerBucket[i]
+	}
+	return reconciled
+}
+
+// exemplarToProtoExemplar converts exemplar, formatting attachment values
+// that aren't strings, such as SpanContexts, with fmt.Sprint.
+func exemplarToProtoExemplar(exemplar *metricdata.Exemplar) *metricspb.DistributionValue_Exemplar {
+	pe := &metricspb.DistributionValue_Exemplar{
+		Value:     exemplar.Value,
+		Timestamp: TimeToProto(exemplar.Timestamp),
+	}
+	if exemplar.Attachments != nil {
+		pe.Attachments = make(map[string]string, len(exemplar.Attachments))
+		for key, value := range exemplar.Attachments {
+			if s, ok := value.(string); ok {
+				pe.Attachments[key] = s
+			} else {
+				pe.Attachments[key] = fmt.Sprint(value)
+			}
+		}
+	}
+	return pe
+}
+
 func bucketsToProtoBuckets(countPerBucket []int64) []*metricspb.DistributionValue_Bucket {
 	distBuckets := make([]*metricspb.DistributionValue_Bucket, len(countPerBucket))
 	for i := 0; i < len(countPerBucket); i++ {
@@ -283,25 +1094,59 @@ func bucketsToProtoBuckets(countPerBucket []int64) []*metricspb.DistributionValu
 	return distBuckets
 }
 
-func labelValuesFromTags(tags []tag.Tag) []*metricspb.LabelValue {
-	if len(tags) == 0 {
+// UnionTagKeys returns the union of the tag keys of the view of vd and those
+// of the tags of its rows, sorted by name, such that a descriptor whose label
+// keys derive from it covers every row, even when rows have differing tags.
+func UnionTagKeys(vd *view.Data) []tag.Key {
+	if vd == nil {
 		return nil
 	}
+	seen := make(map[tag.Key]bool)
+	var keys []tag.Key
+	add := func(key tag.Key) {
+		// The zero Key marks a missing tag.
+		if key.Name() != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if vd.View != nil {
+		for _, key := range vd.View.TagKeys {
+			add(key)
+		}
+	}
+	for _, row := range vd.Rows {
+		if row == nil {
+			continue
+		}
+		for _, t := range row.Tags {
+			add(t.Key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name() < keys[j].Name()
+	})
+	return keys
+}
 
-	labelValues := make([]*metricspb.LabelValue, 0, len(tags))
-	for _, tag_ := range tags {
+// LabelValuesFromTags returns one LabelValue per key in keys, in the same order,
+// for example aligned with the LabelKeys of a MetricDescriptor. Keys present in
+// tags get their tag value and HasValue set, while absent keys get HasValue unset.
+// If a key appears more than once in tags, its last value is used.
+func LabelValuesFromTags(tags []tag.Tag, keys []tag.Key) []*metricspb.LabelValue {
+	if len(keys) == 0 {
+		return nil
+	}
+	values := make(map[tag.Key]string, len(tags))
+	for _, t := range tags {
+		values[t.Key] = t.Value
+	}
+	labelValues := make([]*metricspb.LabelValue, 0, len(keys))
+	for _, key := range keys {
+		value, ok := values[key]
 		labelValues = append(labelValues, &metricspb.LabelValue{
-			Value: tag_.Value,
-
-			// It is imperative that we set the "HasValue" attribute,
-			// in order to distinguish missing a label from the empty string.
-			// https://godoc.org/github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1#LabelValue.HasValue
-			//
-			// OpenCensus-Go uses non-pointers for tags as seen by this function's arguments,
-			// so the best case that we can use to distinguish missing labels/tags from the
-			// empty string is by checking if the Tag.Key.Name() != "" to indicate that we have
-			// a value.
-			HasValue: tag_.Key.Name() != "",
+			Value:    value,
+			HasValue: ok,
 		})
 	}
 	return labelValues
@@ -16,15 +16,23 @@ package ocagent
 
 import (
 	"encoding/json"
+	"fmt"
+	"math"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
+	"go.opencensus.io/metric/metricdata"
+	"go.opencensus.io/resource"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/stats/view"
 	"go.opencensus.io/tag"
+	"go.opencensus.io/trace"
 
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
 	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+	resourcepb "github.com/orijtech/ocagent_structs_no_grpc/pb/resource/v1"
 
 	"github.com/golang/protobuf/ptypes/timestamp"
 )
@@ -75,7 +83,7 @@ func TestViewDataToMetrics_Distribution(t *testing.T) {
 							Min:             11.9,
 							Max:             11.9,
 							Mean:            11.9,
-							CountPerBucket:  []int64{0, 1, 0, 0, 0},
+							CountPerBucket:  []int64{0, 1, 0, 0, 0, 0},
 							SumOfSquaredDev: 0,
 						},
 					},
@@ -90,7 +98,7 @@ func TestViewDataToMetrics_Distribution(t *testing.T) {
 							Min:             20.2,
 							Max:             20.2,
 							Mean:            20.2,
-							CountPerBucket:  []int64{0, 0, 1, 0, 0},
+							CountPerBucket:  []int64{0, 0, 1, 0, 0, 0},
 							SumOfSquaredDev: 0,
 						},
 					},
@@ -105,7 +113,7 @@ func TestViewDataToMetrics_Distribution(t *testing.T) {
 							Min:             28.9,
 							Max:             28.9,
 							Mean:            28.9,
-							CountPerBucket:  []int64{0, 0, 1, 0, 0},
+							CountPerBucket:  []int64{0, 0, 1, 0, 0, 0},
 							SumOfSquaredDev: 0,
 						},
 					},
@@ -144,7 +152,7 @@ func TestViewDataToMetrics_Distribution(t *testing.T) {
 										Sum:                   11.9,
 										SumOfSquaredDeviation: 0,
 										Buckets: []*metricspb.DistributionValue_Bucket{
-											{}, {Count: 1}, {}, {}, {},
+											{}, {Count: 1}, {}, {}, {}, {},
 										},
 										BucketOptions: &metricspb.DistributionValue_BucketOptions{
 											Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
@@ -179,7 +187,7 @@ func TestViewDataToMetrics_Distribution(t *testing.T) {
 										Sum:                   20.2,
 										SumOfSquaredDeviation: 0,
 										Buckets: []*metricspb.DistributionValue_Bucket{
-											{}, {}, {Count: 1}, {}, {},
+											{}, {}, {Count: 1}, {}, {}, {},
 										},
 										BucketOptions: &metricspb.DistributionValue_BucketOptions{
 											Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
@@ -214,7 +222,7 @@ func TestViewDataToMetrics_Distribution(t *testing.T) {
 										Sum:                   28.9,
 										SumOfSquaredDeviation: 0,
 										Buckets: []*metricspb.DistributionValue_Bucket{
-											{}, {}, {Count: 1}, {}, {},
+											{}, {}, {Count: 1}, {}, {}, {},
 										},
 										BucketOptions: &metricspb.DistributionValue_BucketOptions{
 											Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
@@ -256,7 +264,7 @@ func TestViewDataToMetrics_Distribution(t *testing.T) {
 							Min:             26,
 							Max:             26,
 							Mean:            26,
-							CountPerBucket:  []int64{0, 0, 0, 1, 0},
+							CountPerBucket:  []int64{0, 0, 0, 1, 0, 0},
 							SumOfSquaredDev: 0,
 						},
 					},
@@ -272,7 +280,7 @@ func TestViewDataToMetrics_Distribution(t *testing.T) {
 							Min:             3,
 							Max:             3,
 							Mean:            3,
-							CountPerBucket:  []int64{1, 0, 0, 0, 0},
+							CountPerBucket:  []int64{1, 0, 0, 0, 0, 0},
 							SumOfSquaredDev: 0,
 						},
 					},
@@ -313,7 +321,7 @@ func TestViewDataToMetrics_Distribution(t *testing.T) {
 										Sum:                   26,
 										SumOfSquaredDeviation: 0,
 										Buckets: []*metricspb.DistributionValue_Bucket{
-											{}, {}, {}, {Count: 1}, {},
+											{}, {}, {}, {Count: 1}, {}, {},
 										},
 										BucketOptions: &metricspb.DistributionValue_BucketOptions{
 											Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
@@ -349,7 +357,7 @@ func TestViewDataToMetrics_Distribution(t *testing.T) {
 										Sum:                   3,
 										SumOfSquaredDeviation: 0,
 										Buckets: []*metricspb.DistributionValue_Bucket{
-											{Count: 1}, {}, {}, {}, {},
+											{Count: 1}, {}, {}, {}, {}, {},
 										},
 										BucketOptions: &metricspb.DistributionValue_BucketOptions{
 											Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
@@ -421,10 +429,6 @@ func TestViewDataToMetrics_LastValue(t *testing.T) {
 				},
 				Timeseries: []*metricspb.TimeSeries{
 					{
-						StartTimestamp: &timestamp.Timestamp{
-							Seconds: 1543160298,
-							Nanos:   997,
-						},
 						LabelValues: []*metricspb.LabelValue{
 							{Value: "main-field", HasValue: true},
 							{Value: "sprinter-#10", HasValue: true},
@@ -440,10 +444,6 @@ func TestViewDataToMetrics_LastValue(t *testing.T) {
 						},
 					},
 					{
-						StartTimestamp: &timestamp.Timestamp{
-							Seconds: 1543160298,
-							Nanos:   997,
-						},
 						LabelValues: []*metricspb.LabelValue{
 							{Value: "small-field", HasValue: true},
 							{Value: "sprints", HasValue: true},
@@ -486,7 +486,7 @@ func TestViewDataToMetrics_LastValue(t *testing.T) {
 							Min:             11.9,
 							Max:             11.9,
 							Mean:            11.9,
-							CountPerBucket:  []int64{0, 1, 0, 0, 0},
+							CountPerBucket:  []int64{0, 1, 0, 0, 0, 0},
 							SumOfSquaredDev: 0,
 						},
 					},
@@ -501,7 +501,7 @@ func TestViewDataToMetrics_LastValue(t *testing.T) {
 							Min:             20.2,
 							Max:             20.2,
 							Mean:            20.2,
-							CountPerBucket:  []int64{0, 0, 1, 0, 0},
+							CountPerBucket:  []int64{0, 0, 1, 0, 0, 0},
 							SumOfSquaredDev: 0,
 						},
 					},
@@ -516,7 +516,7 @@ func TestViewDataToMetrics_LastValue(t *testing.T) {
 							Min:             28.9,
 							Max:             28.9,
 							Mean:            28.9,
-							CountPerBucket:  []int64{0, 0, 1, 0, 0},
+							CountPerBucket:  []int64{0, 0, 1, 0, 0, 0},
 							SumOfSquaredDev: 0,
 						},
 					},
@@ -555,7 +555,7 @@ func TestViewDataToMetrics_LastValue(t *testing.T) {
 										Sum:                   11.9,
 										SumOfSquaredDeviation: 0,
 										Buckets: []*metricspb.DistributionValue_Bucket{
-											{}, {Count: 1}, {}, {}, {},
+											{}, {Count: 1}, {}, {}, {}, {},
 										},
 										BucketOptions: &metricspb.DistributionValue_BucketOptions{
 											Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
@@ -590,7 +590,7 @@ func TestViewDataToMetrics_LastValue(t *testing.T) {
 										Sum:                   20.2,
 										SumOfSquaredDeviation: 0,
 										Buckets: []*metricspb.DistributionValue_Bucket{
-											{}, {}, {Count: 1}, {}, {},
+											{}, {}, {Count: 1}, {}, {}, {},
 										},
 										BucketOptions: &metricspb.DistributionValue_BucketOptions{
 											Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
@@ -625,7 +625,7 @@ func TestViewDataToMetrics_LastValue(t *testing.T) {
 										Sum:                   28.9,
 										SumOfSquaredDeviation: 0,
 										Buckets: []*metricspb.DistributionValue_Bucket{
-											{}, {}, {Count: 1}, {}, {},
+											{}, {}, {Count: 1}, {}, {}, {},
 										},
 										BucketOptions: &metricspb.DistributionValue_BucketOptions{
 											Type: &metricspb.DistributionValue_BucketOptions_Explicit_{
@@ -758,7 +758,7 @@ func TestViewDataToMetrics_Count(t *testing.T) {
 						Tags: []tag.Tag{
 							{Key: keyField, Value: "main-field"},
 							{Key: keyName, Value: "sprinter-#10"},
-							{Key: keyName, Value: "player_1"},
+							{Key: keyPlayerName, Value: "player_1"},
 						},
 						Data: &view.CountData{Value: 3},
 					},
@@ -766,7 +766,7 @@ func TestViewDataToMetrics_Count(t *testing.T) {
 						Tags: []tag.Tag{
 							{Key: keyField, Value: "small-field"},
 							{Key: keyName, Value: "sprints"},
-							{Key: keyName, Value: "player_2"},
+							{Key: keyPlayerName, Value: "player_2"},
 						},
 						Data: &view.CountData{Value: 1},
 					},
@@ -944,7 +944,7 @@ func TestViewDataToMetrics_Sum(t *testing.T) {
 						Tags: []tag.Tag{
 							{Key: keyField, Value: "main-field"},
 							{Key: keyName, Value: "sprinter-#10"},
-							{Key: keyName, Value: "player_1"},
+							{Key: keyPlayerName, Value: "player_1"},
 						},
 						Data: &view.SumData{Value: 3},
 					},
@@ -952,7 +952,7 @@ func TestViewDataToMetrics_Sum(t *testing.T) {
 						Tags: []tag.Tag{
 							{Key: keyField, Value: "small-field"},
 							{Key: keyName, Value: "sprints"},
-							{Key: keyName, Value: "player_2"},
+							{Key: keyPlayerName, Value: "player_2"},
 						},
 						Data: &view.SumData{Value: 1},
 					},
@@ -1126,10 +1126,12 @@ func TestViewDataToMetrics_MissingVsEmptyLabelValues(t *testing.T) {
 							Seconds: 1543160298,
 							Nanos:   997,
 						},
+						// Label values are aligned with the label keys
+						// regardless of the order of the tags.
 						LabelValues: []*metricspb.LabelValue{
-							{Value: "", HasValue: false},
-							{Value: "player_1", HasValue: true},
 							{Value: "", HasValue: true},
+							{Value: "player_1", HasValue: true},
+							{Value: "", HasValue: false},
 						},
 						Points: []*metricspb.Point{
 							{
@@ -1152,7 +1154,7 @@ func TestViewDataToMetrics_MissingVsEmptyLabelValues(t *testing.T) {
 
 func testViewDataToMetrics(t *testing.T, tests []*test) {
 	for i, tt := range tests {
-		got, err := viewDataToMetric(tt.in)
+		got, err := viewDataToMetric(tt.in, new(MetricsConversionOptions))
 		if tt.wantErr != "" {
 			continue
 		}
@@ -1181,3 +1183,1310 @@ func serializeAsJSON(v interface{}) string {
 	blob, _ := json.MarshalIndent(v, "", "  ")
 	return string(blob)
 }
+
+func TestViewDataToMetrics_ViewsSharingMeasure(t *testing.T) {
+	startTime := time.Date(2018, 11, 25, 15, 38, 18, 997, time.UTC)
+	endTime := startTime.Add(100 * time.Millisecond)
+
+	vds := []*view.Data{
+		{
+			Start: startTime,
+			End:   endTime,
+			View: &view.View{
+				Name:        "ocagent.io/latency_distribution",
+				Aggregation: view.Distribution(0, 10, 20),
+				Measure:     mSprinterLatencyMs,
+			},
+			Rows: []*view.Row{
+				{Data: &view.DistributionData{Count: 1, Mean: 11.9, CountPerBucket: []int64{0, 1, 0}}},
+			},
+		},
+		{
+			Start: startTime,
+			End:   endTime,
+			View: &view.View{
+				Name:        "ocagent.io/latency_count",
+				Aggregation: view.Count(),
+				Measure:     mSprinterLatencyMs,
+			},
+			Rows: []*view.Row{
+				{Data: &view.CountData{Value: 1}},
+			},
+		},
+	}
+
+	req := OpenCensusViewDataToProtoMetrics(vds)
+	if req == nil {
+		t.Fatal("Expected a non-nil request")
+	}
+	if g, w := len(req.Metrics), 2; g != w {
+		t.Fatalf("Number of metrics: got %d want %d", g, w)
+	}
+
+	wants := []struct {
+		name  string
+		mType metricspb.MetricDescriptor_Type
+	}{
+		{"ocagent.io/latency_distribution", metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION},
+		{"ocagent.io/latency_count", metricspb.MetricDescriptor_CUMULATIVE_INT64},
+	}
+	for i, want := range wants {
+		desc := req.Metrics[i].MetricDescriptor
+		if desc.Name != want.name {
+			t.Errorf("#%d: Name: got %q want %q", i, desc.Name, want.name)
+		}
+		if desc.Type != want.mType {
+			t.Errorf("#%d: Type: got %v want %v", i, desc.Type, want.mType)
+		}
+	}
+}
+
+func TestOpenCensusViewDataToProtoMetricsBatched(t *testing.T) {
+	startTime := time.Date(2018, 11, 25, 15, 38, 18, 997, time.UTC)
+	endTime := startTime.Add(100 * time.Millisecond)
+
+	viewDataWithRows := func(name string, nRows int) *view.Data {
+		vd := &view.Data{
+			Start: startTime,
+			End:   endTime,
+			View: &view.View{
+				Name:        name,
+				Aggregation: view.Count(),
+				TagKeys:     []tag.Key{keyName},
+				Measure:     mFouls,
+			},
+		}
+		for i := 0; i < nRows; i++ {
+			vd.Rows = append(vd.Rows, &view.Row{
+				Tags: []tag.Tag{{Key: keyName, Value: fmt.Sprintf("player_%d", i)}},
+				Data: &view.CountData{Value: int64(i)},
+			})
+		}
+		return vd
+	}
+
+	tests := []struct {
+		name     string
+		in       []*view.Data
+		max      int
+		wantReqs [][]int // The number of timeseries per metric in each request.
+	}{
+		{
+			name:     "single large metric",
+			in:       []*view.Data{viewDataWithRows("a", 7)},
+			max:      3,
+			wantReqs: [][]int{{3}, {3}, {1}},
+		},
+		{
+			name:     "small metrics packed together",
+			in:       []*view.Data{viewDataWithRows("a", 1), viewDataWithRows("b", 2), viewDataWithRows("c", 2), viewDataWithRows("d", 1)},
+			max:      3,
+			wantReqs: [][]int{{1, 2}, {2, 1}},
+		},
+		{
+			name:     "no limit",
+			in:       []*view.Data{viewDataWithRows("a", 4), viewDataWithRows("b", 4)},
+			max:      0,
+			wantReqs: [][]int{{4, 4}},
+		},
+	}
+
+	for _, tt := range tests {
+		node := &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}}
+		reqs := OpenCensusViewDataToProtoMetricsBatchedWithNode(tt.in, node, tt.max)
+		var got [][]int
+		for i, req := range reqs {
+			if i == 0 && req.Node != node {
+				t.Errorf("%s: expected the first batch to carry the Node, got %v", tt.name, req.Node)
+			}
+			if i > 0 && req.Node != nil {
+				t.Errorf("%s: expected batch #%d to have a nil Node, got %v", tt.name, i, req.Node)
+			}
+			var counts []int
+			for _, metric := range req.Metrics {
+				counts = append(counts, len(metric.Timeseries))
+			}
+			got = append(got, counts)
+		}
+		if !reflect.DeepEqual(got, tt.wantReqs) {
+			t.Errorf("%s: got batches %v want %v", tt.name, got, tt.wantReqs)
+		}
+
+		for i, req := range OpenCensusViewDataToProtoMetricsBatched(tt.in, tt.max) {
+			if req.Node != nil {
+				t.Errorf("%s: expected batch #%d without a node to have a nil Node, got %v", tt.name, i, req.Node)
+			}
+		}
+	}
+}
+
+func TestViewDataToMetrics_DefaultDistributionUnit(t *testing.T) {
+	mUnitless := stats.Float64("unitless_latency", "A latency measure without a unit", "")
+	vds := []*view.Data{
+		{
+			View: &view.View{
+				Name:        "ocagent.io/unitless_latency",
+				Aggregation: view.Distribution(0, 10, 20),
+				Measure:     mUnitless,
+			},
+		},
+		{
+			View: &view.View{
+				Name:        "ocagent.io/unitless_sum",
+				Aggregation: view.Sum(),
+				Measure:     mUnitless,
+			},
+		},
+		{
+			View: &view.View{
+				Name:        "ocagent.io/latency",
+				Aggregation: view.Distribution(0, 10, 20),
+				Measure:     mSprinterLatencyMs,
+			},
+		},
+	}
+
+	req := OpenCensusViewDataToProtoMetricsWithOptions(vds, &MetricsConversionOptions{DefaultDistributionUnit: "ms"})
+	wantUnits := []string{"ms", "", "ms"}
+	for i, metric := range req.Metrics {
+		if g, w := metric.MetricDescriptor.Unit, wantUnits[i]; g != w {
+			t.Errorf("#%d: Unit: got %q want %q", i, g, w)
+		}
+	}
+
+	req = OpenCensusViewDataToProtoMetrics(vds)
+	if g := req.Metrics[0].MetricDescriptor.Unit; g != "" {
+		t.Errorf("Expected no default unit, got %q", g)
+	}
+}
+
+func TestOpenCensusViewDataToProtoMetricsFlattenResource(t *testing.T) {
+	keyZone, _ := tag.NewKey("zone")
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/fouls",
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{keyName, keyZone},
+			Measure:     mFouls,
+		},
+		Rows: []*view.Row{
+			{
+				Tags: []tag.Tag{{Key: keyName, Value: "player_1"}, {Key: keyZone, Value: "us-east1-b"}},
+				Data: &view.CountData{Value: 1},
+			},
+			{
+				Tags: []tag.Tag{{Key: keyName, Value: "player_2"}, {Key: keyZone, Value: "us-east1-c"}},
+				Data: &view.CountData{Value: 2},
+			},
+		},
+	}
+	rs := &resource.Resource{
+		Type:   "k8s",
+		Labels: map[string]string{"zone": "us-east1", "cluster": "c1"},
+	}
+
+	req := OpenCensusViewDataToProtoMetricsFlattenResource([]*view.Data{vd}, rs)
+	metric := req.Metrics[0]
+	if metric.Resource != nil {
+		t.Errorf("Expected no Metric.Resource, got %v", metric.Resource)
+	}
+
+	var gotKeys []string
+	for _, labelKey := range metric.MetricDescriptor.LabelKeys {
+		gotKeys = append(gotKeys, labelKey.Key)
+	}
+	wantKeys := []string{"resource.type", "cluster", "zone_1", "name", "zone"}
+	if !reflect.DeepEqual(gotKeys, wantKeys) {
+		t.Errorf("LabelKeys: got %v want %v", gotKeys, wantKeys)
+	}
+
+	wantValues := [][]string{
+		{"k8s", "c1", "us-east1", "player_1", "us-east1-b"},
+		{"k8s", "c1", "us-east1", "player_2", "us-east1-c"},
+	}
+	for i, ts := range metric.Timeseries {
+		var gotValues []string
+		for _, labelValue := range ts.LabelValues {
+			gotValues = append(gotValues, labelValue.Value)
+		}
+		if !reflect.DeepEqual(gotValues, wantValues[i]) {
+			t.Errorf("#%d: LabelValues: got %v want %v", i, gotValues, wantValues[i])
+		}
+	}
+}
+
+func TestViewDataToMetrics_SumOfSquaredDeviation(t *testing.T) {
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/latency",
+			Aggregation: view.Distribution(0, 10, 20),
+			Measure:     mSprinterLatencyMs,
+		},
+		Rows: []*view.Row{
+			{
+				Data: &view.DistributionData{
+					// Points: [5, 14, 17], deviating from the mean by -7, 2 and 5.
+					Count:           3,
+					Min:             5,
+					Max:             17,
+					Mean:            12,
+					CountPerBucket:  []int64{0, 1, 2, 0},
+					SumOfSquaredDev: 78,
+				},
+			},
+		},
+	}
+
+	metric, err := viewDataToMetric(vd, new(MetricsConversionOptions))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	dv := metric.Timeseries[0].Points[0].GetDistributionValue()
+	if g, w := dv.SumOfSquaredDeviation, 78.0; g != w {
+		t.Errorf("SumOfSquaredDeviation: got %v want %v", g, w)
+	}
+	if g, w := dv.Count, int64(3); g != w {
+		t.Errorf("Count: got %d want %d", g, w)
+	}
+	if g, w := dv.Sum, 36.0; g != w {
+		t.Errorf("Sum: got %v want %v", g, w)
+	}
+	var bucketTotal int64
+	for _, bucket := range dv.Buckets {
+		bucketTotal += bucket.Count
+	}
+	if bucketTotal != dv.Count {
+		t.Errorf("Bucket counts add up to %d, yet Count is %d", bucketTotal, dv.Count)
+	}
+}
+
+func TestOpenCensusViewDataToProtoMetricsSorted(t *testing.T) {
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/fouls",
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{keyField, keyName},
+			Measure:     mFouls,
+		},
+		Rows: []*view.Row{
+			{Tags: []tag.Tag{{Key: keyField, Value: "small-field"}, {Key: keyName, Value: "b"}}, Data: &view.CountData{Value: 1}},
+			{Tags: []tag.Tag{{Key: keyField, Value: "main-field"}, {Key: keyName, Value: "z"}}, Data: &view.CountData{Value: 2}},
+			{Tags: []tag.Tag{{Key: keyField, Value: "small-field"}, {Key: keyName, Value: "a"}}, Data: &view.CountData{Value: 3}},
+		},
+	}
+
+	order := func() []string {
+		req := OpenCensusViewDataToProtoMetricsSorted([]*view.Data{vd})
+		var got []string
+		for _, ts := range req.Metrics[0].Timeseries {
+			got = append(got, ts.LabelValues[0].Value+"/"+ts.LabelValues[1].Value)
+		}
+		return got
+	}
+
+	want := []string{"main-field/z", "small-field/a", "small-field/b"}
+	first := order()
+	if !reflect.DeepEqual(first, want) {
+		t.Errorf("Timeseries order: got %v want %v", first, want)
+	}
+
+	// Shuffle the rows, the output must remain the same.
+	vd.Rows[0], vd.Rows[2] = vd.Rows[2], vd.Rows[0]
+	if second := order(); !reflect.DeepEqual(first, second) {
+		t.Errorf("Conversions differ in order: %v vs %v", first, second)
+	}
+}
+
+func TestViewDataToMetrics_MismatchedBuckets(t *testing.T) {
+	v := &view.View{
+		Name:        "ocagent.io/latency",
+		Aggregation: view.Distribution(0, 10, 20),
+		Measure:     mSprinterLatencyMs,
+	}
+
+	tests := []struct {
+		name           string
+		countPerBucket []int64
+		want           []int64
+	}{
+		{name: "fewer buckets", countPerBucket: []int64{1, 2}, want: []int64{1, 2, 0, 0}},
+		{name: "more buckets", countPerBucket: []int64{1, 2, 3, 4, 5}, want: []int64{1, 2, 3, 9}},
+		{name: "matching buckets", countPerBucket: []int64{1, 2, 3, 4}, want: []int64{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		vd := &view.Data{
+			View: v,
+			Rows: []*view.Row{{Data: &view.DistributionData{Count: 15, CountPerBucket: tt.countPerBucket}}},
+		}
+
+		var mismatches [][2]int
+		opts := &MetricsConversionOptions{
+			BucketMismatchHandler: func(mv *view.View, got, want int) {
+				if mv != v {
+					t.Errorf("%s: handler called with view %v", tt.name, mv)
+				}
+				mismatches = append(mismatches, [2]int{got, want})
+			},
+		}
+		metric, err := viewDataToMetric(vd, opts)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		dv := metric.Timeseries[0].Points[0].GetDistributionValue()
+		var got []int64
+		for _, bucket := range dv.Buckets {
+			got = append(got, bucket.Count)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: bucket counts: got %v want %v", tt.name, got, tt.want)
+		}
+		if g, w := len(dv.Buckets), len(dv.BucketOptions.GetExplicit().Bounds)+1; g != w {
+			t.Errorf("%s: got %d buckets for %d bounds", tt.name, g, w-1)
+		}
+
+		wantMismatches := 0
+		if len(tt.countPerBucket) != len(tt.want) {
+			wantMismatches = 1
+		}
+		if len(mismatches) != wantMismatches {
+			t.Errorf("%s: handler called %d times, want %d", tt.name, len(mismatches), wantMismatches)
+		} else if wantMismatches == 1 && mismatches[0] != [2]int{len(tt.countPerBucket), len(tt.want)} {
+			t.Errorf("%s: handler got %v", tt.name, mismatches[0])
+		}
+	}
+}
+
+func TestViewDataToMetrics_CompactIntegralDoubles(t *testing.T) {
+	newViewData := func(values ...float64) *view.Data {
+		vd := &view.Data{
+			View: &view.View{
+				Name:        "ocagent.io/latency",
+				Aggregation: view.LastValue(),
+				TagKeys:     []tag.Key{keyName},
+				Measure:     mSprinterLatencyMs,
+			},
+		}
+		for i, value := range values {
+			vd.Rows = append(vd.Rows, &view.Row{
+				Tags: []tag.Tag{{Key: keyName, Value: strconv.Itoa(i)}},
+				Data: &view.LastValueData{Value: value},
+			})
+		}
+		return vd
+	}
+	opts := &MetricsConversionOptions{CompactIntegralDoubles: true}
+
+	metric, err := viewDataToMetric(newViewData(10, -3, 0), opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := metric.MetricDescriptor.Type, metricspb.MetricDescriptor_GAUGE_INT64; g != w {
+		t.Errorf("Integral values: descriptor type got %v want %v", g, w)
+	}
+	var got []int64
+	for _, ts := range metric.Timeseries {
+		pv, ok := ts.Points[0].Value.(*metricspb.Point_Int64Value)
+		if !ok {
+			t.Fatalf("Integral values: got point value %T", ts.Points[0].Value)
+		}
+		got = append(got, pv.Int64Value)
+	}
+	if want := []int64{10, -3, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Integral values: got %v want %v", got, want)
+	}
+
+	// A single fractional value keeps the whole metric as DOUBLE.
+	metric, err = viewDataToMetric(newViewData(10, 2.5, 0), opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := metric.MetricDescriptor.Type, metricspb.MetricDescriptor_GAUGE_DOUBLE; g != w {
+		t.Errorf("Mixed values: descriptor type got %v want %v", g, w)
+	}
+	for i, ts := range metric.Timeseries {
+		if _, ok := ts.Points[0].Value.(*metricspb.Point_DoubleValue); !ok {
+			t.Errorf("Mixed values #%d: got point value %T", i, ts.Points[0].Value)
+		}
+	}
+
+	// Without the option, integral values are left as DOUBLE.
+	metric, err = viewDataToMetric(newViewData(10), new(MetricsConversionOptions))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := metric.MetricDescriptor.Type, metricspb.MetricDescriptor_GAUGE_DOUBLE; g != w {
+		t.Errorf("Default: descriptor type got %v want %v", g, w)
+	}
+}
+
+func TestViewDataToMetrics_ClampPointsToStart(t *testing.T) {
+	start := time.Date(2019, time.January, 2, 3, 4, 5, 0, time.UTC)
+	end := start.Add(-time.Second)
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/fouls",
+			Aggregation: view.Count(),
+			Measure:     mFouls,
+		},
+		Start: start,
+		End:   end,
+		Rows:  []*view.Row{{Data: &view.CountData{Value: 3}}},
+	}
+
+	var clamped [][2]time.Time
+	opts := &MetricsConversionOptions{
+		ClampPointsToStart: true,
+		ClampedPointHandler: func(v *view.View, pointTime, startTime time.Time) {
+			if v != vd.View {
+				t.Errorf("Handler called with view %v", v)
+			}
+			clamped = append(clamped, [2]time.Time{pointTime, startTime})
+		},
+	}
+	metric, err := viewDataToMetric(vd, opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ts := metric.Timeseries[0]
+	if g, w := ProtoToTime(ts.Points[0].Timestamp), start; !g.Equal(w) {
+		t.Errorf("Point timestamp: got %v want %v", g, w)
+	}
+	if g, w := ts.Points[0].GetInt64Value(), int64(3); g != w {
+		t.Errorf("Point value: got %d want %d", g, w)
+	}
+	if len(clamped) != 1 || !clamped[0][0].Equal(end) || !clamped[0][1].Equal(start) {
+		t.Errorf("Handler got %v, want a single call with (%v, %v)", clamped, end, start)
+	}
+
+	// By default, the point is left as is.
+	metric, err = viewDataToMetric(vd, new(MetricsConversionOptions))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := ProtoToTime(metric.Timeseries[0].Points[0].Timestamp), end; !g.Equal(w) {
+		t.Errorf("Default point timestamp: got %v want %v", g, w)
+	}
+}
+
+func TestOpenCensusViewDataToProtoMetricsWithResources(t *testing.T) {
+	newViewData := func(name string) *view.Data {
+		return &view.Data{
+			View: &view.View{Name: name, Aggregation: view.Count(), Measure: mFouls},
+			Rows: []*view.Row{{Data: &view.CountData{Value: 1}}},
+		}
+	}
+	vdl := []*view.Data{newViewData("a"), newViewData("b"), newViewData("c"), newViewData("d")}
+	resources := []*resource.Resource{
+		{Type: "target", Labels: map[string]string{"instance": "10.0.0.1:9090"}},
+		{Type: "target", Labels: map[string]string{"instance": "10.0.0.2:9090"}},
+		nil,
+	}
+
+	req := OpenCensusViewDataToProtoMetricsWithResources(vdl, resources)
+	want := []*resourcepb.Resource{
+		{Type: "target", Labels: map[string]string{"instance": "10.0.0.1:9090"}},
+		{Type: "target", Labels: map[string]string{"instance": "10.0.0.2:9090"}},
+		nil,
+		nil,
+	}
+	if g, w := len(req.Metrics), len(want); g != w {
+		t.Fatalf("Number of metrics: got %d want %d", g, w)
+	}
+	for i, metric := range req.Metrics {
+		if g, w := metric.Resource, want[i]; !reflect.DeepEqual(g, w) {
+			t.Errorf("Metric %q: resource got %v want %v", metric.MetricDescriptor.Name, g, w)
+		}
+	}
+	if req.Resource != nil {
+		t.Errorf("Expected no request-level resource, got %v", req.Resource)
+	}
+}
+
+func TestLabelValuesFromTags(t *testing.T) {
+	tags := []tag.Tag{
+		{Key: keyName, Value: "first"},
+		{Key: keyField, Value: ""},
+		{Key: keyName, Value: "last"},
+	}
+
+	got := LabelValuesFromTags(tags, []tag.Key{keyField, keyPlayerName, keyName})
+	want := []*metricspb.LabelValue{
+		// Present, with the empty string.
+		{Value: "", HasValue: true},
+		// Absent.
+		{Value: "", HasValue: false},
+		// Duplicated, the last value wins.
+		{Value: "last", HasValue: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LabelValues mismatch\n\tGot  %v\n\tWant %v", got, want)
+	}
+
+	if got := LabelValuesFromTags(tags, nil); got != nil {
+		t.Errorf("Expected nil for no keys, got %v", got)
+	}
+	got = LabelValuesFromTags(nil, []tag.Key{keyName})
+	if want := []*metricspb.LabelValue{{}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("No tags: got %v want %v", got, want)
+	}
+}
+
+func TestOpenCensusViewDataToProtoMetrics_EmitConvertDuration(t *testing.T) {
+	vd := &view.Data{
+		View: &view.View{Name: "ocagent.io/fouls", Aggregation: view.Count(), Measure: mFouls},
+		Rows: []*view.Row{{Data: &view.CountData{Value: 1}}},
+	}
+
+	req := OpenCensusViewDataToProtoMetricsWithOptions([]*view.Data{vd}, &MetricsConversionOptions{EmitConvertDuration: true})
+	if g, w := len(req.Metrics), 2; g != w {
+		t.Fatalf("Number of metrics: got %d want %d", g, w)
+	}
+	metric := req.Metrics[1]
+	if g, w := metric.MetricDescriptor.Name, "scrape_duration_seconds"; g != w {
+		t.Errorf("Name: got %q want %q", g, w)
+	}
+	if g, w := metric.MetricDescriptor.Type, metricspb.MetricDescriptor_GAUGE_DOUBLE; g != w {
+		t.Errorf("Type: got %v want %v", g, w)
+	}
+	point := metric.Timeseries[0].Points[0]
+	if v, ok := point.Value.(*metricspb.Point_DoubleValue); !ok || v.DoubleValue < 0 {
+		t.Errorf("Expected a non-negative double value, got %v", point.Value)
+	}
+	if point.Timestamp == nil {
+		t.Error("Expected the point to have a timestamp")
+	}
+
+	req = OpenCensusViewDataToProtoMetrics([]*view.Data{vd})
+	if g, w := len(req.Metrics), 1; g != w {
+		t.Errorf("Default number of metrics: got %d want %d", g, w)
+	}
+}
+
+func TestViewDataToMetrics_PointAndStartTimestamps(t *testing.T) {
+	start := time.Date(2019, time.January, 2, 3, 4, 5, 600, time.UTC)
+	end := start.Add(17 * time.Second)
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/latency",
+			Aggregation: view.Distribution(0, 10, 20),
+			TagKeys:     []tag.Key{keyName},
+			Measure:     mSprinterLatencyMs,
+		},
+		Start: start,
+		End:   end,
+		Rows: []*view.Row{
+			{Tags: []tag.Tag{{Key: keyName, Value: "a"}}, Data: &view.DistributionData{Count: 1, Mean: 5, CountPerBucket: []int64{0, 1, 0, 0}}},
+			{Tags: []tag.Tag{{Key: keyName, Value: "b"}}, Data: &view.DistributionData{Count: 1, Mean: 15, CountPerBucket: []int64{0, 0, 1, 0}}},
+		},
+	}
+
+	metric, err := viewDataToMetric(vd, new(MetricsConversionOptions))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := metric.MetricDescriptor.Type, metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION; g != w {
+		t.Fatalf("Type: got %v want %v", g, w)
+	}
+	for i, ts := range metric.Timeseries {
+		if g := ProtoToTime(ts.StartTimestamp); !g.Equal(start) {
+			t.Errorf("#%d: StartTimestamp got %v want %v", i, g, start)
+		}
+		for j, point := range ts.Points {
+			if g := ProtoToTime(point.Timestamp); !g.Equal(end) {
+				t.Errorf("#%d.%d: point Timestamp got %v want %v", i, j, g, end)
+			}
+		}
+	}
+
+	// A zero start time is converted to a nil StartTimestamp, as on the span path.
+	vd.Start = time.Time{}
+	metric, err = viewDataToMetric(vd, new(MetricsConversionOptions))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, ts := range metric.Timeseries {
+		if ts.StartTimestamp != nil {
+			t.Errorf("#%d: expected a nil StartTimestamp for a zero start time, got %v", i, ts.StartTimestamp)
+		}
+	}
+}
+
+func TestOpenCensusViewDataToProtoMetricsNoResource(t *testing.T) {
+	vd := &view.Data{
+		View: &view.View{Name: "ocagent.io/fouls", Aggregation: view.Count(), Measure: mFouls},
+		Rows: []*view.Row{{Data: &view.CountData{Value: 1}}},
+	}
+
+	req := OpenCensusViewDataToProtoMetricsNoResource([]*view.Data{vd})
+	if req.Resource != nil {
+		t.Errorf("Expected no Resource, got %v", req.Resource)
+	}
+	if len(req.Metrics) != 1 || req.Metrics[0].Resource != nil {
+		t.Errorf("Expected a single metric without a Resource, got %v", req.Metrics)
+	}
+}
+
+func TestViewDataToMetrics_NearOverflow(t *testing.T) {
+	const threshold = math.MaxInt64 - 1000
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/fouls",
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{keyName},
+			Measure:     mFouls,
+		},
+		Rows: []*view.Row{
+			{Tags: []tag.Tag{{Key: keyName, Value: "near"}}, Data: &view.CountData{Value: math.MaxInt64 - 10}},
+			{Tags: []tag.Tag{{Key: keyName, Value: "far"}}, Data: &view.CountData{Value: 1 << 40}},
+			{Tags: []tag.Tag{{Key: keyName, Value: "max"}}, Data: &view.CountData{Value: math.MaxInt64}},
+		},
+	}
+
+	var reported []int64
+	opts := &MetricsConversionOptions{
+		NearOverflowThreshold: threshold,
+		NearOverflowHandler: func(v *view.View, value int64) {
+			reported = append(reported, value)
+		},
+	}
+	metric, err := viewDataToMetric(vd, opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var got []int64
+	for _, ts := range metric.Timeseries {
+		got = append(got, ts.Points[0].GetInt64Value())
+	}
+	if want := []int64{math.MaxInt64 - 10, 1 << 40, math.MaxInt64}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Values: got %v want %v", got, want)
+	}
+	if want := []int64{math.MaxInt64 - 10, math.MaxInt64}; !reflect.DeepEqual(reported, want) {
+		t.Errorf("Reported: got %v want %v", reported, want)
+	}
+
+	// Sums of int64 measures beyond the int64 range saturate.
+	sum := &view.Data{
+		View: &view.View{Name: "ocagent.io/fouls_sum", Aggregation: view.Sum(), Measure: mFouls},
+		Rows: []*view.Row{{Data: &view.SumData{Value: 2 * math.MaxInt64}}},
+	}
+	metric, err = viewDataToMetric(sum, new(MetricsConversionOptions))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := metric.Timeseries[0].Points[0].GetInt64Value(), int64(math.MaxInt64); g != w {
+		t.Errorf("Saturated sum: got %d want %d", g, w)
+	}
+}
+
+func TestViewDataToMetrics_CountAndSumPointTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		measure   stats.Measure
+		agg       *view.Aggregation
+		data      view.AggregationData
+		wantType  metricspb.MetricDescriptor_Type
+		wantValue interface{}
+	}{
+		{
+			name:      "count",
+			measure:   mSprinterLatencyMs,
+			agg:       view.Count(),
+			data:      &view.CountData{Value: 7},
+			wantType:  metricspb.MetricDescriptor_CUMULATIVE_INT64,
+			wantValue: &metricspb.Point_Int64Value{Int64Value: 7},
+		},
+		{
+			name:      "sum of float64 measure",
+			measure:   mSprinterLatencyMs,
+			agg:       view.Sum(),
+			data:      &view.SumData{Value: 12.5},
+			wantType:  metricspb.MetricDescriptor_CUMULATIVE_DOUBLE,
+			wantValue: &metricspb.Point_DoubleValue{DoubleValue: 12.5},
+		},
+		{
+			name:      "sum of int64 measure",
+			measure:   mFouls,
+			agg:       view.Sum(),
+			data:      &view.SumData{Value: 42},
+			wantType:  metricspb.MetricDescriptor_CUMULATIVE_INT64,
+			wantValue: &metricspb.Point_Int64Value{Int64Value: 42},
+		},
+	}
+
+	for _, tt := range tests {
+		vd := &view.Data{
+			View: &view.View{
+				Name:        "ocagent.io/" + tt.name,
+				Aggregation: tt.agg,
+				Measure:     tt.measure,
+			},
+			Rows: []*view.Row{{Data: tt.data}},
+		}
+		metric, err := viewDataToMetric(vd, new(MetricsConversionOptions))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if g, w := metric.MetricDescriptor.Type, tt.wantType; g != w {
+			t.Errorf("%s: descriptor type got %v want %v", tt.name, g, w)
+		}
+		if g := metric.Timeseries[0].Points[0].Value; !reflect.DeepEqual(g, tt.wantValue) {
+			t.Errorf("%s: point value got %#v want %#v", tt.name, g, tt.wantValue)
+		}
+	}
+}
+
+func TestViewDataToMetrics_LastValueGauges(t *testing.T) {
+	start := time.Date(2019, time.January, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		measure   stats.Measure
+		value     float64
+		wantType  metricspb.MetricDescriptor_Type
+		wantValue interface{}
+	}{
+		{
+			measure:   mFouls,
+			value:     9,
+			wantType:  metricspb.MetricDescriptor_GAUGE_INT64,
+			wantValue: &metricspb.Point_Int64Value{Int64Value: 9},
+		},
+		{
+			measure:   mSprinterLatencyMs,
+			value:     9.75,
+			wantType:  metricspb.MetricDescriptor_GAUGE_DOUBLE,
+			wantValue: &metricspb.Point_DoubleValue{DoubleValue: 9.75},
+		},
+	}
+
+	for _, tt := range tests {
+		vd := &view.Data{
+			Start: start,
+			End:   start.Add(time.Minute),
+			View: &view.View{
+				Name:        "ocagent.io/last_" + tt.measure.Name(),
+				Aggregation: view.LastValue(),
+				Measure:     tt.measure,
+			},
+			Rows: []*view.Row{{Data: &view.LastValueData{Value: tt.value}}},
+		}
+		metric, err := viewDataToMetric(vd, new(MetricsConversionOptions))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.measure.Name(), err)
+			continue
+		}
+		if g, w := metric.MetricDescriptor.Type, tt.wantType; g != w {
+			t.Errorf("%s: descriptor type got %v want %v", tt.measure.Name(), g, w)
+		}
+		ts := metric.Timeseries[0]
+		if ts.StartTimestamp != nil {
+			t.Errorf("%s: expected a nil StartTimestamp, got %v", tt.measure.Name(), ts.StartTimestamp)
+		}
+		if g := ts.Points[0].Value; !reflect.DeepEqual(g, tt.wantValue) {
+			t.Errorf("%s: point value got %#v want %#v", tt.measure.Name(), g, tt.wantValue)
+		}
+	}
+}
+
+func TestViewDataToMetrics_NormalizeLabelKeyCase(t *testing.T) {
+	keyHostUpper, _ := tag.NewKey("Host")
+	keyHostLower, _ := tag.NewKey("host")
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/requests",
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{keyHostUpper, keyHostLower},
+			Measure:     mFouls,
+		},
+		Rows: []*view.Row{
+			{Tags: []tag.Tag{{Key: keyHostUpper, Value: "a"}}, Data: &view.CountData{Value: 2}},
+			{Tags: []tag.Tag{{Key: keyHostLower, Value: "a"}}, Data: &view.CountData{Value: 3}},
+			{Tags: []tag.Tag{{Key: keyHostLower, Value: "b"}}, Data: &view.CountData{Value: 5}},
+		},
+	}
+
+	metric, err := viewDataToMetric(vd, &MetricsConversionOptions{NormalizeLabelKeyCase: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := metric.MetricDescriptor.LabelKeys, []*metricspb.LabelKey{{Key: "host"}}; !reflect.DeepEqual(g, w) {
+		t.Errorf("LabelKeys: got %v want %v", g, w)
+	}
+	want := []*metricspb.TimeSeries{
+		{
+			StartTimestamp: metric.Timeseries[0].StartTimestamp,
+			LabelValues:    []*metricspb.LabelValue{{Value: "a", HasValue: true}},
+			Points:         []*metricspb.Point{{Timestamp: metric.Timeseries[0].Points[0].Timestamp, Value: &metricspb.Point_Int64Value{Int64Value: 5}}},
+		},
+		{
+			StartTimestamp: metric.Timeseries[0].StartTimestamp,
+			LabelValues:    []*metricspb.LabelValue{{Value: "b", HasValue: true}},
+			Points:         []*metricspb.Point{{Timestamp: metric.Timeseries[0].Points[0].Timestamp, Value: &metricspb.Point_Int64Value{Int64Value: 5}}},
+		},
+	}
+	if !reflect.DeepEqual(metric.Timeseries, want) {
+		gj, _ := json.MarshalIndent(metric.Timeseries, "", "  ")
+		wj, _ := json.MarshalIndent(want, "", "  ")
+		t.Errorf("Timeseries mismatch:\nGot:\n%s\nWant:\n%s", gj, wj)
+	}
+
+	// Without the option, "Host" and "host" remain distinct.
+	metric, err = viewDataToMetric(vd, new(MetricsConversionOptions))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := len(metric.MetricDescriptor.LabelKeys), 2; g != w {
+		t.Errorf("Default LabelKeys: got %d want %d", g, w)
+	}
+	if g, w := len(metric.Timeseries), 3; g != w {
+		t.Errorf("Default Timeseries: got %d want %d", g, w)
+	}
+}
+
+func TestViewDataToMetrics_NormalizeLabelKeyCaseSaturates(t *testing.T) {
+	keyHostUpper, _ := tag.NewKey("Host")
+	keyHostLower, _ := tag.NewKey("host")
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/requests",
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{keyHostUpper, keyHostLower},
+			Measure:     mFouls,
+		},
+		Rows: []*view.Row{
+			{Tags: []tag.Tag{{Key: keyHostUpper, Value: "a"}}, Data: &view.CountData{Value: math.MaxInt64 - 1}},
+			{Tags: []tag.Tag{{Key: keyHostLower, Value: "a"}}, Data: &view.CountData{Value: 3}},
+		},
+	}
+
+	metric, err := viewDataToMetric(vd, &MetricsConversionOptions{NormalizeLabelKeyCase: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := metric.Timeseries[0].Points[0].GetInt64Value(), int64(math.MaxInt64); g != w {
+		t.Errorf("Merged value: got %d want %d", g, w)
+	}
+}
+
+func TestAddInt64(t *testing.T) {
+	tests := []struct {
+		a, b, want int64
+	}{
+		{a: 2, b: 3, want: 5},
+		{a: -2, b: 3, want: 1},
+		{a: math.MaxInt64, b: 1, want: math.MaxInt64},
+		{a: math.MaxInt64, b: math.MinInt64, want: -1},
+		{a: math.MinInt64, b: -1, want: math.MinInt64},
+	}
+	for _, tt := range tests {
+		if g := addInt64(tt.a, tt.b); g != tt.want {
+			t.Errorf("addInt64(%d, %d): got %d want %d", tt.a, tt.b, g, tt.want)
+		}
+	}
+}
+
+func TestMergeDistributionValues(t *testing.T) {
+	// Populations [1, 3] and [5].
+	a := &metricspb.DistributionValue{Count: 2, Sum: 4, SumOfSquaredDeviation: 2, Buckets: []*metricspb.DistributionValue_Bucket{{Count: 2}, {}}}
+	b := &metricspb.DistributionValue{Count: 1, Sum: 5, Buckets: []*metricspb.DistributionValue_Bucket{{}, {Count: 1}}}
+	got := mergeDistributionValues(a, b)
+	if got.Count != 3 || got.Sum != 9 {
+		t.Errorf("Got Count=%d Sum=%v want Count=3 Sum=9", got.Count, got.Sum)
+	}
+	// Mean of [1, 3, 5] is 3, hence the deviations are 4+0+4.
+	if g, w := got.SumOfSquaredDeviation, 8.0; math.Abs(g-w) > 1e-9 {
+		t.Errorf("SumOfSquaredDeviation: got %v want %v", g, w)
+	}
+	if g, w := got.Buckets, []*metricspb.DistributionValue_Bucket{{Count: 2}, {Count: 1}}; !reflect.DeepEqual(g, w) {
+		t.Errorf("Buckets: got %v want %v", g, w)
+	}
+}
+
+func TestViewDataToMetrics_LabelKeyOrder(t *testing.T) {
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/fouls",
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{keyField, keyName, keyPlayerName},
+			Measure:     mFouls,
+		},
+		Rows: []*view.Row{{
+			Tags: []tag.Tag{
+				{Key: keyField, Value: "main-field"},
+				{Key: keyName, Value: "sprinter-#10"},
+				{Key: keyPlayerName, Value: "player_1"},
+			},
+			Data: &view.CountData{Value: 1},
+		}},
+	}
+	opts := &MetricsConversionOptions{LabelKeyOrder: map[string][]string{
+		"ocagent.io/fouls": {"player_name", "team", "field"},
+	}}
+
+	metric, err := viewDataToMetric(vd, opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantKeys := []*metricspb.LabelKey{{Key: "player_name"}, {Key: "team"}, {Key: "field"}, {Key: "name"}}
+	if g := metric.MetricDescriptor.LabelKeys; !reflect.DeepEqual(g, wantKeys) {
+		t.Errorf("LabelKeys: got %v want %v", g, wantKeys)
+	}
+	wantValues := []*metricspb.LabelValue{
+		{Value: "player_1", HasValue: true},
+		{},
+		{Value: "main-field", HasValue: true},
+		{Value: "sprinter-#10", HasValue: true},
+	}
+	if g := metric.Timeseries[0].LabelValues; !reflect.DeepEqual(g, wantValues) {
+		t.Errorf("LabelValues: got %v want %v", g, wantValues)
+	}
+
+	// Metrics without an order keep their label keys as is.
+	vd.View.Name = "ocagent.io/unordered"
+	metric, err = viewDataToMetric(vd, opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := metric.MetricDescriptor.LabelKeys, tagKeysToLabelKeys(vd.View.TagKeys); !reflect.DeepEqual(g, w) {
+		t.Errorf("Unordered LabelKeys: got %v want %v", g, w)
+	}
+}
+
+func TestUnionTagKeys(t *testing.T) {
+	keyZone, _ := tag.NewKey("zone")
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/fouls",
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{keyName, keyField},
+			Measure:     mFouls,
+		},
+		Rows: []*view.Row{
+			{Tags: []tag.Tag{{Key: keyName, Value: "a"}, {Key: keyZone, Value: "z1"}}, Data: &view.CountData{Value: 1}},
+			{Tags: []tag.Tag{{Key: keyPlayerName, Value: "p"}, {Key: keyField, Value: "f"}}, Data: &view.CountData{Value: 2}},
+		},
+	}
+
+	if g, w := UnionTagKeys(vd), []tag.Key{keyField, keyName, keyPlayerName, keyZone}; !reflect.DeepEqual(g, w) {
+		t.Errorf("UnionTagKeys: got %v want %v", g, w)
+	}
+	if g := UnionTagKeys(nil); g != nil {
+		t.Errorf("Nil view data: got %v", g)
+	}
+
+	metric, err := viewDataToMetric(vd, new(MetricsConversionOptions))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	wantKeys := []*metricspb.LabelKey{{Key: "field"}, {Key: "name"}, {Key: "player_name"}, {Key: "zone"}}
+	if g := metric.MetricDescriptor.LabelKeys; !reflect.DeepEqual(g, wantKeys) {
+		t.Errorf("LabelKeys: got %v want %v", g, wantKeys)
+	}
+	wantValues := [][]*metricspb.LabelValue{
+		{{}, {Value: "a", HasValue: true}, {}, {Value: "z1", HasValue: true}},
+		{{Value: "f", HasValue: true}, {}, {Value: "p", HasValue: true}, {}},
+	}
+	for i, ts := range metric.Timeseries {
+		if !reflect.DeepEqual(ts.LabelValues, wantValues[i]) {
+			t.Errorf("Row #%d: LabelValues got %v want %v", i, ts.LabelValues, wantValues[i])
+		}
+	}
+}
+
+func TestViewDataToMetrics_DistributionWithoutBucketsAsSummary(t *testing.T) {
+	newViewData := func(bounds ...float64) *view.Data {
+		return &view.Data{
+			View: &view.View{
+				Name:        "ocagent.io/latency",
+				Aggregation: view.Distribution(bounds...),
+				Measure:     mSprinterLatencyMs,
+			},
+			Rows: []*view.Row{{
+				Data: &view.DistributionData{
+					Count:          4,
+					Mean:           2.5,
+					CountPerBucket: make([]int64, len(bounds)+1),
+				},
+			}},
+		}
+	}
+	opts := &MetricsConversionOptions{DistributionWithoutBucketsAsSummary: true}
+
+	metric, err := viewDataToMetric(newViewData(), opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := metric.MetricDescriptor.Type, metricspb.MetricDescriptor_SUMMARY; g != w {
+		t.Errorf("Descriptor type: got %v want %v", g, w)
+	}
+	sv := metric.Timeseries[0].Points[0].GetSummaryValue()
+	if sv == nil {
+		t.Fatalf("Expected a SummaryValue, got %T", metric.Timeseries[0].Points[0].Value)
+	}
+	if sv.GetCount().GetValue() != 4 || sv.GetSum().GetValue() != 10 {
+		t.Errorf("Got count %v and sum %v want 4 and 10", sv.Count, sv.Sum)
+	}
+	if sv.Snapshot != nil {
+		t.Errorf("Expected no snapshot, got %v", sv.Snapshot)
+	}
+
+	// Distributions with buckets are left as is.
+	metric, err = viewDataToMetric(newViewData(1, 2), opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g, w := metric.MetricDescriptor.Type, metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION; g != w {
+		t.Errorf("With buckets: descriptor type got %v want %v", g, w)
+	}
+	if metric.Timeseries[0].Points[0].GetDistributionValue() == nil {
+		t.Errorf("With buckets: expected a DistributionValue, got %T", metric.Timeseries[0].Points[0].Value)
+	}
+}
+
+func TestOpenCensusViewDataToProtoMetricsAsGauge(t *testing.T) {
+	start := time.Date(2019, time.January, 2, 3, 4, 5, 0, time.UTC)
+	vd := &view.Data{
+		Start: start,
+		End:   start.Add(time.Minute),
+		View: &view.View{
+			Name:        "ocagent.io/queue_wait",
+			Aggregation: view.Distribution(10, 20),
+			Measure:     mSprinterLatencyMs,
+		},
+		Rows: []*view.Row{{
+			Data: &view.DistributionData{Count: 6, Mean: 15, CountPerBucket: []int64{1, 3, 2}},
+		}},
+	}
+
+	req := OpenCensusViewDataToProtoMetricsAsGauge([]*view.Data{vd})
+	metric := req.Metrics[0]
+	if g, w := metric.MetricDescriptor.Type, metricspb.MetricDescriptor_GAUGE_DISTRIBUTION; g != w {
+		t.Errorf("Descriptor type: got %v want %v", g, w)
+	}
+	ts := metric.Timeseries[0]
+	if ts.StartTimestamp != nil {
+		t.Errorf("Expected a nil StartTimestamp, got %v", ts.StartTimestamp)
+	}
+	wantBuckets := []*metricspb.DistributionValue_Bucket{{Count: 1}, {Count: 3}, {Count: 2}}
+	if g := ts.Points[0].GetDistributionValue().GetBuckets(); !reflect.DeepEqual(g, wantBuckets) {
+		t.Errorf("Buckets: got %v want %v", g, wantBuckets)
+	}
+
+	// By default, distributions are cumulative.
+	metric = OpenCensusViewDataToProtoMetrics([]*view.Data{vd}).Metrics[0]
+	if g, w := metric.MetricDescriptor.Type, metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION; g != w {
+		t.Errorf("Default descriptor type: got %v want %v", g, w)
+	}
+	if metric.Timeseries[0].StartTimestamp == nil {
+		t.Error("Default: expected a StartTimestamp")
+	}
+}
+
+func TestOpenCensusViewDataToProtoMetrics_ExplodeDistributions(t *testing.T) {
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/latency",
+			Aggregation: view.Distribution(10),
+			TagKeys:     []tag.Key{keyName},
+			Measure:     mSprinterLatencyMs,
+		},
+		Rows: []*view.Row{
+			{Tags: []tag.Tag{{Key: keyName, Value: "a"}}, Data: &view.DistributionData{Count: 2, Mean: 7.5, CountPerBucket: []int64{1, 1}}},
+			{Tags: []tag.Tag{{Key: keyName, Value: "b"}}, Data: &view.DistributionData{Count: 1, Mean: 3, CountPerBucket: []int64{1, 0}}},
+		},
+	}
+
+	req := OpenCensusViewDataToProtoMetricsWithOptions([]*view.Data{vd}, &MetricsConversionOptions{ExplodeDistributions: true})
+	if g, w := len(req.Metrics), 3; g != w {
+		t.Fatalf("Got %d metrics want %d", g, w)
+	}
+	distribution, sumMetric, countMetric := req.Metrics[0], req.Metrics[1], req.Metrics[2]
+	if g, w := distribution.MetricDescriptor.Type, metricspb.MetricDescriptor_CUMULATIVE_DISTRIBUTION; g != w {
+		t.Errorf("Distribution type: got %v want %v", g, w)
+	}
+
+	if g, w := sumMetric.MetricDescriptor.Name, "ocagent.io/latency_sum"; g != w {
+		t.Errorf("Sum name: got %q want %q", g, w)
+	}
+	if g, w := sumMetric.MetricDescriptor.Type, metricspb.MetricDescriptor_GAUGE_DOUBLE; g != w {
+		t.Errorf("Sum type: got %v want %v", g, w)
+	}
+	if g, w := countMetric.MetricDescriptor.Name, "ocagent.io/latency_count"; g != w {
+		t.Errorf("Count name: got %q want %q", g, w)
+	}
+	if g, w := countMetric.MetricDescriptor.Type, metricspb.MetricDescriptor_GAUGE_INT64; g != w {
+		t.Errorf("Count type: got %v want %v", g, w)
+	}
+
+	wantSums, wantCounts := []float64{15, 3}, []int64{2, 1}
+	for i := range distribution.Timeseries {
+		if g, w := sumMetric.Timeseries[i].LabelValues, distribution.Timeseries[i].LabelValues; !reflect.DeepEqual(g, w) {
+			t.Errorf("Sum #%d: LabelValues got %v want %v", i, g, w)
+		}
+		if g, w := sumMetric.Timeseries[i].Points[0].GetDoubleValue(), wantSums[i]; g != w {
+			t.Errorf("Sum #%d: got %v want %v", i, g, w)
+		}
+		if g, w := countMetric.Timeseries[i].Points[0].GetInt64Value(), wantCounts[i]; g != w {
+			t.Errorf("Count #%d: got %v want %v", i, g, w)
+		}
+	}
+
+	// By default, there are no companion metrics.
+	if g := len(OpenCensusViewDataToProtoMetrics([]*view.Data{vd}).Metrics); g != 1 {
+		t.Errorf("Default: got %d metrics want 1", g)
+	}
+}
+
+func TestDedupeTimeSeries(t *testing.T) {
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/fouls",
+			Aggregation: view.Count(),
+			TagKeys:     []tag.Key{keyName},
+			Measure:     mFouls,
+		},
+		Rows: []*view.Row{
+			{Tags: []tag.Tag{{Key: keyName, Value: "a"}}, Data: &view.CountData{Value: 1}},
+			{Tags: []tag.Tag{{Key: keyName, Value: "a"}}, Data: &view.CountData{Value: 2}},
+			{Tags: []tag.Tag{{Key: keyName, Value: ""}}, Data: &view.CountData{Value: 3}},
+			{Data: &view.CountData{Value: 4}},
+		},
+	}
+
+	metric := OpenCensusViewDataToProtoMetrics([]*view.Data{vd}).Metrics[0]
+	if g, w := len(metric.Timeseries), 4; g != w {
+		t.Fatalf("Default: got %d timeseries want %d", g, w)
+	}
+	if g, w := DedupeTimeSeries(metric), 1; g != w {
+		t.Errorf("Removed: got %d want %d", g, w)
+	}
+	var values []int64
+	for _, ts := range metric.Timeseries {
+		values = append(values, ts.Points[0].GetInt64Value())
+	}
+	// The first "a" timeseries is kept, and the missing label value
+	// isn't a duplicate of the empty one.
+	if want := []int64{1, 3, 4}; !reflect.DeepEqual(values, want) {
+		t.Errorf("Kept point values: got %v want %v", values, want)
+	}
+
+	metric = OpenCensusViewDataToProtoMetricsDeduped([]*view.Data{vd}).Metrics[0]
+	if g, w := len(metric.Timeseries), 3; g != w {
+		t.Errorf("Deduped conversion: got %d timeseries want %d", g, w)
+	}
+
+	if g := DedupeTimeSeries(nil); g != 0 {
+		t.Errorf("Nil metric: got %d removed", g)
+	}
+}
+
+func TestViewDataToMetrics_ExemplarAttachments(t *testing.T) {
+	recorded := time.Date(2019, time.January, 2, 3, 4, 5, 0, time.UTC)
+	spanContext := trace.SpanContext{SpanID: trace.SpanID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}}
+	vd := &view.Data{
+		View: &view.View{
+			Name:        "ocagent.io/latency",
+			Aggregation: view.Distribution(10),
+			Measure:     mSprinterLatencyMs,
+		},
+		Rows: []*view.Row{{
+			Data: &view.DistributionData{
+				Count:          2,
+				Mean:           10,
+				CountPerBucket: []int64{1, 1},
+				ExemplarsPerBucket: []*metricdata.Exemplar{
+					{Value: 5, Timestamp: recorded, Attachments: metricdata.Attachments{
+						"span_id": "0102030405060708",
+						"context": spanContext,
+					}},
+					{Value: 15},
+				},
+			},
+		}},
+	}
+
+	metric, err := viewDataToMetric(vd, new(MetricsConversionOptions))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	buckets := metric.Timeseries[0].Points[0].GetDistributionValue().GetBuckets()
+	want := &metricspb.DistributionValue_Exemplar{
+		Value:     5,
+		Timestamp: TimeToProto(recorded),
+		Attachments: map[string]string{
+			"span_id": "0102030405060708",
+			"context": fmt.Sprint(spanContext),
+		},
+	}
+	if g := buckets[0].Exemplar; !reflect.DeepEqual(g, want) {
+		t.Errorf("Exemplar #0: got %v want %v", g, want)
+	}
+	if g := buckets[1].Exemplar; g == nil || g.Value != 15 || g.Attachments != nil {
+		t.Errorf("Exemplar #1: got %v want a value of 15 and nil attachments", g)
+	}
+}
+
+func TestViewDataToMetrics_ValueScale(t *testing.T) {
+	newViewData := func(name string, measure stats.Measure, value float64) *view.Data {
+		return &view.Data{
+			View: &view.View{
+				Name:        name,
+				Aggregation: view.Sum(),
+				Measure:     measure,
+			},
+			Rows: []*view.Row{{Data: &view.SumData{Value: value}}},
+		}
+	}
+	mBytes := stats.Float64("bytes", "The number of bytes received", stats.UnitBytes)
+	opts := &MetricsConversionOptions{ValueScale: map[string]float64{
+		"ocagent.io/received": 0.001,
+		"ocagent.io/fouls":    0.001,
+	}}
+
+	tests := []struct {
+		vd   *view.Data
+		want interface{}
+	}{
+		{vd: newViewData("ocagent.io/received", mBytes, 2500), want: &metricspb.Point_DoubleValue{DoubleValue: 2.5}},
+		// Metrics not in ValueScale aren't scaled.
+		{vd: newViewData("ocagent.io/sent", mBytes, 2500), want: &metricspb.Point_DoubleValue{DoubleValue: 2500}},
+		// INT64 points aren't scaled.
+		{vd: newViewData("ocagent.io/fouls", mFouls, 2500), want: &metricspb.Point_Int64Value{Int64Value: 2500}},
+	}
+	for _, tt := range tests {
+		metric, err := viewDataToMetric(tt.vd, opts)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.vd.View.Name, err)
+			continue
+		}
+		if g := metric.Timeseries[0].Points[0].Value; !reflect.DeepEqual(g, tt.want) {
+			t.Errorf("%s: got %v want %v", tt.vd.View.Name, g, tt.want)
+		}
+	}
+}
+
+func TestOpenCensusViewDataToProtoMetrics_MeasureDescriptionFallback(t *testing.T) {
+	start := time.Date(2019, time.January, 2, 3, 4, 5, 0, time.UTC)
+	vd := &view.Data{
+		Start: start,
+		End:   start.Add(time.Minute),
+		View: &view.View{
+			Name:        "ocagent.io/fouls",
+			Aggregation: view.Count(),
+			Measure:     mFouls,
+		},
+		Rows: []*view.Row{{Data: &view.CountData{Value: 3}}},
+	}
+
+	md := OpenCensusViewDataToProtoMetrics([]*view.Data{vd}).Metrics[0].MetricDescriptor
+	if g, w := md.Description, mFouls.Description(); g != w {
+		t.Errorf("Description: got %q want %q", g, w)
+	}
+
+	vd.View.Description = "The fouls per game"
+	md = OpenCensusViewDataToProtoMetrics([]*view.Data{vd}).Metrics[0].MetricDescriptor
+	if g, w := md.Description, "The fouls per game"; g != w {
+		t.Errorf("Description of the view: got %q want %q", g, w)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+)
+
+// UnmarshalError is returned by the JSON unmarshal helpers when
+// the payload can't be parsed as the expected message type.
+type UnmarshalError struct {
+	// MessageType is the fully qualified name of the expected message,
+	// such as "opencensus.proto.agent.trace.v1.ExportTraceServiceRequest".
+	MessageType string
+	Err         error
+}
+
+func (e *UnmarshalError) Error() string {
+	return fmt.Sprintf("unmarshaling %s from JSON: %v", e.MessageType, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *UnmarshalError) Unwrap() error {
+	return e.Err
+}
+
+// jsonUnmarshaler ignores unknown fields, so that payloads from
+// newer producers with additional fields still parse.
+var jsonUnmarshaler = &jsonpb.Unmarshaler{AllowUnknownFields: true}
+
+// UnmarshalTraceRequestJSON parses data, the jsonpb encoding of an
+// ExportTraceServiceRequest, ignoring unknown fields. Errors are of
+// type *UnmarshalError.
+func UnmarshalTraceRequestJSON(data []byte) (*agenttracepb.ExportTraceServiceRequest, error) {
+	req := new(agenttracepb.ExportTraceServiceRequest)
+	if err := unmarshalJSON(data, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// UnmarshalMetricsRequestJSON is like UnmarshalTraceRequestJSON,
+// for an ExportMetricsServiceRequest.
+func UnmarshalMetricsRequestJSON(data []byte) (*agentmetricspb.ExportMetricsServiceRequest, error) {
+	req := new(agentmetricspb.ExportMetricsServiceRequest)
+	if err := unmarshalJSON(data, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func unmarshalJSON(data []byte, msg proto.Message) error {
+	if err := jsonUnmarshaler.Unmarshal(bytes.NewReader(data), msg); err != nil {
+		return &UnmarshalError{MessageType: proto.MessageName(msg), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/orijtech/ocagent_structs_no_grpc"
+
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+func TestUnmarshalTraceRequestJSON_roundTrip(t *testing.T) {
+	want := &agenttracepb.ExportTraceServiceRequest{
+		Node: &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}},
+		Spans: []*tracepb.Span{{
+			TraceId: []byte{0x01, 0x02},
+			SpanId:  []byte{0x03},
+			Name:    &tracepb.TruncatableString{Value: "span"},
+			Kind:    tracepb.Span_SERVER,
+		}},
+	}
+	data, err := new(jsonpb.Marshaler).MarshalToString(want)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+
+	got, err := ocagent.UnmarshalTraceRequestJSON([]byte(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("Got %v want %v", got, want)
+	}
+}
+
+func TestUnmarshalMetricsRequestJSON_roundTrip(t *testing.T) {
+	want := &agentmetricspb.ExportMetricsServiceRequest{
+		Metrics: []*metricspb.Metric{{
+			MetricDescriptor: &metricspb.MetricDescriptor{
+				Name: "ocagent.io/calls",
+				Type: metricspb.MetricDescriptor_CUMULATIVE_INT64,
+			},
+			Timeseries: []*metricspb.TimeSeries{{
+				Points: []*metricspb.Point{{Value: &metricspb.Point_Int64Value{Int64Value: 7}}},
+			}},
+		}},
+	}
+	data, err := new(jsonpb.Marshaler).MarshalToString(want)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %v", err)
+	}
+
+	got, err := ocagent.UnmarshalMetricsRequestJSON([]byte(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !proto.Equal(got, want) {
+		t.Errorf("Got %v want %v", got, want)
+	}
+}
+
+func TestUnmarshalRequestJSON_unknownFields(t *testing.T) {
+	req, err := ocagent.UnmarshalTraceRequestJSON([]byte(`{"spans": [{"name": {"value": "a"}, "futureField": 1}], "futureField": true}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if g := req.Spans[0].Name.Value; g != "a" {
+		t.Errorf("Got span name %q", g)
+	}
+}
+
+func TestUnmarshalRequestJSON_errors(t *testing.T) {
+	_, err := ocagent.UnmarshalTraceRequestJSON([]byte(`{"spans": 1}`))
+	uerr, ok := err.(*ocagent.UnmarshalError)
+	if !ok {
+		t.Fatalf("Got error %v of type %T want *UnmarshalError", err, err)
+	}
+	if g, w := uerr.MessageType, "opencensus.proto.agent.trace.v1.ExportTraceServiceRequest"; g != w {
+		t.Errorf("MessageType: got %q want %q", g, w)
+	}
+
+	_, err = ocagent.UnmarshalMetricsRequestJSON([]byte(`not json`))
+	if err == nil || !strings.Contains(err.Error(), "ExportMetricsServiceRequest") {
+		t.Errorf("Expected an error identifying the metrics request, got %v", err)
+	}
+}
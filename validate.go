@@ -0,0 +1,122 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"errors"
+	"fmt"
+
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+)
+
+var (
+	errNilSummaryValue          = errors.New("expecting a non-nil SummaryValue")
+	errNegativeSummaryCount     = errors.New("summary count must be non-negative")
+	errNonZeroSumWithZeroCount  = errors.New("summary sum must be zero if count is zero")
+	errPercentilesWithZeroCount = errors.New("summary snapshot has percentile values but a zero count")
+	errNilNode                  = errors.New("expecting a non-nil Node")
+	errUnidentifiedNode         = errors.New("node has neither a service name nor an identifier")
+)
+
+// ValidateSummaryValue checks that sv obeys the constraints documented
+// on metricspb.SummaryValue, returning the first violation found.
+func ValidateSummaryValue(sv *metricspb.SummaryValue) error {
+	if sv == nil {
+		return errNilSummaryValue
+	}
+	if sv.Count != nil {
+		if sv.Count.Value < 0 {
+			return errNegativeSummaryCount
+		}
+		if sv.Count.Value == 0 && sv.Sum != nil && sv.Sum.Value != 0 {
+			return errNonZeroSumWithZeroCount
+		}
+	}
+
+	snapshot := sv.Snapshot
+	if snapshot == nil {
+		return nil
+	}
+	// Without any recorded value, the snapshot can't have percentiles either.
+	if sv.Count != nil && sv.Count.Value == 0 && len(snapshot.PercentileValues) > 0 {
+		return errPercentilesWithZeroCount
+	}
+	if snapshot.Count != nil {
+		if snapshot.Count.Value < 0 {
+			return errNegativeSummaryCount
+		}
+		if snapshot.Count.Value == 0 {
+			if snapshot.Sum != nil && snapshot.Sum.Value != 0 {
+				return errNonZeroSumWithZeroCount
+			}
+			if len(snapshot.PercentileValues) > 0 {
+				return errPercentilesWithZeroCount
+			}
+		}
+	}
+
+	var prev float64
+	for i, pv := range snapshot.PercentileValues {
+		if pv == nil {
+			return fmt.Errorf("summary snapshot percentile value #%d is nil", i)
+		}
+		if !isValidPercentile(pv.Percentile) {
+			return fmt.Errorf("summary snapshot percentile #%d: %v is not in the interval (0, 100]", i, pv.Percentile)
+		}
+		if i > 0 && pv.Percentile <= prev {
+			return fmt.Errorf("summary snapshot percentiles must be strictly increasing, got %v after %v", pv.Percentile, prev)
+		}
+		prev = pv.Percentile
+	}
+	return nil
+}
+
+// ValidateResourceConsistency returns an error for every span in req whose
+// Resource has a Type that conflicts with that of the request-level Resource,
+// since backends may then behave inconsistently. Spans without a Resource or
+// with an empty Type, as well as requests without a Resource, are consistent.
+func ValidateResourceConsistency(req *agenttracepb.ExportTraceServiceRequest) []error {
+	if req == nil || req.Resource == nil || req.Resource.Type == "" {
+		return nil
+	}
+
+	var errs []error
+	for i, span := range req.Spans {
+		if span == nil || span.Resource == nil || span.Resource.Type == "" {
+			continue
+		}
+		if span.Resource.Type != req.Resource.Type {
+			errs = append(errs, fmt.Errorf("span #%d: resource type %q conflicts with the request resource type %q",
+				i, span.Resource.Type, req.Resource.Type))
+		}
+	}
+	return errs
+}
+
+// ValidateNode checks that n identifies its sender as the agent requires,
+// that is that it has either a non-empty ServiceInfo.Name or an Identifier.
+// ProtoMetricsToRequestStrict and OpenCensusSpanDataToProtoSpansWithNode
+// apply it to the Node of the requests they build.
+func ValidateNode(n *commonpb.Node) error {
+	if n == nil {
+		return errNilNode
+	}
+	if n.GetServiceInfo().GetName() == "" && n.Identifier == nil {
+		return errUnidentifiedNode
+	}
+	return nil
+}
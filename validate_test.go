@@ -0,0 +1,159 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"math"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"go.opencensus.io/trace"
+
+	commonpb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/common/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+	resourcepb "github.com/orijtech/ocagent_structs_no_grpc/pb/resource/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+func TestValidateSummaryValue(t *testing.T) {
+	tests := []struct {
+		in      *metricspb.SummaryValue
+		wantErr error
+	}{
+		{in: nil, wantErr: errNilSummaryValue},
+		{
+			in: &metricspb.SummaryValue{
+				Count: &wrappers.Int64Value{Value: 2},
+				Sum:   &wrappers.DoubleValue{Value: 10},
+				Snapshot: &metricspb.SummaryValue_Snapshot{
+					Count: &wrappers.Int64Value{Value: 2},
+					Sum:   &wrappers.DoubleValue{Value: 10},
+					PercentileValues: []*metricspb.SummaryValue_Snapshot_ValueAtPercentile{
+						{Percentile: 50, Value: 4},
+						{Percentile: 100, Value: 6},
+					},
+				},
+			},
+		},
+		{
+			in: &metricspb.SummaryValue{
+				Snapshot: &metricspb.SummaryValue_Snapshot{
+					Count: &wrappers.Int64Value{Value: 0},
+					PercentileValues: []*metricspb.SummaryValue_Snapshot_ValueAtPercentile{
+						{Percentile: 99, Value: 4},
+					},
+				},
+			},
+			wantErr: errPercentilesWithZeroCount,
+		},
+		{
+			in: &metricspb.SummaryValue{
+				Count: &wrappers.Int64Value{Value: 0},
+				Snapshot: &metricspb.SummaryValue_Snapshot{
+					PercentileValues: []*metricspb.SummaryValue_Snapshot_ValueAtPercentile{
+						{Percentile: 99, Value: 4},
+					},
+				},
+			},
+			wantErr: errPercentilesWithZeroCount,
+		},
+		{
+			in: &metricspb.SummaryValue{
+				Count: &wrappers.Int64Value{Value: 0},
+				Snapshot: &metricspb.SummaryValue_Snapshot{
+					Count: &wrappers.Int64Value{Value: 3},
+					PercentileValues: []*metricspb.SummaryValue_Snapshot_ValueAtPercentile{
+						{Percentile: 99, Value: 4},
+					},
+				},
+			},
+			wantErr: errPercentilesWithZeroCount,
+		},
+	}
+
+	for i, tt := range tests {
+		if g, w := ValidateSummaryValue(tt.in), tt.wantErr; g != w {
+			t.Errorf("#%d: got error %v want %v", i, g, w)
+		}
+	}
+
+	nanPercentile := &metricspb.SummaryValue{
+		Snapshot: &metricspb.SummaryValue_Snapshot{
+			PercentileValues: []*metricspb.SummaryValue_Snapshot_ValueAtPercentile{
+				{Percentile: math.NaN(), Value: 4},
+			},
+		},
+	}
+	if err := ValidateSummaryValue(nanPercentile); err == nil {
+		t.Error("Expected an error for a NaN percentile")
+	}
+}
+
+func TestValidateResourceConsistency(t *testing.T) {
+	req := &agenttracepb.ExportTraceServiceRequest{
+		Resource: &resourcepb.Resource{Type: "k8s"},
+		Spans: []*tracepb.Span{
+			{},
+			{Resource: &resourcepb.Resource{Type: "k8s", Labels: map[string]string{"pod": "p1"}}},
+			{Resource: &resourcepb.Resource{Type: "gce_instance"}},
+		},
+	}
+
+	errs := ValidateResourceConsistency(req)
+	if g, w := len(errs), 1; g != w {
+		t.Fatalf("Number of errors: got %d want %d: %v", g, w, errs)
+	}
+	if g, w := errs[0].Error(), `span #2: resource type "gce_instance" conflicts with the request resource type "k8s"`; g != w {
+		t.Errorf("Error: got %q want %q", g, w)
+	}
+}
+
+func TestValidateNode(t *testing.T) {
+	tests := []struct {
+		in      *commonpb.Node
+		wantErr error
+	}{
+		{in: nil, wantErr: errNilNode},
+		{in: &commonpb.Node{}, wantErr: errUnidentifiedNode},
+		{in: &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{}}, wantErr: errUnidentifiedNode},
+		{in: &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}}},
+		{in: &commonpb.Node{Identifier: &commonpb.ProcessIdentifier{HostName: "h1", Pid: 42}}},
+	}
+	for i, tt := range tests {
+		if err := ValidateNode(tt.in); err != tt.wantErr {
+			t.Errorf("#%d: got error %v want %v", i, err, tt.wantErr)
+		}
+	}
+
+	if _, err := ProtoMetricsToRequestStrict(nil, &commonpb.Node{}, nil); err != errUnidentifiedNode {
+		t.Errorf("ProtoMetricsToRequestStrict: got error %v want %v", err, errUnidentifiedNode)
+	}
+	if req, err := ProtoMetricsToRequestStrict(nil, nil, nil); err != nil || req == nil {
+		t.Errorf("ProtoMetricsToRequestStrict without a node: got %v, %v", req, err)
+	}
+
+	sdl := []*trace.SpanData{{
+		SpanContext: trace.SpanContext{TraceID: trace.TraceID{0x01}, SpanID: trace.SpanID{0x01}},
+		Name:        "span",
+	}}
+	if _, err := OpenCensusSpanDataToProtoSpansWithNode(sdl, &commonpb.Node{}); err != errUnidentifiedNode {
+		t.Errorf("OpenCensusSpanDataToProtoSpansWithNode: got error %v want %v", err, errUnidentifiedNode)
+	}
+	node := &commonpb.Node{ServiceInfo: &commonpb.ServiceInfo{Name: "svc"}}
+	if req, err := OpenCensusSpanDataToProtoSpansWithNode(sdl, node); err != nil || req.Node != node || len(req.Spans) != 1 {
+		t.Errorf("OpenCensusSpanDataToProtoSpansWithNode with a valid node: got %v, %v", req, err)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent
+
+import (
+	"io"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+)
+
+// WriteTraceRequestJSON writes the jsonpb encoding of req directly to w,
+// without buffering it first.
+func WriteTraceRequestJSON(w io.Writer, req *agenttracepb.ExportTraceServiceRequest) error {
+	return new(jsonpb.Marshaler).Marshal(w, req)
+}
+
+// WriteTraceRequestProto writes the protobuf encoding of req to w in a single Write.
+func WriteTraceRequestProto(w io.Writer, req *agenttracepb.ExportTraceServiceRequest) error {
+	return writeProto(w, req)
+}
+
+// WriteMetricsRequestJSON writes the jsonpb encoding of req directly to w,
+// without buffering it first.
+func WriteMetricsRequestJSON(w io.Writer, req *agentmetricspb.ExportMetricsServiceRequest) error {
+	return new(jsonpb.Marshaler).Marshal(w, req)
+}
+
+// WriteMetricsRequestProto writes the protobuf encoding of req to w in a single Write.
+func WriteMetricsRequestProto(w io.Writer, req *agentmetricspb.ExportMetricsServiceRequest) error {
+	return writeProto(w, req)
+}
+
+func writeProto(w io.Writer, m proto.Message) error {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
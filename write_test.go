@@ -0,0 +1,94 @@
+// Copyright 2019, OpenCensus Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocagent_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/orijtech/ocagent_structs_no_grpc"
+
+	agentmetricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/metrics/v1"
+	agenttracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/agent/trace/v1"
+	metricspb "github.com/orijtech/ocagent_structs_no_grpc/pb/metrics/v1"
+	tracepb "github.com/orijtech/ocagent_structs_no_grpc/pb/trace/v1"
+)
+
+func TestWriteTraceRequest(t *testing.T) {
+	req := &agenttracepb.ExportTraceServiceRequest{
+		Spans: []*tracepb.Span{{Name: &tracepb.TruncatableString{Value: "span"}}},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := ocagent.WriteTraceRequestJSON(buf, req); err != nil {
+		t.Fatalf("WriteTraceRequestJSON: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Fatal("WriteTraceRequestJSON: wrote nothing")
+	}
+	fromJSON, err := ocagent.UnmarshalTraceRequestJSON(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to parse the JSON: %v", err)
+	}
+	if !proto.Equal(fromJSON, req) {
+		t.Errorf("JSON: got %v want %v", fromJSON, req)
+	}
+
+	buf.Reset()
+	if err := ocagent.WriteTraceRequestProto(buf, req); err != nil {
+		t.Fatalf("WriteTraceRequestProto: %v", err)
+	}
+	fromProto := new(agenttracepb.ExportTraceServiceRequest)
+	if err := proto.Unmarshal(buf.Bytes(), fromProto); err != nil {
+		t.Fatalf("Failed to parse the proto: %v", err)
+	}
+	if buf.Len() == 0 || !proto.Equal(fromProto, req) {
+		t.Errorf("Proto: got %v want %v", fromProto, req)
+	}
+}
+
+func TestWriteMetricsRequest(t *testing.T) {
+	req := &agentmetricspb.ExportMetricsServiceRequest{
+		Metrics: []*metricspb.Metric{{MetricDescriptor: &metricspb.MetricDescriptor{Name: "ocagent.io/calls"}}},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := ocagent.WriteMetricsRequestJSON(buf, req); err != nil {
+		t.Fatalf("WriteMetricsRequestJSON: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Fatal("WriteMetricsRequestJSON: wrote nothing")
+	}
+	fromJSON, err := ocagent.UnmarshalMetricsRequestJSON(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to parse the JSON: %v", err)
+	}
+	if !proto.Equal(fromJSON, req) {
+		t.Errorf("JSON: got %v want %v", fromJSON, req)
+	}
+
+	buf.Reset()
+	if err := ocagent.WriteMetricsRequestProto(buf, req); err != nil {
+		t.Fatalf("WriteMetricsRequestProto: %v", err)
+	}
+	fromProto := new(agentmetricspb.ExportMetricsServiceRequest)
+	if err := proto.Unmarshal(buf.Bytes(), fromProto); err != nil {
+		t.Fatalf("Failed to parse the proto: %v", err)
+	}
+	if buf.Len() == 0 || !proto.Equal(fromProto, req) {
+		t.Errorf("Proto: got %v want %v", fromProto, req)
+	}
+}